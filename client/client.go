@@ -0,0 +1,337 @@
+/*
+ * Client of the API served by core on NATS.
+ */
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/nats-io/nats"
+	"github.com/nats-io/nats/encoders/protobuf"
+)
+
+/*
+ * Error returned when core does not reply in time.
+ */
+var ErrTimeout = errors.New("core did not reply in time")
+
+/*
+ * Error returned when core replies to an operation with an error.
+ */
+type ResponseError struct {
+	Response *pb.NetResponse
+}
+
+func (err *ResponseError) Error() string {
+	return "core replied with an error : " + err.Response.Details
+}
+
+/*
+ * Error returned when the creation of a plugin failed. The response
+ * tells which step failed, or which fields of the request are invalid.
+ */
+type CreationError struct {
+	Response *pb.NewPluginResponse
+}
+
+func (err *CreationError) Error() string {
+	if err.Response.FailedStep != "" {
+		return "The creation of the plugin failed at step \"" + err.Response.FailedStep + "\" : " + err.Response.Error
+	}
+	return "The creation of the plugin failed : " + err.Response.Error
+}
+
+type Options struct {
+	/*
+	 * Time given to core to reply to a request, unless the context's
+	 * deadline is earlier.
+	 */
+	Timeout time.Duration
+
+	/*
+	 * Time given to core to create a plugin, the build being much
+	 * longer than the other operations.
+	 */
+	CreateTimeout time.Duration
+
+	/*
+	 * JSON Web Token sent with the requests, when core authenticates
+	 * them. The requests are then sent in a "RequestEnvelope".
+	 */
+	Token string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Timeout:       10 * time.Second,
+		CreateTimeout: 30 * time.Minute,
+	}
+}
+
+type Client struct {
+	connection *nats.EncodedConn
+	options    Options
+
+	/*
+	 * Whether or not the connection was opened by the client, and must
+	 * be closed with it.
+	 */
+	ownConnection bool
+}
+
+/*
+ * Returns a client sending its requests on the given connection, which
+ * must use the protobuf encoder.
+ */
+func New(connection *nats.EncodedConn, options Options) *Client {
+	return &Client{connection: connection, options: options}
+}
+
+/*
+ * Connects to the given NATS server and returns a client using this
+ * connection. The connection is closed with the client.
+ */
+func Connect(natsServerURL string, options Options) (*Client, error) {
+	connection, err := nats.Connect(natsServerURL, nats.Timeout(options.Timeout))
+	if err != nil {
+		return nil, err
+	}
+	encodedConnection, err := nats.NewEncodedConn(connection, protobuf.PROTOBUF_ENCODER)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+	client := New(encodedConnection, options)
+	client.ownConnection = true
+	return client, nil
+}
+
+/*
+ * Closes the connection if it was opened by the client.
+ */
+func (client *Client) Close() {
+	if client.ownConnection {
+		client.connection.Close()
+	}
+}
+
+func (client *Client) ListAvailable(ctx context.Context) ([]*pb.Plugin, error) {
+	plugins := &pb.Plugins{}
+	if err := client.request(ctx, pb.ListAvailablePluginsTopic, &pb.Empty{}, plugins, client.options.Timeout); err != nil {
+		return nil, err
+	}
+	return plugins.Plugins, nil
+}
+
+func (client *Client) ListInstalled(ctx context.Context) ([]*pb.Plugin, error) {
+	plugins := &pb.Plugins{}
+	if err := client.request(ctx, pb.ListInstalledPluginsTopic, &pb.Empty{}, plugins, client.options.Timeout); err != nil {
+		return nil, err
+	}
+	return plugins.Plugins, nil
+}
+
+/*
+ * Installs the given plugin, its container being run with the given
+ * command if it is not empty.
+ *
+ * A "ResponseError" is returned if core could not install the plugin.
+ */
+func (client *Client) Install(ctx context.Context, name string, cmd string) error {
+	request := &pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: name}, Cmd: cmd}
+	return client.operation(ctx, pb.InstallPluginTopic, request)
+}
+
+/*
+ * Uninstalls the given plugin.
+ *
+ * A "ResponseError" is returned if core could not uninstall the plugin.
+ */
+func (client *Client) Uninstall(ctx context.Context, name string) error {
+	return client.operation(ctx, pb.UninstallPluginTopic, &pb.Plugin{Name: name})
+}
+
+/*
+ * Creates a plugin. The build output is written to the given writer, if
+ * not nil, as it is streamed by core. A build identifier is set in the
+ * request if it has none.
+ *
+ * A "CreationError" holding the response is returned if the creation
+ * failed.
+ */
+func (client *Client) Create(ctx context.Context, request *pb.NewPluginRequest, output io.Writer) (*pb.NewPluginResponse, error) {
+	if request.BuildId == "" {
+		request.BuildId = newBuildID()
+	}
+
+	/*
+	 * Sequence of the last line received, and signal sent on each line.
+	 */
+	var received int64
+	progress := make(chan struct{}, 1)
+
+	if output != nil {
+		subscription, err := client.connection.Subscribe(pb.BuildLogTopic(request.BuildId), func(line *pb.BuildLogLine) {
+			io.WriteString(output, line.Line+"\n")
+			atomic.StoreInt64(&received, line.Sequence)
+			select {
+			case progress <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer subscription.Unsubscribe()
+	}
+
+	response := &pb.NewPluginResponse{}
+	if err := client.request(ctx, pb.CreatePlugin, request, response, client.options.CreateTimeout); err != nil {
+		return nil, err
+	}
+	if output != nil {
+		/*
+		 * The lines and the response may be published on different
+		 * connections of core : the lines still in flight are waited
+		 * for before unsubscribing.
+		 */
+		client.connection.Flush()
+		client.waitForLogLines(ctx, &received, progress, response.LogLines)
+	}
+	if !response.Status {
+		return response, &CreationError{Response: response}
+	}
+	return response, nil
+}
+
+/*
+ * Waits until the given number of build log lines were received, for
+ * at most the timeout of the requests.
+ */
+func (client *Client) waitForLogLines(ctx context.Context, received *int64, progress <-chan struct{}, count int64) {
+	timer := time.NewTimer(client.options.Timeout)
+	defer timer.Stop()
+	for atomic.LoadInt64(received) < count {
+		select {
+		case <-progress:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+/*
+ * Returns the last build log of the given plugin. The log is empty if
+ * the plugin has never been built.
+ */
+func (client *Client) BuildLog(ctx context.Context, name string) (*pb.BuildLog, error) {
+	buildLog := &pb.BuildLog{}
+	if err := client.request(ctx, pb.GetBuildLogTopic, &pb.NameRequest{Name: name}, buildLog, client.options.Timeout); err != nil {
+		return nil, err
+	}
+	return buildLog, nil
+}
+
+/*
+ * Sends a request replied to with a "NetResponse", converted to an error.
+ */
+func (client *Client) operation(ctx context.Context, topic string, request interface{}) error {
+	response := &pb.NetResponse{}
+	if err := client.request(ctx, topic, request, response, client.options.Timeout); err != nil {
+		return err
+	}
+	if response.Response != pb.Responses_ACK {
+		return &ResponseError{Response: response}
+	}
+	return nil
+}
+
+/*
+ * Sends a request and decodes the reply.
+ *
+ * The request fails when the context is done, or after the given
+ * timeout if the context has no earlier deadline.
+ */
+func (client *Client) request(ctx context.Context, topic string, request interface{}, response interface{}, timeout time.Duration) error {
+	deadlineFirst := false
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := deadline.Sub(time.Now()); remaining < timeout {
+			timeout = remaining
+			deadlineFirst = true
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return context.DeadlineExceeded
+	}
+
+	/*
+	 * When the context is done first, the reply may still be decoded
+	 * into the response : the callers discard it on error.
+	 */
+	reply := make(chan error, 1)
+	go func() {
+		reply <- client.send(topic, request, response, timeout)
+	}()
+
+	select {
+	case err := <-reply:
+		if err == nats.ErrTimeout {
+			/*
+			 * The request may time out just before the context's deadline.
+			 */
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if deadlineFirst {
+				return context.DeadlineExceeded
+			}
+			return ErrTimeout
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+ * Sends a request, in a "RequestEnvelope" if the client has a token.
+ *
+ * A rejection of core is returned as a "*pb.AuthError".
+ */
+func (client *Client) send(topic string, request interface{}, response interface{}, timeout time.Duration) error {
+	if client.options.Token == "" {
+		return client.connection.Request(topic, request, response, timeout)
+	}
+
+	payload, err := client.connection.Enc.Encode(topic, request)
+	if err != nil {
+		return err
+	}
+	envelope := &pb.ResponseEnvelope{}
+	err = client.connection.Request(topic, &pb.RequestEnvelope{Token: client.options.Token, Payload: payload}, envelope, timeout)
+	if err != nil {
+		return err
+	}
+	if envelope.Error != nil {
+		return envelope.Error
+	}
+	return client.connection.Enc.Decode(topic, envelope.Payload, response)
+}
+
+func newBuildID() string {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,270 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/eogile/agilestack-core/client"
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/gnatsd/server"
+	gnatsd "github.com/nats-io/gnatsd/test"
+	"github.com/nats-io/nats"
+	"github.com/nats-io/nats/encoders/protobuf"
+)
+
+var natsServerURL string
+
+/*
+ * Runs the tests against an embedded NATS server.
+ */
+func TestMain(m *testing.M) {
+	options := gnatsd.DefaultTestOptions
+	options.Port = server.RANDOM_PORT
+	natsServer := gnatsd.RunServer(&options)
+	natsServerURL = fmt.Sprintf("nats://%s", natsServer.Addr().String())
+
+	code := m.Run()
+	natsServer.Shutdown()
+	os.Exit(code)
+}
+
+/*
+ * Returns a connection playing the role of core, on which the tests
+ * subscribe to the topics.
+ */
+func newFakeCore(t *testing.T) *nats.EncodedConn {
+	connection, err := nats.Connect(natsServerURL)
+	if err != nil {
+		t.Fatalf("Error while connecting to the NATS server : %v", err)
+	}
+	core, err := nats.NewEncodedConn(connection, protobuf.PROTOBUF_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return core
+}
+
+func newClient(t *testing.T, core *nats.EncodedConn) *client.Client {
+	if err := core.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	options := client.DefaultOptions()
+	options.Timeout = 500 * time.Millisecond
+	testedClient, err := client.Connect(natsServerURL, options)
+	if err != nil {
+		t.Fatalf("Error while connecting the client : %v", err)
+	}
+	return testedClient
+}
+
+func TestListInstalled(t *testing.T) {
+	core := newFakeCore(t)
+	defer core.Close()
+	core.Subscribe(pb.ListInstalledPluginsTopic, func(_, reply string, _ *pb.Empty) {
+		core.Publish(reply, &pb.Plugins{Plugins: []*pb.Plugin{
+			{Name: "agilestack-todo", PluginStatus: pb.PluginStatus_OK},
+		}})
+	})
+	testedClient := newClient(t, core)
+	defer testedClient.Close()
+
+	plugins, err := testedClient.ListInstalled(context.Background())
+	if err != nil {
+		t.Fatalf("Error while listing the plugins : %v", err)
+	}
+	if len(plugins) != 1 || plugins[0].Name != "agilestack-todo" || plugins[0].PluginStatus != pb.PluginStatus_OK {
+		t.Errorf("Invalid plugins : %v", plugins)
+	}
+}
+
+/*
+ * Tests that an "ERROR" response is converted to a "ResponseError".
+ */
+func TestInstall(t *testing.T) {
+	core := newFakeCore(t)
+	defer core.Close()
+	core.Subscribe(pb.InstallPluginTopic, func(_, reply string, request *pb.InstallPluginRequest) {
+		if request.Plugin.Name == "agilestack-todo" && request.Cmd == "serve" {
+			core.Publish(reply, &pb.NetResponse{Response: pb.Responses_ACK})
+		} else {
+			core.Publish(reply, &pb.NetResponse{Response: pb.Responses_ERROR, Details: "No such image"})
+		}
+	})
+	testedClient := newClient(t, core)
+	defer testedClient.Close()
+
+	if err := testedClient.Install(context.Background(), "agilestack-todo", "serve"); err != nil {
+		t.Errorf("Error while installing the plugin : %v", err)
+	}
+
+	err := testedClient.Install(context.Background(), "agilestack-unknown", "")
+	responseError, ok := err.(*client.ResponseError)
+	if !ok || responseError.Response.Details != "No such image" {
+		t.Errorf("Invalid error : %v", err)
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	core := newFakeCore(t)
+	defer core.Close()
+	uninstalled := make(chan string, 1)
+	core.Subscribe(pb.UninstallPluginTopic, func(_, reply string, plugin *pb.Plugin) {
+		uninstalled <- plugin.Name
+		core.Publish(reply, &pb.NetResponse{Response: pb.Responses_ACK})
+	})
+	testedClient := newClient(t, core)
+	defer testedClient.Close()
+
+	if err := testedClient.Uninstall(context.Background(), "agilestack-todo"); err != nil {
+		t.Errorf("Error while uninstalling the plugin : %v", err)
+	}
+	if name := <-uninstalled; name != "agilestack-todo" {
+		t.Errorf("Invalid uninstalled plugin : %s", name)
+	}
+}
+
+/*
+ * Tests that the build output is written as it is streamed, and that
+ * a failed creation is converted to a "CreationError".
+ */
+func TestCreate(t *testing.T) {
+	core := newFakeCore(t)
+	defer core.Close()
+	core.Subscribe(pb.CreatePlugin, func(_, reply string, request *pb.NewPluginRequest) {
+		topic := pb.BuildLogTopic(request.BuildId)
+		core.Publish(topic, &pb.BuildLogLine{BuildId: request.BuildId, Sequence: 1, Line: "Step 1 : FROM node"})
+		core.Publish(topic, &pb.BuildLogLine{BuildId: request.BuildId, Sequence: 2, Line: "Error: no such image"})
+		core.Publish(reply, &pb.NewPluginResponse{
+			BuildId:    request.BuildId,
+			FailedStep: "FROM node",
+			Error:      "no such image",
+		})
+	})
+	testedClient := newClient(t, core)
+	defer testedClient.Close()
+
+	var output bytes.Buffer
+	request := &pb.NewPluginRequest{Name: "todo", Template: "default"}
+	response, err := testedClient.Create(context.Background(), request, &output)
+	if _, ok := err.(*client.CreationError); !ok {
+		t.Errorf("Invalid error : %v", err)
+	}
+	if response == nil || response.FailedStep != "FROM node" || response.BuildId != request.BuildId {
+		t.Errorf("Invalid response : %v", response)
+	}
+	if output.String() != "Step 1 : FROM node\nError: no such image\n" {
+		t.Errorf("Invalid build output : %q", output.String())
+	}
+}
+
+/*
+ * Tests that the build log lines published after the response are
+ * still written to the output.
+ */
+func TestCreateWaitsForLogLines(t *testing.T) {
+	core := newFakeCore(t)
+	defer core.Close()
+	logs := newFakeCore(t)
+	defer logs.Close()
+	core.Subscribe(pb.CreatePlugin, func(_, reply string, request *pb.NewPluginRequest) {
+		core.Publish(reply, &pb.NewPluginResponse{Status: true, BuildId: request.BuildId, LogLines: 2})
+		core.Flush()
+		time.Sleep(50 * time.Millisecond)
+		topic := pb.BuildLogTopic(request.BuildId)
+		logs.Publish(topic, &pb.BuildLogLine{BuildId: request.BuildId, Sequence: 1, Line: "Step 1 : FROM node"})
+		logs.Publish(topic, &pb.BuildLogLine{BuildId: request.BuildId, Sequence: 2, Line: "Successfully built"})
+	})
+	testedClient := newClient(t, core)
+	defer testedClient.Close()
+
+	var output bytes.Buffer
+	if _, err := testedClient.Create(context.Background(), &pb.NewPluginRequest{Name: "todo"}, &output); err != nil {
+		t.Fatalf("Error while creating the plugin : %v", err)
+	}
+	if output.String() != "Step 1 : FROM node\nSuccessfully built\n" {
+		t.Errorf("Invalid build output : %q", output.String())
+	}
+}
+
+/*
+ * Tests that a request without reply fails after the timeout, or when
+ * the context is done.
+ */
+func TestTimeoutAndCancellation(t *testing.T) {
+	core := newFakeCore(t)
+	defer core.Close()
+	testedClient := newClient(t, core)
+	defer testedClient.Close()
+
+	if _, err := testedClient.ListAvailable(context.Background()); err != client.ErrTimeout {
+		t.Errorf("The request should time out : %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := testedClient.ListAvailable(ctx); err != context.DeadlineExceeded {
+		t.Errorf("The request should fail with the context's deadline : %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := testedClient.Install(ctx, "agilestack-todo", ""); err != context.Canceled {
+		t.Errorf("The request should be cancelled : %v", err)
+	}
+}
+
+/*
+ * Tests that the requests are sent in an envelope holding the token,
+ * and that a rejection is returned as an "AuthError".
+ */
+func TestToken(t *testing.T) {
+	core := newFakeCore(t)
+	defer core.Close()
+	core.Subscribe(pb.UninstallPluginTopic, func(_, reply string, envelope *pb.RequestEnvelope) {
+		if envelope.Token != "operator-token" {
+			core.Publish(reply, &pb.ResponseEnvelope{Error: &pb.AuthError{
+				Code:         pb.ErrorCode_PERMISSION_DENIED,
+				Message:      "Denied",
+				RequiredRole: "operator",
+			}})
+			return
+		}
+		plugin := &pb.Plugin{}
+		if err := proto.Unmarshal(envelope.Payload, plugin); err != nil || plugin.Name != "agilestack-todo" {
+			t.Errorf("Invalid request : %v, %v", plugin, err)
+		}
+		payload, _ := proto.Marshal(&pb.NetResponse{Response: pb.Responses_ERROR, Details: "Not installed"})
+		core.Publish(reply, &pb.ResponseEnvelope{Payload: payload})
+	})
+	if err := core.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	options := client.DefaultOptions()
+	options.Token = "operator-token"
+	operator, err := client.Connect(natsServerURL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer operator.Close()
+	err = operator.Uninstall(context.Background(), "agilestack-todo")
+	if responseError, ok := err.(*client.ResponseError); !ok || responseError.Response.Details != "Not installed" {
+		t.Errorf("Invalid error : %v", err)
+	}
+
+	options.Token = "viewer-token"
+	viewer, err := client.Connect(natsServerURL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer viewer.Close()
+	err = viewer.Uninstall(context.Background(), "agilestack-todo")
+	if authErr, ok := err.(*pb.AuthError); !ok || authErr.RequiredRole != "operator" {
+		t.Errorf("Invalid error : %v", err)
+	}
+}
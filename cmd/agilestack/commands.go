@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/eogile/agilestack-core/client"
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+/*
+ * Prints the error of a request that did not succeed, and returns the
+ * exit code : the refused or failed operations are distinguished from
+ * the requests that could not reach core.
+ */
+func (app *cli) failure(err error) int {
+	switch err.(type) {
+	case *client.ResponseError, *client.CreationError, *pb.AuthError:
+		fmt.Fprintf(app.errors, "Error: %v\n", err)
+		return exitFailure
+	}
+	fmt.Fprintf(app.errors, "Error while sending the request to core : %v\n", err)
+	return exitUnavailable
+}
+
+/*
+ * Parses the flags of a subcommand and checks the number of its
+ * positional arguments. A negative maximum means no limit.
+ */
+func parseArguments(flags *flag.FlagSet, args []string, min int, max int) bool {
+	if err := flags.Parse(args); err != nil {
+		return false
+	}
+	if flags.NArg() < min || (max >= 0 && flags.NArg() > max) {
+		flags.Usage()
+		return false
+	}
+	return true
+}
+
+func runList(app *cli, flags *flag.FlagSet, args []string) int {
+	return listPlugins(app, flags, app.core.ListInstalled, args)
+}
+
+func runAvailable(app *cli, flags *flag.FlagSet, args []string) int {
+	return listPlugins(app, flags, app.core.ListAvailable, args)
+}
+
+func listPlugins(app *cli, flags *flag.FlagSet, list func(context.Context) ([]*pb.Plugin, error), args []string) int {
+	if !parseArguments(flags, args, 0, 0) {
+		return exitUsage
+	}
+
+	plugins, err := list(context.Background())
+	if err != nil {
+		return app.failure(err)
+	}
+	if app.json {
+		return printJSON(app.output, &pb.Plugins{Plugins: plugins})
+	}
+	printPlugins(app.output, plugins)
+	return exitOK
+}
+
+func runInstall(app *cli, flags *flag.FlagSet, args []string) int {
+	cmd := flags.String("cmd", "", "Command given to the plugin's container")
+	if !parseArguments(flags, args, 1, 1) {
+		return exitUsage
+	}
+
+	err := app.core.Install(context.Background(), flags.Arg(0), *cmd)
+	return app.printResult(err, "Plugin "+flags.Arg(0)+" installed.")
+}
+
+func runUninstall(app *cli, flags *flag.FlagSet, args []string) int {
+	if !parseArguments(flags, args, 1, 1) {
+		return exitUsage
+	}
+
+	err := app.core.Uninstall(context.Background(), flags.Arg(0))
+	return app.printResult(err, "Plugin "+flags.Arg(0)+" uninstalled.")
+}
+
+/*
+ * Prints the result of an operation replied to with a "NetResponse".
+ * In JSON mode, the response is printed.
+ */
+func (app *cli) printResult(err error, message string) int {
+	response := &pb.NetResponse{Response: pb.Responses_ACK}
+	if responseError, ok := err.(*client.ResponseError); ok {
+		response = responseError.Response
+	} else if err != nil {
+		return app.failure(err)
+	}
+
+	if app.json {
+		printJSON(app.output, response)
+	} else if response.Response == pb.Responses_ACK {
+		fmt.Fprintln(app.output, message)
+	} else {
+		fmt.Fprintln(app.errors, "Error: "+response.Details)
+	}
+	return netResponseExitCode(response)
+}
+
+func runCreate(app *cli, flags *flag.FlagSet, args []string) int {
+	request := &pb.NewPluginRequest{Parameters: map[string]string{}, BuildArgs: map[string]string{}}
+	flags.StringVar(&request.Url, "url", "", "URL of the plugin")
+	flags.StringVar(&request.Template, "template", "", "Template the plugin is generated from")
+	flags.Var(keyValues(request.Parameters), "param", "Parameter of the template, as KEY=VALUE (repeatable)")
+	flags.StringVar(&request.Directory, "dir", "", "Directory of the sources, on core's host")
+	flags.StringVar(&request.GitUrl, "git", "", "Git repository of the sources")
+	flags.StringVar(&request.GitRef, "git-ref", "", "Branch, tag or commit of the git repository")
+	flags.StringVar(&request.RemoteContext, "remote", "", "URL of a build context fetched by the Docker daemon")
+	flags.StringVar(&request.Tag, "tag", "", "Tag of the image")
+	flags.Var(keyValues(request.BuildArgs), "build-arg", "Build argument, as KEY=VALUE (repeatable)")
+	flags.StringVar(&request.Target, "target", "", "Stage of a multi-stage Dockerfile to build")
+	flags.BoolVar(&request.NoCache, "no-cache", false, "Do not use the build cache")
+	flags.BoolVar(&request.Pull, "pull", false, "Pull the base images even if they are present")
+	flags.BoolVar(&request.Push, "push", false, "Push the image to the registry configured in core")
+	if !parseArguments(flags, args, 1, 1) {
+		return exitUsage
+	}
+	request.Name = flags.Arg(0)
+
+	/*
+	 * The build output is printed as it is streamed. In JSON mode, it is
+	 * printed on the error output, so that the reply is the only output.
+	 */
+	logOutput := app.output
+	if app.json {
+		logOutput = app.errors
+	}
+	response, err := app.core.Create(context.Background(), request, logOutput)
+	if _, ok := err.(*client.CreationError); err != nil && !ok {
+		return app.failure(err)
+	}
+
+	if app.json {
+		printJSON(app.output, response)
+	} else if response.Status {
+		fmt.Fprintf(app.output, "Plugin %s created.\n", request.Name)
+	} else {
+		printCreationError(app.errors, response)
+	}
+	if !response.Status {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runLogs(app *cli, flags *flag.FlagSet, args []string) int {
+	if !parseArguments(flags, args, 1, 1) {
+		return exitUsage
+	}
+
+	buildLog, err := app.core.BuildLog(context.Background(), flags.Arg(0))
+	if err != nil {
+		return app.failure(err)
+	}
+	if app.json {
+		printJSON(app.output, buildLog)
+	} else if buildLog.BuildId == "" {
+		fmt.Fprintf(app.errors, "The plugin %s has never been built.\n", flags.Arg(0))
+	} else {
+		printBuildLog(app.output, buildLog)
+	}
+	if buildLog.BuildId == "" || !buildLog.Status {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runStatus(app *cli, flags *flag.FlagSet, args []string) int {
+	if !parseArguments(flags, args, 0, -1) {
+		return exitUsage
+	}
+
+	plugins, err := app.core.ListInstalled(context.Background())
+	if err != nil {
+		return app.failure(err)
+	}
+	if flags.NArg() > 0 {
+		plugins = selectPlugins(plugins, flags.Args())
+	}
+	if app.json {
+		printJSON(app.output, &pb.Plugins{Plugins: plugins})
+	} else {
+		printPlugins(app.output, plugins)
+	}
+	return statusExitCode(plugins)
+}
+
+/*
+ * Returns the given plugins among the installed ones. The plugins that
+ * are not installed are given the "NOTINSTALLED" status.
+ */
+func selectPlugins(installed []*pb.Plugin, names []string) []*pb.Plugin {
+	plugins := make([]*pb.Plugin, 0, len(names))
+	for _, name := range names {
+		plugin := &pb.Plugin{Name: name, PluginStatus: pb.PluginStatus_NOTINSTALLED}
+		for _, installedPlugin := range installed {
+			if installedPlugin.Name == name {
+				plugin = installedPlugin
+			}
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins
+}
+
+/*
+ * Returns the exit code of an operation replied to with the given response.
+ */
+func netResponseExitCode(response *pb.NetResponse) int {
+	if response.Response == pb.Responses_ACK {
+		return exitOK
+	}
+	return exitFailure
+}
+
+/*
+ * Returns a non-zero exit code if one of the given plugins is not OK.
+ */
+func statusExitCode(plugins []*pb.Plugin) int {
+	for _, plugin := range plugins {
+		if plugin.PluginStatus != pb.PluginStatus_OK {
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+/*
+ * Map filled by a repeatable "KEY=VALUE" flag.
+ */
+type keyValues map[string]string
+
+func (values keyValues) String() string {
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (values keyValues) Set(pair string) error {
+	index := strings.Index(pair, "=")
+	if index <= 0 {
+		return fmt.Errorf("Invalid value %q, expected KEY=VALUE", pair)
+	}
+	values[pair[:index]] = pair[index+1:]
+	return nil
+}
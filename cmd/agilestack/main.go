@@ -0,0 +1,153 @@
+/*
+ * Command-line client of core.
+ *
+ * It talks to core over NATS, with the messages of the "proto" package :
+ *
+ *   agilestack [-nats URL] [-timeout DURATION] [-build-timeout DURATION] [-json]
+ *              [-token TOKEN] COMMAND [ARGUMENTS]
+ *
+ * When core authenticates the requests, the token is given with -token or
+ * in $AGILESTACK_TOKEN.
+ *
+ * The exit code is 0 when the operation succeeded, 1 when core refused
+ * it or it failed, 2 on a usage error and 3 when core could not be
+ * reached.
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/eogile/agilestack-core/client"
+	"github.com/nats-io/nats"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+
+	/*
+	 * The NATS server could not be reached, or core did not reply in time.
+	 */
+	exitUnavailable = 3
+)
+
+/*
+ * Environment variable holding the token, so that it does not appear
+ * in the process list.
+ */
+const tokenVariable = "AGILESTACK_TOKEN"
+
+/*
+ * Subcommand of the app.
+ */
+type command struct {
+	name        string
+	arguments   string
+	description string
+	run         func(app *cli, flags *flag.FlagSet, args []string) int
+}
+
+var commands = []command{
+	{"list", "", "Lists the installed plugins", runList},
+	{"available", "", "Lists the plugins that can be installed", runAvailable},
+	{"install", "[-cmd COMMAND] NAME", "Installs a plugin", runInstall},
+	{"uninstall", "NAME", "Uninstalls a plugin", runUninstall},
+	{"create", "[OPTIONS] NAME", "Creates a plugin and streams its build output", runCreate},
+	{"logs", "NAME", "Prints the last build log of a plugin", runLogs},
+	{"status", "[NAME...]", "Prints the status of the installed plugins, failing if one is not OK", runStatus},
+}
+
+/*
+ * State shared by the subcommands.
+ */
+type cli struct {
+	core *client.Client
+	json bool
+
+	output io.Writer
+	errors io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("agilestack", flag.ContinueOnError)
+	natsURL := flags.String("nats", nats.DefaultURL, "URL of the NATS server")
+	timeout := flags.Duration("timeout", 10*time.Second, "Time given to core to reply")
+	buildTimeout := flags.Duration("build-timeout", 30*time.Minute, "Time given to core to create a plugin")
+	jsonOutput := flags.Bool("json", false, "Print the replies of core as JSON")
+	token := flags.String("token", "", "Token sent with the requests, read from $"+tokenVariable+" if not given")
+	flags.Usage = func() { usage(flags) }
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		usage(flags)
+		return exitUsage
+	}
+
+	var selected *command
+	for i := range commands {
+		if commands[i].name == flags.Arg(0) {
+			selected = &commands[i]
+		}
+	}
+	if selected == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command : %s\n", flags.Arg(0))
+		usage(flags)
+		return exitUsage
+	}
+
+	if *token == "" {
+		*token = os.Getenv(tokenVariable)
+	}
+	options := client.Options{Timeout: *timeout, CreateTimeout: *buildTimeout, Token: *token}
+	core, err := client.Connect(*natsURL, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while connecting to %s : %v\n", *natsURL, err)
+		return exitUnavailable
+	}
+	defer core.Close()
+
+	app := &cli{
+		core:   core,
+		json:   *jsonOutput,
+		output: os.Stdout,
+		errors: os.Stderr,
+	}
+	return selected.run(app, selected.flagSet(app), flags.Args()[1:])
+}
+
+/*
+ * Returns the set of the subcommand's flags, printing its usage on
+ * the client's error output.
+ */
+func (command *command) flagSet(app *cli) *flag.FlagSet {
+	flags := flag.NewFlagSet(command.name, flag.ContinueOnError)
+	flags.SetOutput(app.errors)
+	flags.Usage = func() {
+		fmt.Fprintf(app.errors, "Usage : agilestack %s %s\n", command.name, command.arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "Usage : agilestack [OPTIONS] COMMAND [ARGUMENTS]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands :")
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %-22s %s\n", command.name, command.arguments, command.description)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Options :")
+	flags.PrintDefaults()
+}
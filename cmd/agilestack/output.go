@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+/*
+ * Prints the plugins as a table, sorted by name.
+ */
+func printPlugins(output io.Writer, plugins []*pb.Plugin) {
+	sorted := make([]*pb.Plugin, len(plugins))
+	copy(sorted, plugins)
+	sort.Sort(byName(sorted))
+
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tSTATUS")
+	for _, plugin := range sorted {
+		fmt.Fprintf(writer, "%s\t%s\n", plugin.Name, plugin.PluginStatus)
+	}
+	writer.Flush()
+}
+
+/*
+ * Prints a build log. The error of a failed build is its last line.
+ */
+func printBuildLog(output io.Writer, buildLog *pb.BuildLog) {
+	status := "succeeded"
+	if !buildLog.Status && buildLog.FailedStep != "" {
+		status = fmt.Sprintf("failed at step %q", buildLog.FailedStep)
+	} else if !buildLog.Status {
+		status = "failed"
+	}
+	fmt.Fprintf(output, "Build %s of %s %s on %s\n", buildLog.BuildId, buildLog.Name, status,
+		time.Unix(buildLog.FinishedAt, 0).Format(time.RFC3339))
+	for _, line := range buildLog.Lines {
+		fmt.Fprintln(output, line)
+	}
+}
+
+func printCreationError(output io.Writer, response *pb.NewPluginResponse) {
+	if len(response.ValidationErrors) > 0 {
+		fmt.Fprintln(output, "Invalid request :")
+		for _, validationError := range response.ValidationErrors {
+			fmt.Fprintf(output, "  %s: %s\n", validationError.Field, validationError.Message)
+		}
+		return
+	}
+	if response.FailedStep != "" {
+		fmt.Fprintf(output, "Error at step %q: %s\n", response.FailedStep, response.Error)
+	} else {
+		fmt.Fprintf(output, "Error: %s\n", response.Error)
+	}
+}
+
+/*
+ * Prints the given message as indented JSON.
+ */
+func printJSON(output io.Writer, message interface{}) int {
+	content, err := json.MarshalIndent(message, "", "  ")
+	if err != nil {
+		fmt.Fprintf(output, "Error while encoding the reply : %v\n", err)
+		return exitFailure
+	}
+	fmt.Fprintln(output, string(content))
+	return exitOK
+}
+
+type byName []*pb.Plugin
+
+func (plugins byName) Len() int           { return len(plugins) }
+func (plugins byName) Swap(i, j int)      { plugins[i], plugins[j] = plugins[j], plugins[i] }
+func (plugins byName) Less(i, j int) bool { return plugins[i].Name < plugins[j].Name }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/eogile/agilestack-core/client"
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+func TestPrintPlugins(t *testing.T) {
+	var output bytes.Buffer
+	printPlugins(&output, []*pb.Plugin{
+		{Name: "agilestack-todo", PluginStatus: pb.PluginStatus_OK},
+		{Name: "agilestack-backoffice", PluginStatus: pb.PluginStatus_CURRENTLYNOTREACHABLE},
+	})
+
+	expected := "NAME                   STATUS\n" +
+		"agilestack-backoffice  CURRENTLYNOTREACHABLE\n" +
+		"agilestack-todo        OK\n"
+	if output.String() != expected {
+		t.Errorf("Invalid table :\n%s", output.String())
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	if code := netResponseExitCode(&pb.NetResponse{Response: pb.Responses_ACK}); code != exitOK {
+		t.Errorf("Invalid exit code for an ACK response : %d", code)
+	}
+	if code := netResponseExitCode(&pb.NetResponse{Response: pb.Responses_ERROR}); code != exitFailure {
+		t.Errorf("Invalid exit code for an ERROR response : %d", code)
+	}
+
+	installed := []*pb.Plugin{{Name: "agilestack-todo", PluginStatus: pb.PluginStatus_OK}}
+	if code := statusExitCode(selectPlugins(installed, []string{"agilestack-todo"})); code != exitOK {
+		t.Errorf("Invalid exit code for an installed plugin : %d", code)
+	}
+	if code := statusExitCode(selectPlugins(installed, []string{"agilestack-todo", "agilestack-other"})); code != exitFailure {
+		t.Errorf("Invalid exit code for a plugin that is not installed : %d", code)
+	}
+
+	var errors bytes.Buffer
+	app := &cli{output: &bytes.Buffer{}, errors: &errors}
+	rejection := &pb.AuthError{Code: pb.ErrorCode_PERMISSION_DENIED, Message: "Denied", RequiredRole: "operator"}
+	if code := app.failure(rejection); code != exitFailure {
+		t.Errorf("Invalid exit code for a rejected request : %d", code)
+	}
+	if errors.String() != "Error: Denied (required role : operator)\n" {
+		t.Errorf("Invalid error output : %q", errors.String())
+	}
+	if code := app.failure(client.ErrTimeout); code != exitUnavailable {
+		t.Errorf("Invalid exit code for a timeout : %d", code)
+	}
+}
+
+func TestKeyValues(t *testing.T) {
+	values := keyValues{}
+	for _, pair := range []string{"NODE_ENV=production", "EMPTY=", "URL=http://host/?a=b"} {
+		if err := values.Set(pair); err != nil {
+			t.Errorf("Error for %s : %v", pair, err)
+		}
+	}
+	expected := keyValues{"NODE_ENV": "production", "EMPTY": "", "URL": "http://host/?a=b"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Invalid values : %v", values)
+	}
+	if err := values.Set("=value"); err == nil {
+		t.Error("A pair without key should be rejected")
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{{}, {"unknown"}, {"-unknown-flag", "list"}} {
+		if code := run(args); code != exitUsage {
+			t.Errorf("Invalid exit code for %v : %d", args, code)
+		}
+	}
+}
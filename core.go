@@ -1,28 +1,210 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"io/ioutil"
 	"log"
-	"sync"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/eogile/agilestack-core/registry"
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc"
 )
 
+var (
+	natsServerURL = flag.String("nats", "http://nats.agilestacknet:4222",
+		"URL of the NATS server")
+	embeddedNatsAddress = flag.String("embedded-nats", "",
+		"Listen address of a NATS server run in the process, used instead of -nats (empty to use an external server)")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second,
+		"Time given to the requests in progress to complete on shutdown")
+	exitPolicy = flag.String("exit-policy", registry.DefaultSubscriberOptions().ExitPolicy.String(),
+		"What to do with the running plugins on shutdown : \"stop\" or \"keep\"")
+	natsConnectAttempts = flag.Int("nats-connect-attempts", 0,
+		"Maximum number of attempts to connect to the NATS server at startup (0 for no limit)")
+	healthAddress = flag.String("health-addr", ":8080",
+		"Listen address of the health and metrics endpoints (empty to disable them)")
+	grpcAddress = flag.String("grpc-addr", "",
+		"Listen address of the gRPC API (empty to disable it)")
+	apiAddress = flag.String("api-addr", "",
+		"Listen address of the REST API (empty to disable it)")
+	queueGroup = flag.String("queue-group", "agilestack-core",
+		"NATS queue group shared by the core replicas (empty to handle every request)")
+	leaderElection = flag.Bool("leader-election", false,
+		"Elect a leader among the core replicas to change the plugins, run the shutdown hooks and the garbage collection")
+	buildRoot = flag.String("build-root", registry.PLUGIN_BUILD_ROOT,
+		"Directory under which the plugins' build directories must be")
+	remoteContextHosts = flag.String("remote-context-hosts", "",
+		"Comma-separated hosts from which the remote build contexts may be downloaded (empty to forbid them)")
+	registryAddress = flag.String("registry", "",
+		"Address of the registry where the plugin images are pushed (empty to disable the push)")
+	registryUsername = flag.String("registry-username", "",
+		"User name on the registry, the password being read from $"+registryPasswordVariable)
+	gcInterval = flag.Duration("gc-interval", 6*time.Hour,
+		"Interval between two garbage collections (0 to only collect the garbage on demand)")
+	gcKeepVersions = flag.Int("gc-keep-versions", 3,
+		"Number of versions of each plugin's image kept by the garbage collection (0 to keep every version)")
+	gcContainerAge = flag.Duration("gc-container-age", 24*time.Hour,
+		"Minimum age of the stopped plugin containers removed by the garbage collection (0 to keep them)")
+	authPublicKey = flag.String("auth-rsa-public-key", "",
+		"PEM file of the RSA public key of the requesters' tokens, the HMAC secret being read from $"+authSecretVariable+
+			" (the requests are not authenticated when none is given)")
+	auditLogPath = flag.String("audit-log", "",
+		"File where the plugin administration actions are recorded, such as "+registry.AUDIT_LOG_PATH+
+			" (the audit log is disabled when none is given)")
+	auditLogMaxSize = flag.Int64("audit-log-max-size", registry.DefaultAuditOptions().MaxSize,
+		"Size in bytes above which the audit log is rotated")
+	auditLogBackups = flag.Int("audit-log-backups", registry.DefaultAuditOptions().MaxBackups,
+		"Number of rotated audit log files kept (0 to keep every file)")
+)
+
+/*
+ * Environment variable holding the registry password, so that it does not
+ * appear in the process list.
+ */
+const registryPasswordVariable = "AGILESTACK_REGISTRY_PASSWORD"
+
+/*
+ * Environment variable holding the secret of the HMAC-signed tokens.
+ */
+const authSecretVariable = "AGILESTACK_AUTH_HMAC_SECRET"
+
 func init() {
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
 }
 
 func main() {
+	flag.Parse()
 	log.Print("AT BEGINNING")
-	subscriber := registry.NewNatsSubscriber("http://nats.agilestacknet:4222")
-	subscriber.InitShutdownHook()
+
+	options := registry.DefaultSubscriberOptions()
+	policy, err := registry.ParseExitPolicy(*exitPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	options.ExitPolicy = policy
+	options.Connection.MaxAttempts = *natsConnectAttempts
+	options.QueueGroup = *queueGroup
+	options.LeaderElection = *leaderElection
+	options.BuildRoot = *buildRoot
+	if *remoteContextHosts != "" {
+		options.RemoteContextHosts = strings.Split(*remoteContextHosts, ",")
+	}
+	options.GCInterval = *gcInterval
+	options.GCPolicy.KeepVersions = *gcKeepVersions
+	options.GCPolicy.StoppedContainerAge = *gcContainerAge
+	options.Registry = registry.RegistryOptions{
+		Address:  *registryAddress,
+		Username: *registryUsername,
+		Password: os.Getenv(registryPasswordVariable),
+	}
+	options.Audit = registry.AuditOptions{
+		Path:       *auditLogPath,
+		MaxSize:    *auditLogMaxSize,
+		MaxBackups: *auditLogBackups,
+	}
+	options.Auth.HMACSecret = []byte(os.Getenv(authSecretVariable))
+	if *authPublicKey != "" {
+		pem, err := ioutil.ReadFile(*authPublicKey)
+		if err != nil {
+			log.Fatalf("Error while reading the public key : %v", err)
+		}
+		options.Auth.RSAPublicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			log.Fatalf("Invalid public key in %s : %v", *authPublicKey, err)
+		}
+	}
+
+	/*
+	 * The health endpoints are available while connecting to NATS,
+	 * core being reported as not ready.
+	 */
+	health := registry.NewHealthHandler()
+	if *healthAddress != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(*healthAddress, health))
+		}()
+	}
+
+	/*
+	 * The embedded NATS server is started before connecting to it,
+	 * and stopped after the subscriber.
+	 */
+	serverURL := *natsServerURL
+	if *embeddedNatsAddress != "" {
+		embeddedNats, err := registry.StartEmbeddedNatsServer(*embeddedNatsAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer embeddedNats.Shutdown()
+		serverURL = embeddedNats.URL()
+		log.Printf("Embedded NATS server listening on %s", serverURL)
+	}
+
+	subscriber, err := registry.NewNatsSubscriberWithOptions(serverURL, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	health.SetReadinessCheck(subscriber.IsReady)
+	health.SetMetricsHandler(registry.NewMetricsHandler(subscriber.StorageClient()))
+
+	var grpcServer *grpc.Server
+	var grpcService *registry.GrpcServer
+	if *grpcAddress != "" {
+		listener, err := net.Listen("tcp", *grpcAddress)
+		if err != nil {
+			log.Fatalf("Error while listening on %s : %v", *grpcAddress, err)
+		}
+		var serverOptions []grpc.ServerOption
+		if options.Auth.Enabled() {
+			serverOptions = registry.GrpcAuthOptions(options.Auth)
+		}
+		grpcServer = grpc.NewServer(serverOptions...)
+		grpcService = registry.NewGrpcServer(subscriber.Registry(), subscriber, subscriber.Events())
+		grpcService.SetAuditor(subscriber.Auditor())
+		grpcService.Register(grpcServer)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Printf("Error while serving the gRPC API : %v", err)
+			}
+		}()
+	}
+
+	if *apiAddress != "" {
+		gateway := registry.NewRestGateway(subscriber.Registry(), subscriber)
+		gateway.SetAuditor(subscriber.Auditor())
+		if options.Auth.Enabled() {
+			gateway.RequireTokens(options.Auth)
+		}
+		go func() {
+			log.Fatal(http.ListenAndServe(*apiAddress, gateway))
+		}()
+	}
 
 	log.Print("before server listening")
 
 	/*
-	 * Preventing the program to exit
+	 * Running until a termination signal is received.
 	 */
-	var waitGroup sync.WaitGroup
-	waitGroup.Add(1)
-	waitGroup.Wait()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Intercepting \"%v\" signal", <-signals)
 
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if grpcServer != nil {
+		grpcService.Close()
+		grpcServer.GracefulStop()
+	}
+	if err := subscriber.Shutdown(ctx); err != nil {
+		log.Printf("Error during shutdown : %v", err)
+	}
+	log.Print("Bye")
 }
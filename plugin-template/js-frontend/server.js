@@ -0,0 +1,37 @@
+'use strict';
+
+/*
+ * Serves the files of the "public" directory, and the configuration of
+ * the plugin as "/config.json".
+ */
+const fs = require('fs');
+const http = require('http');
+const path = require('path');
+
+const root = path.join(__dirname, 'public');
+const port = process.env.PORT || 8080;
+const contentTypes = {
+  '.css': 'text/css',
+  '.html': 'text/html',
+  '.js': 'application/javascript',
+  '.json': 'application/json'
+};
+
+http.createServer((request, response) => {
+  let file = decodeURIComponent(request.url.split('?')[0]);
+  if (file === '/config.json') {
+    file = path.join(__dirname, 'config.json');
+  } else {
+    file = path.join(root, path.normalize('/' + (file === '/' ? 'index.html' : file)));
+  }
+
+  fs.readFile(file, (err, content) => {
+    if (err) {
+      response.writeHead(404);
+      response.end('Not found');
+      return;
+    }
+    response.writeHead(200, {'Content-Type': contentTypes[path.extname(file)] || 'application/octet-stream'});
+    response.end(content);
+  });
+}).listen(port, () => console.log('Listening on port ' + port));
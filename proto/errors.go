@@ -0,0 +1,12 @@
+package proto
+
+/*
+ * The rejections of the authenticated requests are returned as errors
+ * by the clients.
+ */
+func (err *AuthError) Error() string {
+	if err.RequiredRole != "" {
+		return err.Message + " (required role : " + err.RequiredRole + ")"
+	}
+	return err.Message
+}
@@ -22,11 +22,47 @@ It has these top-level messages:
 	Pong
 	NewPluginRequest
 	NewPluginResponse
+	ValidationError
+	TemplateParameter
+	PluginTemplate
+	PluginTemplates
+	BuildLogLine
+	BuildLog
+	ArchiveChunk
+	PluginConfiguration
+	Route
+	MenuEntry
+	I18nResource
+	Permission
+	PluginConfigurationResponse
+	PushPluginRequest
+	PushPluginResponse
+	DeletePluginRequest
+	DeletePluginResponse
+	GarbageCollectionRequest
+	GarbageCollectionReport
+	PluginEvent
+	InstallRequest
+	UninstallRequest
+	OperationResponse
+	APIVersion
+	APIVersions
+	RequestEnvelope
+	ResponseEnvelope
+	AuthError
+	AuditEntry
+	AuditQuery
+	AuditEntries
 */
 package proto
 
 import proto1 "github.com/golang/protobuf/proto"
 
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+)
+
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto1.Marshal
 
@@ -73,6 +109,75 @@ func (x Responses) String() string {
 	return proto1.EnumName(Responses_name, int32(x))
 }
 
+type PluginEventType int32
+
+const (
+	PluginEventType_INSTALLED   PluginEventType = 0
+	PluginEventType_UNINSTALLED PluginEventType = 1
+	PluginEventType_CREATED     PluginEventType = 2
+	PluginEventType_DELETED     PluginEventType = 3
+)
+
+var PluginEventType_name = map[int32]string{
+	0: "INSTALLED",
+	1: "UNINSTALLED",
+	2: "CREATED",
+	3: "DELETED",
+}
+var PluginEventType_value = map[string]int32{
+	"INSTALLED":   0,
+	"UNINSTALLED": 1,
+	"CREATED":     2,
+	"DELETED":     3,
+}
+
+func (x PluginEventType) String() string {
+	return proto1.EnumName(PluginEventType_name, int32(x))
+}
+
+// Reason of the failure of an operation (API v2).
+type ErrorCode int32
+
+const (
+	ErrorCode_NONE             ErrorCode = 0
+	ErrorCode_INVALID_ARGUMENT ErrorCode = 1
+	// Another operation is in progress on the plugin.
+	ErrorCode_BUSY      ErrorCode = 2
+	ErrorCode_CANCELLED ErrorCode = 3
+	ErrorCode_INTERNAL  ErrorCode = 4
+	// The request has no valid token.
+	ErrorCode_UNAUTHENTICATED ErrorCode = 5
+	// The role of the token does not allow the operation.
+	ErrorCode_PERMISSION_DENIED ErrorCode = 6
+	// The core instance is not the leader, which alone changes the plugins.
+	ErrorCode_UNAVAILABLE ErrorCode = 7
+)
+
+var ErrorCode_name = map[int32]string{
+	0: "NONE",
+	1: "INVALID_ARGUMENT",
+	2: "BUSY",
+	3: "CANCELLED",
+	4: "INTERNAL",
+	5: "UNAUTHENTICATED",
+	6: "PERMISSION_DENIED",
+	7: "UNAVAILABLE",
+}
+var ErrorCode_value = map[string]int32{
+	"NONE":              0,
+	"INVALID_ARGUMENT":  1,
+	"BUSY":              2,
+	"CANCELLED":         3,
+	"INTERNAL":          4,
+	"UNAUTHENTICATED":   5,
+	"PERMISSION_DENIED": 6,
+	"UNAVAILABLE":       7,
+}
+
+func (x ErrorCode) String() string {
+	return proto1.EnumName(ErrorCode_name, int32(x))
+}
+
 type Empty struct {
 }
 
@@ -193,24 +298,806 @@ func (m *Pong) String() string { return proto1.CompactTextString(m) }
 func (*Pong) ProtoMessage()    {}
 
 type NewPluginRequest struct {
-	Directory string `protobuf:"bytes,1,opt,name=directory" json:"directory,omitempty"`
-	Name      string `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
-	Url       string `protobuf:"bytes,3,opt,name=url" json:"url,omitempty"`
+	Directory  string            `protobuf:"bytes,1,opt,name=directory" json:"directory,omitempty"`
+	Name       string            `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Url        string            `protobuf:"bytes,3,opt,name=url" json:"url,omitempty"`
+	Template   string            `protobuf:"bytes,4,opt,name=template" json:"template,omitempty"`
+	Parameters map[string]string `protobuf:"bytes,5,rep,name=parameters" json:"parameters,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Identifier of the build, used in the subject where the build
+	// output is streamed. Generated by core when empty.
+	BuildId string `protobuf:"bytes,6,opt,name=buildId" json:"buildId,omitempty"`
+	// Sources of the plugin, used instead of a template. At most one
+	// of "directory", "gitUrl", "archiveId" and "remoteContext" is set.
+	GitUrl string `protobuf:"bytes,7,opt,name=gitUrl" json:"gitUrl,omitempty"`
+	GitRef string `protobuf:"bytes,8,opt,name=gitRef" json:"gitRef,omitempty"`
+	// Identifier of an archive uploaded with "ArchiveChunk" messages.
+	ArchiveId string `protobuf:"bytes,9,opt,name=archiveId" json:"archiveId,omitempty"`
+	// URL of a build context fetched by the Docker daemon.
+	RemoteContext string `protobuf:"bytes,10,opt,name=remoteContext" json:"remoteContext,omitempty"`
+	// Configuration of the plugin. Its name and URL are the ones
+	// of the request.
+	Configuration *PluginConfiguration `protobuf:"bytes,11,opt,name=configuration" json:"configuration,omitempty"`
+	// Tag of the image. Defaults to "latest".
+	Tag string `protobuf:"bytes,12,opt,name=tag" json:"tag,omitempty"`
+	// Additional tags given to the image.
+	ExtraTags []string          `protobuf:"bytes,13,rep,name=extraTags" json:"extraTags,omitempty"`
+	BuildArgs map[string]string `protobuf:"bytes,14,rep,name=buildArgs" json:"buildArgs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Stage of a multi-stage Dockerfile to build.
+	Target string `protobuf:"bytes,15,opt,name=target" json:"target,omitempty"`
+	// Whether or not the build cache is ignored, and the base images
+	// pulled even if they are present.
+	NoCache bool `protobuf:"varint,16,opt,name=noCache" json:"noCache,omitempty"`
+	Pull    bool `protobuf:"varint,17,opt,name=pull" json:"pull,omitempty"`
+	// Whether or not the image is pushed to the registry configured
+	// in core once built.
+	Push bool `protobuf:"varint,18,opt,name=push" json:"push,omitempty"`
 }
 
 func (m *NewPluginRequest) Reset()         { *m = NewPluginRequest{} }
 func (m *NewPluginRequest) String() string { return proto1.CompactTextString(m) }
 func (*NewPluginRequest) ProtoMessage()    {}
 
+func (m *NewPluginRequest) GetParameters() map[string]string {
+	if m != nil {
+		return m.Parameters
+	}
+	return nil
+}
+
+func (m *NewPluginRequest) GetConfiguration() *PluginConfiguration {
+	if m != nil {
+		return m.Configuration
+	}
+	return nil
+}
+
+func (m *NewPluginRequest) GetBuildArgs() map[string]string {
+	if m != nil {
+		return m.BuildArgs
+	}
+	return nil
+}
+
 type NewPluginResponse struct {
-	Status bool `protobuf:"varint,1,opt,name=status" json:"status,omitempty"`
+	Status  bool   `protobuf:"varint,1,opt,name=status" json:"status,omitempty"`
+	BuildId string `protobuf:"bytes,2,opt,name=buildId" json:"buildId,omitempty"`
+	// Build step being executed when the creation failed, if any.
+	FailedStep string `protobuf:"bytes,3,opt,name=failedStep" json:"failedStep,omitempty"`
+	Error      string `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
+	// Invalid fields of the request, if any.
+	ValidationErrors []*ValidationError `protobuf:"bytes,5,rep,name=validationErrors" json:"validationErrors,omitempty"`
+	// Reference and digest of the pushed image, if any.
+	Image  string `protobuf:"bytes,6,opt,name=image" json:"image,omitempty"`
+	Digest string `protobuf:"bytes,7,opt,name=digest" json:"digest,omitempty"`
+	// Number of lines published on the build's topic, so that the client
+	// knows when it received the whole build log.
+	LogLines int64 `protobuf:"varint,8,opt,name=logLines" json:"logLines,omitempty"`
 }
 
 func (m *NewPluginResponse) Reset()         { *m = NewPluginResponse{} }
 func (m *NewPluginResponse) String() string { return proto1.CompactTextString(m) }
 func (*NewPluginResponse) ProtoMessage()    {}
 
+func (m *NewPluginResponse) GetValidationErrors() []*ValidationError {
+	if m != nil {
+		return m.ValidationErrors
+	}
+	return nil
+}
+
+type ValidationError struct {
+	Field   string `protobuf:"bytes,1,opt,name=field" json:"field,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *ValidationError) Reset()         { *m = ValidationError{} }
+func (m *ValidationError) String() string { return proto1.CompactTextString(m) }
+func (*ValidationError) ProtoMessage()    {}
+
+type TemplateParameter struct {
+	Name         string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Description  string `protobuf:"bytes,2,opt,name=description" json:"description,omitempty"`
+	DefaultValue string `protobuf:"bytes,3,opt,name=defaultValue" json:"defaultValue,omitempty"`
+	Required     bool   `protobuf:"varint,4,opt,name=required" json:"required,omitempty"`
+}
+
+func (m *TemplateParameter) Reset()         { *m = TemplateParameter{} }
+func (m *TemplateParameter) String() string { return proto1.CompactTextString(m) }
+func (*TemplateParameter) ProtoMessage()    {}
+
+type PluginTemplate struct {
+	Name        string               `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Description string               `protobuf:"bytes,2,opt,name=description" json:"description,omitempty"`
+	Parameters  []*TemplateParameter `protobuf:"bytes,3,rep,name=parameters" json:"parameters,omitempty"`
+}
+
+func (m *PluginTemplate) Reset()         { *m = PluginTemplate{} }
+func (m *PluginTemplate) String() string { return proto1.CompactTextString(m) }
+func (*PluginTemplate) ProtoMessage()    {}
+
+func (m *PluginTemplate) GetParameters() []*TemplateParameter {
+	if m != nil {
+		return m.Parameters
+	}
+	return nil
+}
+
+type PluginTemplates struct {
+	Templates []*PluginTemplate `protobuf:"bytes,1,rep,name=templates" json:"templates,omitempty"`
+}
+
+func (m *PluginTemplates) Reset()         { *m = PluginTemplates{} }
+func (m *PluginTemplates) String() string { return proto1.CompactTextString(m) }
+func (*PluginTemplates) ProtoMessage()    {}
+
+func (m *PluginTemplates) GetTemplates() []*PluginTemplate {
+	if m != nil {
+		return m.Templates
+	}
+	return nil
+}
+
+type BuildLogLine struct {
+	BuildId  string `protobuf:"bytes,1,opt,name=buildId" json:"buildId,omitempty"`
+	Sequence int64  `protobuf:"varint,2,opt,name=sequence" json:"sequence,omitempty"`
+	Line     string `protobuf:"bytes,3,opt,name=line" json:"line,omitempty"`
+}
+
+func (m *BuildLogLine) Reset()         { *m = BuildLogLine{} }
+func (m *BuildLogLine) String() string { return proto1.CompactTextString(m) }
+func (*BuildLogLine) ProtoMessage()    {}
+
+type BuildLog struct {
+	Name       string   `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	BuildId    string   `protobuf:"bytes,2,opt,name=buildId" json:"buildId,omitempty"`
+	Status     bool     `protobuf:"varint,3,opt,name=status" json:"status,omitempty"`
+	FailedStep string   `protobuf:"bytes,4,opt,name=failedStep" json:"failedStep,omitempty"`
+	Error      string   `protobuf:"bytes,5,opt,name=error" json:"error,omitempty"`
+	Lines      []string `protobuf:"bytes,6,rep,name=lines" json:"lines,omitempty"`
+	// Unix timestamp (seconds) of the end of the build.
+	FinishedAt int64 `protobuf:"varint,7,opt,name=finishedAt" json:"finishedAt,omitempty"`
+}
+
+func (m *BuildLog) Reset()         { *m = BuildLog{} }
+func (m *BuildLog) String() string { return proto1.CompactTextString(m) }
+func (*BuildLog) ProtoMessage()    {}
+
+// Chunk of a tar archive uploaded to build a plugin. The chunks of an
+// upload are numbered from 1 and must be sent in order.
+type ArchiveChunk struct {
+	UploadId string `protobuf:"bytes,1,opt,name=uploadId" json:"uploadId,omitempty"`
+	Sequence int64  `protobuf:"varint,2,opt,name=sequence" json:"sequence,omitempty"`
+	Data     []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	Last     bool   `protobuf:"varint,4,opt,name=last" json:"last,omitempty"`
+}
+
+func (m *ArchiveChunk) Reset()         { *m = ArchiveChunk{} }
+func (m *ArchiveChunk) String() string { return proto1.CompactTextString(m) }
+func (*ArchiveChunk) ProtoMessage()    {}
+
+// Configuration of a plugin, written into its "config.json" file.
+type PluginConfiguration struct {
+	// Version of the configuration format.
+	Version int32    `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	Name    string   `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Url     string   `protobuf:"bytes,3,opt,name=url" json:"url,omitempty"`
+	Routes  []*Route `protobuf:"bytes,4,rep,name=routes" json:"routes,omitempty"`
+	// Entries added to the backoffice menu.
+	MenuEntries []*MenuEntry `protobuf:"bytes,5,rep,name=menuEntries" json:"menuEntries,omitempty"`
+	// NATS topics the plugin subscribes to and publishes on.
+	ConsumedTopics []string        `protobuf:"bytes,6,rep,name=consumedTopics" json:"consumedTopics,omitempty"`
+	ProducedTopics []string        `protobuf:"bytes,7,rep,name=producedTopics" json:"producedTopics,omitempty"`
+	I18N           []*I18NResource `protobuf:"bytes,8,rep,name=i18n" json:"i18n,omitempty"`
+	// Permissions defined by the plugin.
+	Permissions []*Permission `protobuf:"bytes,9,rep,name=permissions" json:"permissions,omitempty"`
+}
+
+func (m *PluginConfiguration) Reset()         { *m = PluginConfiguration{} }
+func (m *PluginConfiguration) String() string { return proto1.CompactTextString(m) }
+func (*PluginConfiguration) ProtoMessage()    {}
+
+func (m *PluginConfiguration) GetRoutes() []*Route {
+	if m != nil {
+		return m.Routes
+	}
+	return nil
+}
+
+func (m *PluginConfiguration) GetMenuEntries() []*MenuEntry {
+	if m != nil {
+		return m.MenuEntries
+	}
+	return nil
+}
+
+func (m *PluginConfiguration) GetI18N() []*I18NResource {
+	if m != nil {
+		return m.I18N
+	}
+	return nil
+}
+
+func (m *PluginConfiguration) GetPermissions() []*Permission {
+	if m != nil {
+		return m.Permissions
+	}
+	return nil
+}
+
+type Route struct {
+	Path      string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Component string `protobuf:"bytes,2,opt,name=component" json:"component,omitempty"`
+	// Permission required to access the route, if any.
+	Permission string `protobuf:"bytes,3,opt,name=permission" json:"permission,omitempty"`
+}
+
+func (m *Route) Reset()         { *m = Route{} }
+func (m *Route) String() string { return proto1.CompactTextString(m) }
+func (*Route) ProtoMessage()    {}
+
+type MenuEntry struct {
+	Label string `protobuf:"bytes,1,opt,name=label" json:"label,omitempty"`
+	// Path of one of the plugin's routes.
+	Route      string `protobuf:"bytes,2,opt,name=route" json:"route,omitempty"`
+	Icon       string `protobuf:"bytes,3,opt,name=icon" json:"icon,omitempty"`
+	Order      int32  `protobuf:"varint,4,opt,name=order" json:"order,omitempty"`
+	Permission string `protobuf:"bytes,5,opt,name=permission" json:"permission,omitempty"`
+}
+
+func (m *MenuEntry) Reset()         { *m = MenuEntry{} }
+func (m *MenuEntry) String() string { return proto1.CompactTextString(m) }
+func (*MenuEntry) ProtoMessage()    {}
+
+type I18NResource struct {
+	Locale string `protobuf:"bytes,1,opt,name=locale" json:"locale,omitempty"`
+	Path   string `protobuf:"bytes,2,opt,name=path" json:"path,omitempty"`
+}
+
+func (m *I18NResource) Reset()         { *m = I18NResource{} }
+func (m *I18NResource) String() string { return proto1.CompactTextString(m) }
+func (*I18NResource) ProtoMessage()    {}
+
+type Permission struct {
+	Name        string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Description string `protobuf:"bytes,2,opt,name=description" json:"description,omitempty"`
+}
+
+func (m *Permission) Reset()         { *m = Permission{} }
+func (m *Permission) String() string { return proto1.CompactTextString(m) }
+func (*Permission) ProtoMessage()    {}
+
+type PluginConfigurationResponse struct {
+	Configuration *PluginConfiguration `protobuf:"bytes,1,opt,name=configuration" json:"configuration,omitempty"`
+	Error         string               `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *PluginConfigurationResponse) Reset()         { *m = PluginConfigurationResponse{} }
+func (m *PluginConfigurationResponse) String() string { return proto1.CompactTextString(m) }
+func (*PluginConfigurationResponse) ProtoMessage()    {}
+
+func (m *PluginConfigurationResponse) GetConfiguration() *PluginConfiguration {
+	if m != nil {
+		return m.Configuration
+	}
+	return nil
+}
+
+type PushPluginRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Tag of the image to push. Defaults to "latest".
+	Tag string `protobuf:"bytes,2,opt,name=tag" json:"tag,omitempty"`
+	// Identifier of the push, used in the subject where the push
+	// progress is streamed. Generated by core when empty.
+	PushId string `protobuf:"bytes,3,opt,name=pushId" json:"pushId,omitempty"`
+}
+
+func (m *PushPluginRequest) Reset()         { *m = PushPluginRequest{} }
+func (m *PushPluginRequest) String() string { return proto1.CompactTextString(m) }
+func (*PushPluginRequest) ProtoMessage()    {}
+
+type PushPluginResponse struct {
+	Status bool   `protobuf:"varint,1,opt,name=status" json:"status,omitempty"`
+	PushId string `protobuf:"bytes,2,opt,name=pushId" json:"pushId,omitempty"`
+	// Reference of the image in the registry.
+	Image  string `protobuf:"bytes,3,opt,name=image" json:"image,omitempty"`
+	Digest string `protobuf:"bytes,4,opt,name=digest" json:"digest,omitempty"`
+	Error  string `protobuf:"bytes,5,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *PushPluginResponse) Reset()         { *m = PushPluginResponse{} }
+func (m *PushPluginResponse) String() string { return proto1.CompactTextString(m) }
+func (*PushPluginResponse) ProtoMessage()    {}
+
+type DeletePluginRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Whether or not an installed plugin is uninstalled before being
+	// deleted. Otherwise, deleting an installed plugin fails.
+	Force bool `protobuf:"varint,2,opt,name=force" json:"force,omitempty"`
+	// Whether or not the most recent image of the plugin is kept.
+	OldVersionsOnly bool `protobuf:"varint,3,opt,name=oldVersionsOnly" json:"oldVersionsOnly,omitempty"`
+}
+
+func (m *DeletePluginRequest) Reset()         { *m = DeletePluginRequest{} }
+func (m *DeletePluginRequest) String() string { return proto1.CompactTextString(m) }
+func (*DeletePluginRequest) ProtoMessage()    {}
+
+type DeletePluginResponse struct {
+	Status bool `protobuf:"varint,1,opt,name=status" json:"status,omitempty"`
+	// References of the removed images.
+	RemovedImages []string `protobuf:"bytes,2,rep,name=removedImages" json:"removedImages,omitempty"`
+	// Space freed by the removal of the dangling layers, in bytes.
+	ReclaimedSpace int64  `protobuf:"varint,3,opt,name=reclaimedSpace" json:"reclaimedSpace,omitempty"`
+	Error          string `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *DeletePluginResponse) Reset()         { *m = DeletePluginResponse{} }
+func (m *DeletePluginResponse) String() string { return proto1.CompactTextString(m) }
+func (*DeletePluginResponse) ProtoMessage()    {}
+
+type GarbageCollectionRequest struct {
+	// Whether or not the report only lists what would be removed.
+	DryRun bool `protobuf:"varint,1,opt,name=dryRun" json:"dryRun,omitempty"`
+}
+
+func (m *GarbageCollectionRequest) Reset()         { *m = GarbageCollectionRequest{} }
+func (m *GarbageCollectionRequest) String() string { return proto1.CompactTextString(m) }
+func (*GarbageCollectionRequest) ProtoMessage()    {}
+
+type GarbageCollectionReport struct {
+	DryRun bool `protobuf:"varint,1,opt,name=dryRun" json:"dryRun,omitempty"`
+	// Names of the removed containers.
+	RemovedContainers []string `protobuf:"bytes,2,rep,name=removedContainers" json:"removedContainers,omitempty"`
+	// References of the removed images.
+	RemovedImages []string `protobuf:"bytes,3,rep,name=removedImages" json:"removedImages,omitempty"`
+	// Identifiers of the removed untagged images.
+	RemovedDanglingImages []string `protobuf:"bytes,4,rep,name=removedDanglingImages" json:"removedDanglingImages,omitempty"`
+	// Errors of the removals that failed, the other removals being done.
+	Errors []string `protobuf:"bytes,5,rep,name=errors" json:"errors,omitempty"`
+	Error  string   `protobuf:"bytes,6,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *GarbageCollectionReport) Reset()         { *m = GarbageCollectionReport{} }
+func (m *GarbageCollectionReport) String() string { return proto1.CompactTextString(m) }
+func (*GarbageCollectionReport) ProtoMessage()    {}
+
+type PluginEvent struct {
+	Type PluginEventType `protobuf:"varint,1,opt,name=type,enum=proto.PluginEventType" json:"type,omitempty"`
+	Name string          `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	// Unix timestamp (seconds) of the event.
+	Timestamp int64 `protobuf:"varint,3,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *PluginEvent) Reset()         { *m = PluginEvent{} }
+func (m *PluginEvent) String() string { return proto1.CompactTextString(m) }
+func (*PluginEvent) ProtoMessage()    {}
+
+// Installation request of the API v2, replacing InstallPluginRequest.
+type InstallRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Cmd  string `protobuf:"bytes,2,opt,name=cmd" json:"cmd,omitempty"`
+}
+
+func (m *InstallRequest) Reset()         { *m = InstallRequest{} }
+func (m *InstallRequest) String() string { return proto1.CompactTextString(m) }
+func (*InstallRequest) ProtoMessage()    {}
+
+// Uninstallation request of the API v2, replacing Plugin.
+type UninstallRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *UninstallRequest) Reset()         { *m = UninstallRequest{} }
+func (m *UninstallRequest) String() string { return proto1.CompactTextString(m) }
+func (*UninstallRequest) ProtoMessage()    {}
+
+// Response of the API v2 to the operations, replacing NetResponse.
+type OperationResponse struct {
+	Status bool      `protobuf:"varint,1,opt,name=status" json:"status,omitempty"`
+	Code   ErrorCode `protobuf:"varint,2,opt,name=code,enum=proto.ErrorCode" json:"code,omitempty"`
+	Error  string    `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+	Name   string    `protobuf:"bytes,4,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *OperationResponse) Reset()         { *m = OperationResponse{} }
+func (m *OperationResponse) String() string { return proto1.CompactTextString(m) }
+func (*OperationResponse) ProtoMessage()    {}
+
+type APIVersion struct {
+	// Name of the version, such as "v1", used in the topics ("core.v1.*").
+	Version    string   `protobuf:"bytes,1,opt,name=version" json:"version,omitempty"`
+	Topics     []string `protobuf:"bytes,2,rep,name=topics" json:"topics,omitempty"`
+	Deprecated bool     `protobuf:"varint,3,opt,name=deprecated" json:"deprecated,omitempty"`
+}
+
+func (m *APIVersion) Reset()         { *m = APIVersion{} }
+func (m *APIVersion) String() string { return proto1.CompactTextString(m) }
+func (*APIVersion) ProtoMessage()    {}
+
+type APIVersions struct {
+	Versions []*APIVersion `protobuf:"bytes,1,rep,name=versions" json:"versions,omitempty"`
+	Current  string        `protobuf:"bytes,2,opt,name=current" json:"current,omitempty"`
+}
+
+func (m *APIVersions) Reset()         { *m = APIVersions{} }
+func (m *APIVersions) String() string { return proto1.CompactTextString(m) }
+func (*APIVersions) ProtoMessage()    {}
+
+func (m *APIVersions) GetVersions() []*APIVersion {
+	if m != nil {
+		return m.Versions
+	}
+	return nil
+}
+
+// Request sent on the NATS topics when the authentication is enabled.
+type RequestEnvelope struct {
+	// JSON Web Token of the requester, signed with a key configured in core.
+	Token string `protobuf:"bytes,1,opt,name=token" json:"token,omitempty"`
+	// Request of the topic, with the encoding of the topic.
+	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (m *RequestEnvelope) Reset()         { *m = RequestEnvelope{} }
+func (m *RequestEnvelope) String() string { return proto1.CompactTextString(m) }
+func (*RequestEnvelope) ProtoMessage()    {}
+
+// Reply to the requests sent in a RequestEnvelope.
+type ResponseEnvelope struct {
+	// Set when the request was rejected, the payload being then empty.
+	Error *AuthError `protobuf:"bytes,1,opt,name=error" json:"error,omitempty"`
+	// Response of the topic, with the encoding of the topic.
+	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (m *ResponseEnvelope) Reset()         { *m = ResponseEnvelope{} }
+func (m *ResponseEnvelope) String() string { return proto1.CompactTextString(m) }
+func (*ResponseEnvelope) ProtoMessage()    {}
+
+func (m *ResponseEnvelope) GetError() *AuthError {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+type AuthError struct {
+	Code    ErrorCode `protobuf:"varint,1,opt,name=code,enum=proto.ErrorCode" json:"code,omitempty"`
+	Message string    `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	// Role required by the operation, when the requester's role is insufficient.
+	RequiredRole string `protobuf:"bytes,3,opt,name=requiredRole" json:"requiredRole,omitempty"`
+}
+
+func (m *AuthError) Reset()         { *m = AuthError{} }
+func (m *AuthError) String() string { return proto1.CompactTextString(m) }
+func (*AuthError) ProtoMessage()    {}
+
+// Administration action recorded in the audit log.
+type AuditEntry struct {
+	// Unix timestamp (seconds) of the request.
+	Timestamp int64 `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	// Topic of the request, without version nor encoding.
+	Operation string `protobuf:"bytes,2,opt,name=operation" json:"operation,omitempty"`
+	Plugin    string `protobuf:"bytes,3,opt,name=plugin" json:"plugin,omitempty"`
+	// Requester, empty when the requests are not authenticated.
+	Actor string `protobuf:"bytes,4,opt,name=actor" json:"actor,omitempty"`
+	Role  string `protobuf:"bytes,5,opt,name=role" json:"role,omitempty"`
+	// Request, as JSON.
+	Request    string `protobuf:"bytes,6,opt,name=request" json:"request,omitempty"`
+	Status     bool   `protobuf:"varint,7,opt,name=status" json:"status,omitempty"`
+	Error      string `protobuf:"bytes,8,opt,name=error" json:"error,omitempty"`
+	DurationMs int64  `protobuf:"varint,9,opt,name=durationMs" json:"durationMs,omitempty"`
+	// Kind of change : "install", "uninstall", "create", "configure" (a
+	// creation changing the configuration of an existing plugin), "push"
+	// or "delete".
+	Action string `protobuf:"bytes,10,opt,name=action" json:"action,omitempty"`
+}
+
+func (m *AuditEntry) Reset()         { *m = AuditEntry{} }
+func (m *AuditEntry) String() string { return proto1.CompactTextString(m) }
+func (*AuditEntry) ProtoMessage()    {}
+
+// Filters of the audit entries. The empty filters match every entry.
+type AuditQuery struct {
+	Plugin string `protobuf:"bytes,1,opt,name=plugin" json:"plugin,omitempty"`
+	Actor  string `protobuf:"bytes,2,opt,name=actor" json:"actor,omitempty"`
+	// Unix timestamps (seconds) of the time range, "until" being excluded.
+	Since int64 `protobuf:"varint,3,opt,name=since" json:"since,omitempty"`
+	Until int64 `protobuf:"varint,4,opt,name=until" json:"until,omitempty"`
+	// Maximum number of entries, the most recent ones being returned.
+	Limit int32 `protobuf:"varint,5,opt,name=limit" json:"limit,omitempty"`
+}
+
+func (m *AuditQuery) Reset()         { *m = AuditQuery{} }
+func (m *AuditQuery) String() string { return proto1.CompactTextString(m) }
+func (*AuditQuery) ProtoMessage()    {}
+
+type AuditEntries struct {
+	// Matching entries, from the oldest to the most recent.
+	Entries []*AuditEntry `protobuf:"bytes,1,rep,name=entries" json:"entries,omitempty"`
+	Error   string        `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *AuditEntries) Reset()         { *m = AuditEntries{} }
+func (m *AuditEntries) String() string { return proto1.CompactTextString(m) }
+func (*AuditEntries) ProtoMessage()    {}
+
+func (m *AuditEntries) GetEntries() []*AuditEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
 func init() {
 	proto1.RegisterEnum("proto.PluginStatus", PluginStatus_name, PluginStatus_value)
 	proto1.RegisterEnum("proto.Responses", Responses_name, Responses_value)
+	proto1.RegisterEnum("proto.PluginEventType", PluginEventType_name, PluginEventType_value)
+	proto1.RegisterEnum("proto.ErrorCode", ErrorCode_name, ErrorCode_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Registry service
+
+type RegistryClient interface {
+	ListAvailablePlugins(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Plugins, error)
+	ListInstalledPlugins(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Plugins, error)
+	InstallPlugin(ctx context.Context, in *InstallPluginRequest, opts ...grpc.CallOption) (*NetResponse, error)
+	UninstallPlugin(ctx context.Context, in *Plugin, opts ...grpc.CallOption) (*NetResponse, error)
+	CreatePlugin(ctx context.Context, in *NewPluginRequest, opts ...grpc.CallOption) (*NewPluginResponse, error)
+	// Streams the events of the plugins until the client cancels the call.
+	WatchEvents(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Registry_WatchEventsClient, error)
+}
+
+type registryClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewRegistryClient(cc *grpc.ClientConn) RegistryClient {
+	return &registryClient{cc}
+}
+
+func (c *registryClient) ListAvailablePlugins(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Plugins, error) {
+	out := new(Plugins)
+	err := grpc.Invoke(ctx, "/proto.Registry/ListAvailablePlugins", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) ListInstalledPlugins(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Plugins, error) {
+	out := new(Plugins)
+	err := grpc.Invoke(ctx, "/proto.Registry/ListInstalledPlugins", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) InstallPlugin(ctx context.Context, in *InstallPluginRequest, opts ...grpc.CallOption) (*NetResponse, error) {
+	out := new(NetResponse)
+	err := grpc.Invoke(ctx, "/proto.Registry/InstallPlugin", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) UninstallPlugin(ctx context.Context, in *Plugin, opts ...grpc.CallOption) (*NetResponse, error) {
+	out := new(NetResponse)
+	err := grpc.Invoke(ctx, "/proto.Registry/UninstallPlugin", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) CreatePlugin(ctx context.Context, in *NewPluginRequest, opts ...grpc.CallOption) (*NewPluginResponse, error) {
+	out := new(NewPluginResponse)
+	err := grpc.Invoke(ctx, "/proto.Registry/CreatePlugin", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) WatchEvents(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Registry_WatchEventsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Registry_serviceDesc.Streams[0], c.cc, "/proto.Registry/WatchEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &registryWatchEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Registry_WatchEventsClient interface {
+	Recv() (*PluginEvent, error)
+	grpc.ClientStream
+}
+
+type registryWatchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *registryWatchEventsClient) Recv() (*PluginEvent, error) {
+	m := new(PluginEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for Registry service
+
+type RegistryServer interface {
+	ListAvailablePlugins(context.Context, *Empty) (*Plugins, error)
+	ListInstalledPlugins(context.Context, *Empty) (*Plugins, error)
+	InstallPlugin(context.Context, *InstallPluginRequest) (*NetResponse, error)
+	UninstallPlugin(context.Context, *Plugin) (*NetResponse, error)
+	CreatePlugin(context.Context, *NewPluginRequest) (*NewPluginResponse, error)
+	// Streams the events of the plugins until the client cancels the call.
+	WatchEvents(*Empty, Registry_WatchEventsServer) error
+}
+
+func RegisterRegistryServer(s *grpc.Server, srv RegistryServer) {
+	s.RegisterService(&_Registry_serviceDesc, srv)
+}
+
+func _Registry_ListAvailablePlugins_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).ListAvailablePlugins(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Registry/ListAvailablePlugins",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).ListAvailablePlugins(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_ListInstalledPlugins_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).ListInstalledPlugins(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Registry/ListInstalledPlugins",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).ListInstalledPlugins(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_InstallPlugin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InstallPluginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).InstallPlugin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Registry/InstallPlugin",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).InstallPlugin(ctx, req.(*InstallPluginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_UninstallPlugin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Plugin)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).UninstallPlugin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Registry/UninstallPlugin",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).UninstallPlugin(ctx, req.(*Plugin))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_CreatePlugin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NewPluginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).CreatePlugin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Registry/CreatePlugin",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).CreatePlugin(ctx, req.(*NewPluginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RegistryServer).WatchEvents(m, &registryWatchEventsServer{stream})
+}
+
+type Registry_WatchEventsServer interface {
+	Send(*PluginEvent) error
+	grpc.ServerStream
+}
+
+type registryWatchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *registryWatchEventsServer) Send(m *PluginEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Registry_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Registry",
+	HandlerType: (*RegistryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListAvailablePlugins",
+			Handler:    _Registry_ListAvailablePlugins_Handler,
+		},
+		{
+			MethodName: "ListInstalledPlugins",
+			Handler:    _Registry_ListInstalledPlugins_Handler,
+		},
+		{
+			MethodName: "InstallPlugin",
+			Handler:    _Registry_InstallPlugin_Handler,
+		},
+		{
+			MethodName: "UninstallPlugin",
+			Handler:    _Registry_UninstallPlugin_Handler,
+		},
+		{
+			MethodName: "CreatePlugin",
+			Handler:    _Registry_CreatePlugin_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Registry_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "registry.proto",
 }
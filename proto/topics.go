@@ -1,10 +1,55 @@
 package proto
 
+import "strings"
+
 const (
-	topicNameSpace            = "core"
-	ListAvailablePluginsTopic = topicNameSpace + ".pluginlist.available"
-	ListInstalledPluginsTopic = topicNameSpace + ".pluginlist.installed"
-	InstallPluginTopic        = topicNameSpace + ".plugin.install"
-	UninstallPluginTopic      = topicNameSpace + ".plugin.uninstall"
-	CreatePlugin              = topicNameSpace + ".plugin.create"
+	topicNameSpace              = "core"
+	ListAvailablePluginsTopic   = topicNameSpace + ".pluginlist.available"
+	ListInstalledPluginsTopic   = topicNameSpace + ".pluginlist.installed"
+	InstallPluginTopic          = topicNameSpace + ".plugin.install"
+	UninstallPluginTopic        = topicNameSpace + ".plugin.uninstall"
+	CreatePlugin                = topicNameSpace + ".plugin.create"
+	ListPluginTemplatesTopic    = topicNameSpace + ".plugin.templates"
+	GetBuildLogTopic            = topicNameSpace + ".plugin.buildlog"
+	UploadArchiveTopic          = topicNameSpace + ".plugin.upload"
+	GetPluginConfigurationTopic = topicNameSpace + ".plugin.config"
+	PushPluginTopic             = topicNameSpace + ".plugin.push"
+	DeletePluginTopic           = topicNameSpace + ".plugin.delete"
+	GarbageCollectionTopic      = topicNameSpace + ".gc"
+	AuditQueryTopic             = topicNameSpace + ".audit.query"
+
+	/*
+	 * Events of the plugins, published by core as "PluginEvent" messages.
+	 */
+	PluginEventsTopic = topicNameSpace + ".events"
+
+	/*
+	 * Heartbeats exchanged by the core instances to elect a leader.
+	 */
+	LeaderHeartbeatTopic = topicNameSpace + ".leader.heartbeat"
 )
+
+/*
+ * Returns the topic where the output of the given build is streamed,
+ * one "BuildLogLine" message per line.
+ */
+func BuildLogTopic(buildId string) string {
+	return topicNameSpace + ".plugin.build." + buildId + ".log"
+}
+
+/*
+ * Returns the topic where the progress of the given push is streamed,
+ * one "BuildLogLine" message per line.
+ */
+func PushProgressTopic(pushId string) string {
+	return topicNameSpace + ".plugin.push." + pushId + ".progress"
+}
+
+/*
+ * Returns the JSON form of the given topic, such as
+ * "core.json.plugin.install" : the messages are the same, encoded with
+ * the JSON mapping of protobuf.
+ */
+func JSONTopic(topic string) string {
+	return topicNameSpace + ".json" + strings.TrimPrefix(topic, topicNameSpace)
+}
@@ -0,0 +1,96 @@
+package proto
+
+import "strings"
+
+const (
+	/*
+	 * Versions of the API, used as the second token of the topics, such
+	 * as "core.v1.plugin.install". The topics without version are the
+	 * ones of the first version, kept for the deployed plugins.
+	 */
+	APIVersion1       = "v1"
+	APIVersion2       = "v2"
+	CurrentAPIVersion = APIVersion2
+
+	/*
+	 * Topic replying with the "APIVersions" served by core.
+	 */
+	APIVersionsTopic = topicNameSpace + ".api.versions"
+)
+
+/*
+ * Versions of the API served by core, from the oldest to the newest.
+ */
+var SupportedAPIVersions = []string{APIVersion1, APIVersion2}
+
+/*
+ * Topics whose messages changed in the API v2 :
+ * - "InstallRequest" replaces "InstallPluginRequest".
+ * - "UninstallRequest" replaces "Plugin".
+ * - "OperationResponse" replaces "NetResponse".
+ *
+ * The other topics use the same messages in both versions.
+ */
+var topicsChangedInV2 = map[string]bool{
+	InstallPluginTopic:   true,
+	UninstallPluginTopic: true,
+}
+
+/*
+ * Returns the topic of the given API version. The topic must be one of
+ * the topics without version declared in this package.
+ */
+func VersionedTopic(version string, topic string) string {
+	return topicNameSpace + "." + version + strings.TrimPrefix(topic, topicNameSpace)
+}
+
+/*
+ * Returns the topic without version nor encoding of the given topic :
+ * "core.json.v2.plugin.install" gives "core.plugin.install".
+ */
+func BaseTopic(topic string) string {
+	name := strings.TrimPrefix(topic, topicNameSpace+".")
+	name = strings.TrimPrefix(name, "json.")
+	for _, version := range SupportedAPIVersions {
+		if strings.HasPrefix(name, version+".") {
+			name = strings.TrimPrefix(name, version+".")
+			break
+		}
+	}
+	return topicNameSpace + "." + name
+}
+
+/*
+ * Returns a boolean indicating whether or not the messages of the given
+ * topic changed in the API v2.
+ */
+func ChangedInV2(topic string) bool {
+	return topicsChangedInV2[topic]
+}
+
+/*
+ * Converts the request to the request of the API v1.
+ */
+func (request *InstallRequest) ToV1() *InstallPluginRequest {
+	return &InstallPluginRequest{Plugin: &Plugin{Name: request.Name}, Cmd: request.Cmd}
+}
+
+/*
+ * Converts the request to the request of the API v1.
+ */
+func (request *UninstallRequest) ToV1() *Plugin {
+	return &Plugin{Name: request.Name}
+}
+
+/*
+ * Converts a response of the API v1 to the response of the API v2.
+ *
+ * The v1 responses do not tell why an operation failed : the failures
+ * are given the "INTERNAL" code.
+ */
+func OperationResponseFromV1(name string, response *NetResponse) *OperationResponse {
+	if response.Response == Responses_ACK {
+		return &OperationResponse{Status: true, Name: name}
+	}
+	return &OperationResponse{Code: ErrorCode_INTERNAL, Error: response.Details, Name: name}
+}
@@ -0,0 +1,56 @@
+package proto_test
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+func TestVersionedTopic(t *testing.T) {
+	if topic := pb.VersionedTopic(pb.APIVersion2, pb.InstallPluginTopic); topic != "core.v2.plugin.install" {
+		t.Errorf("Invalid topic : %s", topic)
+	}
+	if topic := pb.VersionedTopic(pb.APIVersion1, pb.ListAvailablePluginsTopic); topic != "core.v1.pluginlist.available" {
+		t.Errorf("Invalid topic : %s", topic)
+	}
+}
+
+func TestBaseTopic(t *testing.T) {
+	topics := []string{
+		pb.InstallPluginTopic,
+		pb.VersionedTopic(pb.APIVersion1, pb.InstallPluginTopic),
+		pb.JSONTopic(pb.InstallPluginTopic),
+		pb.JSONTopic(pb.VersionedTopic(pb.APIVersion2, pb.InstallPluginTopic)),
+	}
+	for _, topic := range topics {
+		if base := pb.BaseTopic(topic); base != pb.InstallPluginTopic {
+			t.Errorf("Invalid base topic of %s : %s", topic, base)
+		}
+	}
+}
+
+func TestRequestAdapters(t *testing.T) {
+	install := (&pb.InstallRequest{Name: "agilestack-todo", Cmd: "serve"}).ToV1()
+	expected := &pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: "agilestack-todo"}, Cmd: "serve"}
+	if !reflect.DeepEqual(install, expected) {
+		t.Errorf("Invalid installation request : %v", install)
+	}
+
+	uninstall := (&pb.UninstallRequest{Name: "agilestack-todo"}).ToV1()
+	if !reflect.DeepEqual(uninstall, &pb.Plugin{Name: "agilestack-todo"}) {
+		t.Errorf("Invalid uninstallation request : %v", uninstall)
+	}
+}
+
+func TestResponseAdapter(t *testing.T) {
+	response := pb.OperationResponseFromV1("agilestack-todo", &pb.NetResponse{Response: pb.Responses_ACK})
+	if !response.Status || response.Code != pb.ErrorCode_NONE || response.Name != "agilestack-todo" {
+		t.Errorf("Invalid response : %v", response)
+	}
+
+	response = pb.OperationResponseFromV1("agilestack-todo", &pb.NetResponse{Response: pb.Responses_ERROR, Details: "failure"})
+	if response.Status || response.Code != pb.ErrorCode_INTERNAL || response.Error != "failure" {
+		t.Errorf("Invalid response : %v", response)
+	}
+}
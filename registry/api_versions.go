@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"context"
+	"sort"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/nats-io/nats"
+)
+
+/*
+ * Subscribes to the "core.api.versions" topic.
+ *
+ * Replies with the versions of the API served by core, and their topics.
+ * The versions older than the current one are deprecated.
+ */
+func (subscriber *natsSubscriber) subscribeToAPIVersions() {
+	subscriber.subscribeExactly(pb.APIVersionsTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, _ *pb.Empty) {
+			connection.Publish(reply, subscriber.apiVersions())
+		}
+	})
+}
+
+func (subscriber *natsSubscriber) apiVersions() *pb.APIVersions {
+	subscriber.subscriptionsMutex.Lock()
+	defer subscriber.subscriptionsMutex.Unlock()
+
+	versions := &pb.APIVersions{Current: pb.CurrentAPIVersion}
+	for _, version := range pb.SupportedAPIVersions {
+		topics := append([]string(nil), subscriber.apiTopics[version]...)
+		sort.Strings(topics)
+		versions.Versions = append(versions.Versions, &pb.APIVersion{
+			Version:    version,
+			Topics:     topics,
+			Deprecated: version != pb.CurrentAPIVersion,
+		})
+	}
+	return versions
+}
+
+/*
+ * Returns the v2 response to an operation on the given plugin, which
+ * returned the given v1 response and error.
+ */
+func operationResponse(name string, response *pb.NetResponse, err error) *pb.OperationResponse {
+	if err != nil {
+		return &pb.OperationResponse{Code: ErrorCode(err), Error: err.Error(), Name: name}
+	}
+	return pb.OperationResponseFromV1(name, response)
+}
+
+/*
+ * Returns the code of the API v2 telling why an operation failed with
+ * the given error.
+ */
+func ErrorCode(err error) pb.ErrorCode {
+	if err == nil {
+		return pb.ErrorCode_NONE
+	}
+	if _, ok := err.(ValidationErrors); ok {
+		return pb.ErrorCode_INVALID_ARGUMENT
+	}
+	switch err {
+	case ErrPluginBusy:
+		return pb.ErrorCode_BUSY
+	case ErrNotLeader:
+		return pb.ErrorCode_UNAVAILABLE
+	case context.Canceled, context.DeadlineExceeded:
+		return pb.ErrorCode_CANCELLED
+	}
+	return pb.ErrorCode_INTERNAL
+}
@@ -0,0 +1,30 @@
+package registry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+)
+
+func TestErrorCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code pb.ErrorCode
+	}{
+		{nil, pb.ErrorCode_NONE},
+		{registry.ValidationErrors{{Field: "name", Message: "is required"}}, pb.ErrorCode_INVALID_ARGUMENT},
+		{registry.ErrPluginBusy, pb.ErrorCode_BUSY},
+		{registry.ErrNotLeader, pb.ErrorCode_UNAVAILABLE},
+		{context.Canceled, pb.ErrorCode_CANCELLED},
+		{context.DeadlineExceeded, pb.ErrorCode_CANCELLED},
+		{errors.New("No such image"), pb.ErrorCode_INTERNAL},
+	}
+	for _, c := range cases {
+		if code := registry.ErrorCode(c.err); code != c.code {
+			t.Errorf("Invalid code for %v : %v", c.err, code)
+		}
+	}
+}
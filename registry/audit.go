@@ -0,0 +1,614 @@
+package registry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats"
+)
+
+const (
+	/*
+	 * Suggested file where the administration actions are recorded,
+	 * when the audit log is enabled.
+	 */
+	AUDIT_LOG_PATH = "/var/log/agilestack/audit.log"
+
+	/*
+	 * Number of entries returned by a query without limit, and maximum
+	 * number of entries returned by a query.
+	 */
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 10000
+)
+
+/*
+ * Topics whose requests are recorded in the audit log, whatever their
+ * version and encoding, with the action of their entries.
+ *
+ * The configuration of a plugin is part of its image : a creation
+ * changing the configuration of an existing plugin is recorded with the
+ * "configure" action.
+ */
+var auditedTopics = map[string]string{
+	pb.InstallPluginTopic:   "install",
+	pb.UninstallPluginTopic: "uninstall",
+	pb.CreatePlugin:         "create",
+	pb.PushPluginTopic:      "push",
+	pb.DeletePluginTopic:    "delete",
+}
+
+const configureAction = "configure"
+
+/*
+ * Value replacing the build arguments in the audited requests, as they
+ * may hold secrets.
+ */
+const redactedValue = "REDACTED"
+
+func isAudited(topic string) bool {
+	_, ok := auditedTopics[pb.BaseTopic(topic)]
+	return ok
+}
+
+type AuditOptions struct {
+	/*
+	 * Path of the audit log. When empty, the default, nothing
+	 * is recorded.
+	 */
+	Path string
+
+	/*
+	 * Size in bytes above which the log is rotated : the current file is
+	 * renamed with the ".1" suffix, the previous ".1" file with the ".2"
+	 * suffix, and so on.
+	 */
+	MaxSize int64
+
+	/*
+	 * Number of rotated files kept, the older ones being removed.
+	 * Zero means no limit.
+	 */
+	MaxBackups int
+}
+
+/*
+ * Returns the options of a disabled audit log : the path must be set
+ * to enable it.
+ */
+func DefaultAuditOptions() AuditOptions {
+	return AuditOptions{
+		MaxSize:    10 * 1024 * 1024,
+		MaxBackups: 10,
+	}
+}
+
+/*
+ * Error returned when an entry is recorded in a closed audit log.
+ */
+var ErrAuditLogClosed = errors.New("The audit log is closed")
+
+/*
+ * Append-only log of the administration actions, one JSON entry
+ * per line.
+ */
+type AuditLog struct {
+	options AuditOptions
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+/*
+ * Opens the audit log, creating it if needed. The new entries are
+ * appended to the existing ones.
+ */
+func OpenAuditLog(options AuditOptions) (*AuditLog, error) {
+	auditLog := &AuditLog{options: options}
+	if err := os.MkdirAll(filepath.Dir(options.Path), 0755); err != nil {
+		return nil, err
+	}
+	if err := auditLog.open(); err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+func (auditLog *AuditLog) open() error {
+	file, err := os.OpenFile(auditLog.options.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	auditLog.file = file
+	auditLog.size = info.Size()
+	return nil
+}
+
+/*
+ * Appends an entry to the log, rotating the log first if the entry
+ * would make it exceed its maximum size.
+ */
+func (auditLog *AuditLog) Record(entry *pb.AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	if auditLog.file == nil {
+		return ErrAuditLogClosed
+	}
+	if auditLog.options.MaxSize > 0 && auditLog.size > 0 && auditLog.size+int64(len(line)) > auditLog.options.MaxSize {
+		if err := auditLog.rotate(); err != nil {
+			return fmt.Errorf("Error while rotating the audit log : %v", err)
+		}
+	}
+	written, err := auditLog.file.Write(line)
+	auditLog.size += int64(written)
+	return err
+}
+
+func (auditLog *AuditLog) rotate() error {
+	if err := auditLog.file.Close(); err != nil {
+		return err
+	}
+	auditLog.file = nil
+
+	backups := auditLog.backups()
+	if auditLog.options.MaxBackups > 0 {
+		for len(backups) >= auditLog.options.MaxBackups {
+			if err := os.Remove(backups[len(backups)-1]); err != nil {
+				return err
+			}
+			backups = backups[:len(backups)-1]
+		}
+	}
+	for index := len(backups); index > 0; index-- {
+		if err := os.Rename(backups[index-1], auditLog.backupPath(index+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(auditLog.options.Path, auditLog.backupPath(1)); err != nil {
+		return err
+	}
+	return auditLog.open()
+}
+
+/*
+ * Returns the existing rotated files, from the most recent to the oldest.
+ */
+func (auditLog *AuditLog) backups() []string {
+	var backups []string
+	for index := 1; ; index++ {
+		path := auditLog.backupPath(index)
+		if _, err := os.Stat(path); err != nil {
+			return backups
+		}
+		backups = append(backups, path)
+	}
+}
+
+func (auditLog *AuditLog) backupPath(index int) string {
+	return fmt.Sprintf("%s.%d", auditLog.options.Path, index)
+}
+
+/*
+ * Returns the entries matching the given filters, from the oldest to
+ * the most recent. When there are more entries than the query's limit,
+ * the most recent ones are returned.
+ */
+func (auditLog *AuditLog) Query(query *pb.AuditQuery) ([]*pb.AuditEntry, error) {
+	limit := int(query.Limit)
+	if limit <= 0 {
+		limit = defaultAuditQueryLimit
+	} else if limit > maxAuditQueryLimit {
+		limit = maxAuditQueryLimit
+	}
+
+	/*
+	 * The log is not rotated while it is read.
+	 */
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	backups := auditLog.backups()
+	paths := []string{auditLog.options.Path}
+	for _, backup := range backups {
+		paths = append([]string{backup}, paths...)
+	}
+
+	var entries []*pb.AuditEntry
+	for _, path := range paths {
+		err := readAuditEntries(path, func(entry *pb.AuditEntry) {
+			if !matchesAuditQuery(entry, query) {
+				return
+			}
+			entries = append(entries, entry)
+			if len(entries) > limit {
+				entries = entries[1:]
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func readAuditEntries(path string, handle func(entry *pb.AuditEntry)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		entry := &pb.AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return fmt.Errorf("Invalid entry in %s : %v", path, err)
+		}
+		handle(entry)
+	}
+	return scanner.Err()
+}
+
+func matchesAuditQuery(entry *pb.AuditEntry, query *pb.AuditQuery) bool {
+	return (query.Plugin == "" || entry.Plugin == query.Plugin) &&
+		(query.Actor == "" || entry.Actor == query.Actor) &&
+		(query.Since == 0 || entry.Timestamp >= query.Since) &&
+		(query.Until == 0 || entry.Timestamp < query.Until)
+}
+
+func (auditLog *AuditLog) Close() error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	if auditLog.file == nil {
+		return nil
+	}
+	err := auditLog.file.Close()
+	auditLog.file = nil
+	return err
+}
+
+/*
+ * Subscribes to the "core.audit.query" topic.
+ *
+ * Replies with the entries of the audit log matching the query.
+ */
+func (subscriber *natsSubscriber) subscribeToAuditQuery() {
+	subscriber.subscribe(pb.AuditQueryTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, query *pb.AuditQuery) {
+			if subscriber.audit == nil {
+				connection.Publish(reply, &pb.AuditEntries{Error: "The audit log is disabled"})
+				return
+			}
+			entries, err := subscriber.audit.Query(query)
+			if err != nil {
+				log.Println("Error while reading the audit log.", err)
+				connection.Publish(reply, &pb.AuditEntries{Error: err.Error()})
+				return
+			}
+			connection.Publish(reply, &pb.AuditEntries{Entries: entries})
+		}
+	})
+}
+
+/*
+ * Records the administration requests in the audit log, whether they are
+ * received on the NATS topics, by the gRPC server or by the REST gateway.
+ */
+type Auditor struct {
+	auditLog *AuditLog
+
+	/*
+	 * Lists the images of the plugins, to tell the configuration changes
+	 * from the creations.
+	 */
+	storageClient PluginStorageClient
+}
+
+func NewAuditor(auditLog *AuditLog, storageClient PluginStorageClient) *Auditor {
+	return &Auditor{auditLog: auditLog, storageClient: storageClient}
+}
+
+/*
+ * Request being recorded : its entry is written once the request is
+ * handled.
+ */
+type auditedCall struct {
+	auditor *Auditor
+	entry   *pb.AuditEntry
+	start   time.Time
+}
+
+/*
+ * Starts the recording of a request on the given topic.
+ */
+func (auditor *Auditor) begin(topic string) *auditedCall {
+	start := time.Now()
+	operation := pb.BaseTopic(topic)
+	return &auditedCall{
+		auditor: auditor,
+		entry:   &pb.AuditEntry{Timestamp: start.Unix(), Operation: operation, Action: auditedTopics[operation]},
+		start:   start,
+	}
+}
+
+/*
+ * Starts the recording of a request received by the gRPC server or the
+ * REST gateway, whose requester is held by the context. Does nothing
+ * when the auditor is nil.
+ */
+func (auditor *Auditor) audit(ctx context.Context, topic string, request interface{}) *auditedCall {
+	if auditor == nil {
+		return nil
+	}
+	call := auditor.begin(topic)
+	call.identify(RequesterFromContext(ctx))
+	call.describe(ctx, request)
+	return call
+}
+
+func (call *auditedCall) identify(requester *Requester) {
+	if requester != nil {
+		call.entry.Actor = requester.Name
+		call.entry.Role = requester.Role.String()
+	}
+}
+
+/*
+ * Sets the plugin and the request of the entry. Must be called before the
+ * request is handled, as a creation is a configuration change only when
+ * the plugin already exists.
+ */
+func (call *auditedCall) describe(ctx context.Context, request interface{}) {
+	call.entry.Plugin = auditedPlugin(request)
+	call.entry.Request = auditedRequest(request)
+	if newPluginRequest, ok := request.(*pb.NewPluginRequest); ok && newPluginRequest.Configuration != nil &&
+		call.auditor.imageExists(ctx, PLUGIN_IMAGE_PREFIX+newPluginRequest.Name) {
+		call.entry.Action = configureAction
+	}
+}
+
+/*
+ * Records the entry with the result of the request : the response, or
+ * the error returned instead. Does nothing when the call is nil.
+ */
+func (call *auditedCall) end(response interface{}, err error) {
+	if call == nil {
+		return
+	}
+	setAuditResult(call.entry, response)
+	if err != nil {
+		call.entry.Status = false
+		call.entry.Error = err.Error()
+	}
+	call.record()
+}
+
+func (call *auditedCall) record() {
+	call.entry.DurationMs = int64(time.Since(call.start) / time.Millisecond)
+	if err := call.auditor.auditLog.Record(call.entry); err != nil {
+		log.Printf("Error while recording the request on %s in the audit log : %v", call.entry.Operation, err)
+	}
+}
+
+func (auditor *Auditor) imageExists(ctx context.Context, imageName string) bool {
+	plugins, err := auditor.storageClient.ListInstallablePlugins(ctx)
+	if err != nil {
+		log.Printf("Error while listing the plugins of the audit log : %v", err)
+		return false
+	}
+	for _, plugin := range plugins.Plugins {
+		if plugin.Name == imageName {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Returns the handler of an audited topic : each request is recorded in
+ * the audit log once handled, with its requester, its response and its
+ * duration. When the authentication is enabled, the rejected requests are
+ * recorded too.
+ */
+func (subscriber *natsSubscriber) auditedHandler(connection *nats.EncodedConn, topic string, newHandler handlerFactory) nats.Handler {
+	encoder := connection.Enc
+	if subscriber.auth != nil {
+		encoder = subscriber.auth.replies(connection).Enc
+	}
+
+	return func(message *nats.Msg) {
+		call := subscriber.auditor.begin(topic)
+		defer call.record()
+
+		/*
+		 * The handler is created for each request, so that its response
+		 * is set as the entry's result.
+		 */
+		replies := &nats.EncodedConn{Conn: connection.Conn, Enc: &auditEncoder{encoder: encoder, reply: message.Reply, entry: call.entry}}
+		handler := newHandler(replies)
+
+		payload := message.Data
+		var authErr *pb.AuthError
+		if subscriber.auth != nil {
+			var requester *Requester
+			requester, payload, authErr = subscriber.auth.check(connection, topic, message)
+			call.identify(requester)
+		}
+
+		request, err := decodeRequest(connection.Enc, handler, message, payload)
+		if err == nil {
+			call.describe(subscriber.context, request)
+		}
+
+		if authErr != nil {
+			subscriber.auth.reject(connection, message, authErr)
+			call.entry.Error = authErr.Error()
+			return
+		}
+		if err != nil {
+			log.Printf("Error while decoding the request on %s : %v", message.Subject, err)
+			call.entry.Error = "Invalid request : " + err.Error()
+			subscriber.rejectInvalidRequest(connection, topic, message, err)
+			return
+		}
+		callHandler(handler, message, request)
+	}
+}
+
+/*
+ * Returns the JSON form of an audited request. The values of the build
+ * arguments are redacted and the binary fields, such as the archives'
+ * data, are left out.
+ */
+func auditedRequest(request interface{}) string {
+	message, ok := request.(proto.Message)
+	if !ok {
+		return ""
+	}
+	message = proto.Clone(message)
+	if newPluginRequest, ok := message.(*pb.NewPluginRequest); ok {
+		for name := range newPluginRequest.BuildArgs {
+			newPluginRequest.BuildArgs[name] = redactedValue
+		}
+	}
+	dropBlobs(reflect.ValueOf(message))
+
+	marshaler := jsonpb.Marshaler{}
+	text, err := marshaler.MarshalToString(message)
+	if err != nil {
+		return ""
+	}
+	return text
+}
+
+/*
+ * Clears the byte slices of a message and of its nested messages.
+ */
+func dropBlobs(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			dropBlobs(value.Elem())
+		}
+	case reflect.Struct:
+		for index := 0; index < value.NumField(); index++ {
+			if field := value.Field(index); field.CanSet() {
+				dropBlobs(field)
+			}
+		}
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			value.Set(reflect.Zero(value.Type()))
+			return
+		}
+		for index := 0; index < value.Len(); index++ {
+			dropBlobs(value.Index(index))
+		}
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			element := value.MapIndex(key)
+			if element.Kind() == reflect.Ptr {
+				dropBlobs(element)
+			}
+		}
+	}
+}
+
+/*
+ * Returns the name of the plugin targeted by an audited request.
+ */
+func auditedPlugin(request interface{}) string {
+	switch request := request.(type) {
+	case *pb.InstallPluginRequest:
+		if request.Plugin != nil {
+			return request.Plugin.Name
+		}
+	case *pb.InstallRequest:
+		return request.Name
+	case *pb.Plugin:
+		return request.Name
+	case *pb.UninstallRequest:
+		return request.Name
+	case *pb.NewPluginRequest:
+		return request.Name
+	case *pb.PushPluginRequest:
+		return request.Name
+	case *pb.DeletePluginRequest:
+		return request.Name
+	}
+	return ""
+}
+
+/*
+ * Sets the result of an audited request from its response.
+ */
+func setAuditResult(entry *pb.AuditEntry, response interface{}) {
+	switch response := response.(type) {
+	case *pb.NetResponse:
+		entry.Status = response.Response == pb.Responses_ACK
+		entry.Error = response.Details
+	case *pb.OperationResponse:
+		entry.Status = response.Status
+		entry.Error = response.Error
+	case *pb.NewPluginResponse:
+		entry.Status = response.Status
+		entry.Error = response.Error
+	case *pb.PushPluginResponse:
+		entry.Status = response.Status
+		entry.Error = response.Error
+	case *pb.DeletePluginResponse:
+		entry.Status = response.Status
+		entry.Error = response.Error
+	}
+}
+
+/*
+ * Encoder of the replies to an audited request : the response is set
+ * as the result of the request's entry before being encoded.
+ */
+type auditEncoder struct {
+	encoder nats.Encoder
+	reply   string
+	entry   *pb.AuditEntry
+}
+
+func (encoder *auditEncoder) Encode(subject string, v interface{}) ([]byte, error) {
+	if subject == encoder.reply {
+		setAuditResult(encoder.entry, v)
+	}
+	return encoder.encoder.Encode(subject, v)
+}
+
+func (encoder *auditEncoder) Decode(subject string, data []byte, vPtr interface{}) error {
+	return encoder.encoder.Decode(subject, data, vPtr)
+}
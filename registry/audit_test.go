@@ -0,0 +1,159 @@
+package registry_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+)
+
+/*
+ * Opens an audit log in a temporary directory. Returns the log, its
+ * options and the function removing the directory.
+ */
+func openTestAuditLog(t *testing.T, options registry.AuditOptions) (*registry.AuditLog, registry.AuditOptions, func()) {
+	directory, err := ioutil.TempDir("", "agilestack-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Path = filepath.Join(directory, "audit.log")
+	auditLog, err := registry.OpenAuditLog(options)
+	if err != nil {
+		os.RemoveAll(directory)
+		t.Fatalf("Error while opening the audit log : %v", err)
+	}
+	return auditLog, options, func() {
+		auditLog.Close()
+		os.RemoveAll(directory)
+	}
+}
+
+func auditTimestamps(entries []*pb.AuditEntry) []int64 {
+	timestamps := []int64{}
+	for _, entry := range entries {
+		timestamps = append(timestamps, entry.Timestamp)
+	}
+	return timestamps
+}
+
+/*
+ * Tests that the entries are filtered by plugin, actor and time range,
+ * the most recent ones being returned.
+ */
+func TestAuditLogQuery(t *testing.T) {
+	auditLog, _, cleanUp := openTestAuditLog(t, registry.AuditOptions{})
+	defer cleanUp()
+
+	entries := []*pb.AuditEntry{
+		{Timestamp: 100, Operation: pb.InstallPluginTopic, Plugin: "todo", Actor: "alice", Status: true},
+		{Timestamp: 200, Operation: pb.InstallPluginTopic, Plugin: "blog", Actor: "bob", Status: true},
+		{Timestamp: 300, Operation: pb.UninstallPluginTopic, Plugin: "todo", Actor: "bob", Error: "Plugin not installed"},
+		{Timestamp: 400, Operation: pb.CreatePlugin, Plugin: "todo", Actor: "alice", Request: `{"name":"todo"}`, Status: true, DurationMs: 1500},
+	}
+	for _, entry := range entries {
+		if err := auditLog.Record(entry); err != nil {
+			t.Fatalf("Error while recording %v : %v", entry, err)
+		}
+	}
+
+	queries := map[string]struct {
+		query      *pb.AuditQuery
+		timestamps []int64
+	}{
+		"no filter":  {&pb.AuditQuery{}, []int64{100, 200, 300, 400}},
+		"plugin":     {&pb.AuditQuery{Plugin: "todo"}, []int64{100, 300, 400}},
+		"actor":      {&pb.AuditQuery{Actor: "bob"}, []int64{200, 300}},
+		"both":       {&pb.AuditQuery{Plugin: "todo", Actor: "alice"}, []int64{100, 400}},
+		"time range": {&pb.AuditQuery{Since: 200, Until: 400}, []int64{200, 300}},
+		"limit":      {&pb.AuditQuery{Plugin: "todo", Limit: 2}, []int64{300, 400}},
+		"no match":   {&pb.AuditQuery{Actor: "carol"}, []int64{}},
+	}
+	for name, testCase := range queries {
+		result, err := auditLog.Query(testCase.query)
+		if err != nil {
+			t.Fatalf("%s : error while querying the audit log : %v", name, err)
+		}
+		if timestamps := auditTimestamps(result); !reflect.DeepEqual(timestamps, testCase.timestamps) {
+			t.Errorf("%s : invalid entries : %v", name, timestamps)
+		}
+	}
+
+	result, _ := auditLog.Query(&pb.AuditQuery{Since: 400})
+	if len(result) != 1 || !reflect.DeepEqual(result[0], entries[3]) {
+		t.Errorf("Invalid entry : %v", result)
+	}
+}
+
+/*
+ * Tests that the log is rotated when it exceeds its maximum size, that
+ * only the configured number of rotated files is kept, and that the
+ * rotated files are queried.
+ */
+func TestAuditLogRotation(t *testing.T) {
+	auditLog, options, cleanUp := openTestAuditLog(t, registry.AuditOptions{MaxSize: 200, MaxBackups: 2})
+	defer cleanUp()
+
+	for timestamp := int64(1); timestamp <= 20; timestamp++ {
+		entry := &pb.AuditEntry{Timestamp: timestamp, Operation: pb.InstallPluginTopic, Plugin: "todo", Actor: "alice"}
+		if err := auditLog.Record(entry); err != nil {
+			t.Fatalf("Error while recording %v : %v", entry, err)
+		}
+	}
+
+	for _, path := range []string{options.Path, options.Path + ".1", options.Path + ".2"} {
+		if info, err := os.Stat(path); err != nil || info.Size() > options.MaxSize {
+			t.Errorf("Invalid file %s : %v, %v", path, info, err)
+		}
+	}
+	if _, err := os.Stat(options.Path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Only two rotated files should be kept : %v", err)
+	}
+
+	entries, err := auditLog.Query(&pb.AuditQuery{})
+	if err != nil {
+		t.Fatalf("Error while querying the audit log : %v", err)
+	}
+	timestamps := auditTimestamps(entries)
+	if len(timestamps) == 0 || len(timestamps) >= 20 || timestamps[len(timestamps)-1] != 20 {
+		t.Fatalf("The oldest entries only should have been removed : %v", timestamps)
+	}
+	for index := 1; index < len(timestamps); index++ {
+		if timestamps[index] != timestamps[index-1]+1 {
+			t.Errorf("The entries should be consecutive : %v", timestamps)
+		}
+	}
+}
+
+/*
+ * Tests that the entries are appended to the existing log when it is
+ * opened again.
+ */
+func TestAuditLogReopen(t *testing.T) {
+	auditLog, options, cleanUp := openTestAuditLog(t, registry.AuditOptions{})
+	defer cleanUp()
+
+	auditLog.Record(&pb.AuditEntry{Timestamp: 1, Plugin: "todo"})
+	auditLog.Close()
+	if err := auditLog.Record(&pb.AuditEntry{Timestamp: 2, Plugin: "todo"}); err == nil {
+		t.Errorf("No entry should be recorded once the log is closed")
+	}
+
+	reopened, err := registry.OpenAuditLog(options)
+	if err != nil {
+		t.Fatalf("Error while opening the audit log again : %v", err)
+	}
+	defer reopened.Close()
+	reopened.Record(&pb.AuditEntry{Timestamp: 3, Plugin: "todo"})
+
+	entries, err := reopened.Query(&pb.AuditQuery{})
+	if err != nil {
+		t.Fatalf("Error while querying the audit log : %v", err)
+	}
+	if timestamps := auditTimestamps(entries); !reflect.DeepEqual(timestamps, []int64{1, 3}) {
+		t.Errorf("Invalid entries : %v", timestamps)
+	}
+}
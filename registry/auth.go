@@ -0,0 +1,236 @@
+package registry
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/golang-jwt/jwt"
+)
+
+/*
+ * Keys with which the tokens of the requesters are signed. The
+ * authentication is enabled when at least one key is configured.
+ */
+type AuthOptions struct {
+	/*
+	 * Secret of the tokens signed with HMAC (HS256, HS384 and HS512).
+	 */
+	HMACSecret []byte
+
+	/*
+	 * Public key of the tokens signed with RSA (RS256, RS384 and RS512).
+	 */
+	RSAPublicKey *rsa.PublicKey
+}
+
+func (options AuthOptions) Enabled() bool {
+	return len(options.HMACSecret) > 0 || options.RSAPublicKey != nil
+}
+
+/*
+ * Role of a requester. Each role is allowed the operations of the
+ * roles below it.
+ */
+type Role int
+
+const (
+	/*
+	 * Lists the plugins and reads their configuration and build logs.
+	 */
+	RoleViewer Role = iota + 1
+
+	/*
+	 * Installs and uninstalls the plugins.
+	 */
+	RoleOperator
+
+	/*
+	 * Creates, pushes and deletes the plugins' images, and collects
+	 * the garbage.
+	 */
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if roleName == name {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid role : %q", name)
+}
+
+func (role Role) String() string {
+	return roleNames[role]
+}
+
+/*
+ * Returns a boolean indicating whether or not the role is allowed the
+ * operations of the given role.
+ */
+func (role Role) Includes(required Role) bool {
+	return role >= required
+}
+
+/*
+ * Roles required by the topics, whatever their version and encoding.
+ * The topics that are not listed require the "admin" role.
+ */
+var topicRoles = map[string]Role{
+	pb.ListAvailablePluginsTopic:   RoleViewer,
+	pb.ListInstalledPluginsTopic:   RoleViewer,
+	pb.ListPluginTemplatesTopic:    RoleViewer,
+	pb.GetBuildLogTopic:            RoleViewer,
+	pb.GetPluginConfigurationTopic: RoleViewer,
+	pb.APIVersionsTopic:            RoleViewer,
+	pb.InstallPluginTopic:          RoleOperator,
+	pb.UninstallPluginTopic:        RoleOperator,
+	pb.CreatePlugin:                RoleAdmin,
+	pb.UploadArchiveTopic:          RoleAdmin,
+	pb.PushPluginTopic:             RoleAdmin,
+	pb.DeletePluginTopic:           RoleAdmin,
+	pb.GarbageCollectionTopic:      RoleAdmin,
+	pb.AuditQueryTopic:             RoleAdmin,
+	pb.PluginEventsTopic:           RoleViewer,
+}
+
+/*
+ * Returns the role required by the requests of the given topic.
+ */
+func RequiredRole(topic string) Role {
+	if role, ok := topicRoles[pb.BaseTopic(topic)]; ok {
+		return role
+	}
+	return RoleAdmin
+}
+
+/*
+ * Claims of the requesters' tokens : the requester is the subject of
+ * the token.
+ */
+type Claims struct {
+	Role string `json:"role"`
+	jwt.StandardClaims
+}
+
+/*
+ * Requester of an authenticated request.
+ */
+type Requester struct {
+	Name string
+	Role Role
+}
+
+/*
+ * Checks the tokens of the requesters.
+ */
+type TokenVerifier struct {
+	options AuthOptions
+}
+
+func NewTokenVerifier(options AuthOptions) *TokenVerifier {
+	return &TokenVerifier{options: options}
+}
+
+/*
+ * Checks the signature, the validity period and the claims of the given
+ * token, and returns the requester it identifies. The token must have
+ * an expiration time.
+ *
+ * The signing method must match one of the configured keys, so that an
+ * RSA public key is never used as an HMAC secret.
+ */
+func (verifier *TokenVerifier) Verify(tokenString string) (*Requester, error) {
+	if tokenString == "" {
+		return nil, errors.New("The request has no token")
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if len(verifier.options.HMACSecret) > 0 {
+				return verifier.options.HMACSecret, nil
+			}
+		case *jwt.SigningMethodRSA:
+			if verifier.options.RSAPublicKey != nil {
+				return verifier.options.RSAPublicKey, nil
+			}
+		}
+		return nil, fmt.Errorf("Unexpected signing method : %v", token.Header["alg"])
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token : %v", err)
+	}
+
+	/*
+	 * A token without expiration time would be valid forever.
+	 */
+	if claims.ExpiresAt == 0 {
+		return nil, errors.New("Invalid token : no expiration time")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("Invalid token : no subject")
+	}
+	role, err := ParseRole(claims.Role)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token : %v", err)
+	}
+	return &Requester{Name: claims.Subject, Role: role}, nil
+}
+
+/*
+ * Returns an error if the given requester is not allowed to send
+ * requests on the given topic.
+ */
+func Authorize(requester *Requester, topic string) *pb.AuthError {
+	required := RequiredRole(topic)
+	if requester.Role.Includes(required) {
+		return nil
+	}
+	return &pb.AuthError{
+		Code:         pb.ErrorCode_PERMISSION_DENIED,
+		Message:      fmt.Sprintf("The role %s of %s does not allow the requests on %s", requester.Role, requester.Name, topic),
+		RequiredRole: required.String(),
+	}
+}
+
+/*
+ * Checks the token of an HTTP "Authorization" header or of the
+ * "authorization" gRPC metadata, of the form "Bearer <token>".
+ */
+func (verifier *TokenVerifier) VerifyBearer(authorization string) (*Requester, error) {
+	const prefix = "bearer "
+	if len(authorization) < len(prefix) || strings.ToLower(authorization[:len(prefix)]) != prefix {
+		return nil, errors.New("The request has no bearer token")
+	}
+	return verifier.Verify(strings.TrimSpace(authorization[len(prefix):]))
+}
+
+type requesterKey struct{}
+
+/*
+ * Returns a context holding the requester of the request being handled.
+ */
+func withRequester(ctx context.Context, requester *Requester) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+/*
+ * Returns the requester of the request being handled, nil when the
+ * authentication is disabled.
+ */
+func RequesterFromContext(ctx context.Context) *Requester {
+	requester, _ := ctx.Value(requesterKey{}).(*Requester)
+	return requester
+}
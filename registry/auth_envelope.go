@@ -0,0 +1,222 @@
+package registry
+
+import (
+	"errors"
+	"log"
+	"reflect"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/nats-io/nats"
+)
+
+/*
+ * Encoder of the replies to the authenticated requests : the response
+ * is encoded with the encoder of the topic, and sent in a
+ * "ResponseEnvelope".
+ */
+type envelopeEncoder struct {
+	encoder nats.Encoder
+}
+
+func (encoder *envelopeEncoder) Encode(subject string, v interface{}) ([]byte, error) {
+	payload, err := encoder.encoder.Encode(subject, v)
+	if err != nil {
+		return nil, err
+	}
+	return encoder.encoder.Encode(subject, &pb.ResponseEnvelope{Payload: payload})
+}
+
+func (encoder *envelopeEncoder) Decode(subject string, data []byte, vPtr interface{}) error {
+	envelope := &pb.ResponseEnvelope{}
+	if err := encoder.encoder.Decode(subject, data, envelope); err != nil {
+		return err
+	}
+	if envelope.Error != nil {
+		return envelope.Error
+	}
+	return encoder.encoder.Decode(subject, envelope.Payload, vPtr)
+}
+
+/*
+ * Checks the token and the role of the requests before handing them
+ * to the handlers of the topics.
+ */
+type authGuard struct {
+	verifier *TokenVerifier
+}
+
+func newAuthGuard(options AuthOptions) *authGuard {
+	return &authGuard{verifier: NewTokenVerifier(options)}
+}
+
+/*
+ * Returns the handler of the given topic's requests, sent in a
+ * "RequestEnvelope".
+ *
+ * The rejected requests are replied to with a "ResponseEnvelope" holding
+ * the error. The other ones are handed to the topic's handler, whose
+ * response is sent in a "ResponseEnvelope".
+ */
+func (guard *authGuard) handler(connection *nats.EncodedConn, topic string, newHandler handlerFactory) nats.Handler {
+	handler := newHandler(guard.replies(connection))
+
+	return func(message *nats.Msg) {
+		requester, payload, authErr := guard.check(connection, topic, message)
+		if authErr != nil {
+			guard.reject(connection, message, authErr)
+			return
+		}
+		if err := invokeHandler(connection.Enc, handler, message, payload); err != nil {
+			log.Printf("Error while decoding the request of %s on %s : %v", requester.Name, message.Subject, err)
+			guard.reject(connection, message, invalidRequestError(err))
+		}
+	}
+}
+
+/*
+ * Returns the connection on which the handlers reply to the
+ * authenticated requests received on the given connection.
+ */
+func (guard *authGuard) replies(connection *nats.EncodedConn) *nats.EncodedConn {
+	return &nats.EncodedConn{Conn: connection.Conn, Enc: &envelopeEncoder{encoder: connection.Enc}}
+}
+
+/*
+ * Checks the token and the role of the request in the given message.
+ *
+ * Returns the requester and the payload of the request, or the reason why
+ * the request is rejected. The requester is known unless the token is
+ * invalid.
+ */
+func (guard *authGuard) check(connection *nats.EncodedConn, topic string, message *nats.Msg) (*Requester, []byte, *pb.AuthError) {
+	envelope := &pb.RequestEnvelope{}
+	if err := connection.Enc.Decode(message.Subject, message.Data, envelope); err != nil {
+		return nil, nil, &pb.AuthError{
+			Code:    pb.ErrorCode_UNAUTHENTICATED,
+			Message: "Invalid request envelope : " + err.Error(),
+		}
+	}
+
+	requester, err := guard.verifier.Verify(envelope.Token)
+	if err != nil {
+		return nil, envelope.Payload, &pb.AuthError{Code: pb.ErrorCode_UNAUTHENTICATED, Message: err.Error()}
+	}
+	if authErr := Authorize(requester, topic); authErr != nil {
+		return requester, envelope.Payload, authErr
+	}
+	return requester, envelope.Payload, nil
+}
+
+func (guard *authGuard) reject(connection *nats.EncodedConn, message *nats.Msg, authErr *pb.AuthError) {
+	log.Printf("Request on %s rejected : %s", message.Subject, authErr.Message)
+	if message.Reply != "" {
+		connection.Publish(message.Reply, &pb.ResponseEnvelope{Error: authErr})
+	}
+}
+
+/*
+ * Replies to a request that could not be decoded : with a
+ * "ResponseEnvelope" holding the error when the authentication is
+ * enabled, with the error response of the topic otherwise.
+ */
+func (subscriber *natsSubscriber) rejectInvalidRequest(connection *nats.EncodedConn, topic string, message *nats.Msg, err error) {
+	if subscriber.auth != nil {
+		subscriber.auth.reject(connection, message, invalidRequestError(err))
+	} else if message.Reply != "" {
+		connection.Publish(message.Reply, invalidRequestResponse(topic, err))
+	}
+}
+
+func invalidRequestError(err error) *pb.AuthError {
+	return &pb.AuthError{Code: pb.ErrorCode_INVALID_ARGUMENT, Message: "Invalid request : " + err.Error()}
+}
+
+/*
+ * Returns the response of the given topic telling that its request could
+ * not be decoded. The topics whose response has no error are replied to
+ * with a "ResponseEnvelope" holding the error.
+ */
+func invalidRequestResponse(topic string, err error) interface{} {
+	message := "Invalid request : " + err.Error()
+	base := pb.BaseTopic(topic)
+	switch base {
+	case pb.InstallPluginTopic, pb.UninstallPluginTopic:
+		if topic == pb.VersionedTopic(pb.APIVersion2, base) || topic == pb.JSONTopic(pb.VersionedTopic(pb.APIVersion2, base)) {
+			return &pb.OperationResponse{Code: pb.ErrorCode_INVALID_ARGUMENT, Error: message}
+		}
+		return &pb.NetResponse{Response: pb.Responses_ERROR, Details: message}
+	case pb.UploadArchiveTopic:
+		return &pb.NetResponse{Response: pb.Responses_ERROR, Details: message}
+	case pb.CreatePlugin:
+		return &pb.NewPluginResponse{Error: message}
+	case pb.PushPluginTopic:
+		return &pb.PushPluginResponse{Error: message}
+	case pb.DeletePluginTopic:
+		return &pb.DeletePluginResponse{Error: message}
+	case pb.GetPluginConfigurationTopic:
+		return &pb.PluginConfigurationResponse{Error: message}
+	case pb.GarbageCollectionTopic:
+		return &pb.GarbageCollectionReport{Error: message}
+	case pb.AuditQueryTopic:
+		return &pb.AuditEntries{Error: message}
+	}
+	return &pb.ResponseEnvelope{Error: invalidRequestError(err)}
+}
+
+var msgType = reflect.TypeOf(&nats.Msg{})
+
+/*
+ * Calls the given handler as an encoded connection would, with the
+ * request decoded from the given payload.
+ */
+func invokeHandler(encoder nats.Encoder, handler nats.Handler, message *nats.Msg, payload []byte) error {
+	request, err := decodeRequest(encoder, handler, message, payload)
+	if err != nil {
+		return err
+	}
+	callHandler(handler, message, request)
+	return nil
+}
+
+/*
+ * Decodes the request of the given handler from the given payload.
+ *
+ * The handlers receive either the message itself, or a pointer to the
+ * decoded request preceded by the subject and the reply subject.
+ */
+func decodeRequest(encoder nats.Encoder, handler nats.Handler, message *nats.Msg, payload []byte) (interface{}, error) {
+	handlerType := reflect.TypeOf(handler)
+	if handlerType == nil || handlerType.Kind() != reflect.Func || handlerType.NumIn() == 0 || handlerType.NumIn() > 3 {
+		return nil, errors.New("Invalid handler")
+	}
+
+	argumentType := handlerType.In(handlerType.NumIn() - 1)
+	if argumentType == msgType {
+		return &nats.Msg{Subject: message.Subject, Reply: message.Reply, Data: payload, Sub: message.Sub}, nil
+	}
+	if argumentType.Kind() != reflect.Ptr {
+		return nil, errors.New("Invalid handler : the request must be a pointer")
+	}
+	request := reflect.New(argumentType.Elem())
+	if err := encoder.Decode(message.Subject, payload, request.Interface()); err != nil {
+		return nil, err
+	}
+	return request.Interface(), nil
+}
+
+/*
+ * Calls the given handler with a request returned by decodeRequest.
+ */
+func callHandler(handler nats.Handler, message *nats.Msg, request interface{}) {
+	handlerValue := reflect.ValueOf(handler)
+	requestValue := reflect.ValueOf(request)
+
+	arguments := []reflect.Value{requestValue}
+	switch handlerValue.Type().NumIn() {
+	case 2:
+		arguments = []reflect.Value{reflect.ValueOf(message.Subject), requestValue}
+	case 3:
+		arguments = []reflect.Value{reflect.ValueOf(message.Subject), reflect.ValueOf(message.Reply), requestValue}
+	}
+	handlerValue.Call(arguments)
+}
@@ -0,0 +1,120 @@
+package registry_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+	"github.com/golang-jwt/jwt"
+)
+
+var testSecret = []byte("agilestack-secret")
+
+/*
+ * Returns a token of the given requester, valid for an hour.
+ */
+func newToken(t *testing.T, method jwt.SigningMethod, key interface{}, subject string, role string) string {
+	return signToken(t, method, key, registry.Claims{
+		Role: role,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   subject,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims registry.Claims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("Error while signing the token : %v", err)
+	}
+	return token
+}
+
+func TestVerifyHMACToken(t *testing.T) {
+	verifier := registry.NewTokenVerifier(registry.AuthOptions{HMACSecret: testSecret})
+
+	requester, err := verifier.Verify(newToken(t, jwt.SigningMethodHS256, testSecret, "alice", "operator"))
+	if err != nil {
+		t.Fatalf("The token should be valid : %v", err)
+	}
+	if requester.Name != "alice" || requester.Role != registry.RoleOperator {
+		t.Errorf("Invalid requester : %v", requester)
+	}
+
+	invalidTokens := map[string]string{
+		"no token":     "",
+		"malformed":    "agilestack",
+		"other secret": newToken(t, jwt.SigningMethodHS256, []byte("other"), "alice", "admin"),
+		"unknown role": newToken(t, jwt.SigningMethodHS256, testSecret, "alice", "root"),
+		"no subject":   newToken(t, jwt.SigningMethodHS256, testSecret, "", "admin"),
+		"no expiration time": signToken(t, jwt.SigningMethodHS256, testSecret, registry.Claims{
+			Role:           "admin",
+			StandardClaims: jwt.StandardClaims{Subject: "alice"},
+		}),
+		"expired token": signToken(t, jwt.SigningMethodHS256, testSecret, registry.Claims{
+			Role:           "admin",
+			StandardClaims: jwt.StandardClaims{Subject: "alice", ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+		}),
+	}
+	for name, token := range invalidTokens {
+		if _, err := verifier.Verify(token); err == nil {
+			t.Errorf("The token should be rejected : %s", name)
+		}
+	}
+}
+
+/*
+ * Tests that the RSA tokens are accepted, and that the HMAC tokens are
+ * rejected when only an RSA key is configured, even if signed with the
+ * public key.
+ */
+func TestVerifyRSAToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := registry.NewTokenVerifier(registry.AuthOptions{RSAPublicKey: &key.PublicKey})
+
+	requester, err := verifier.Verify(newToken(t, jwt.SigningMethodRS256, key, "ci", "admin"))
+	if err != nil {
+		t.Fatalf("The token should be valid : %v", err)
+	}
+	if requester.Name != "ci" || requester.Role != registry.RoleAdmin {
+		t.Errorf("Invalid requester : %v", requester)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(newToken(t, jwt.SigningMethodHS256, publicKey, "ci", "admin")); err == nil {
+		t.Error("An HMAC token should be rejected")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	viewer := &registry.Requester{Name: "alice", Role: registry.RoleViewer}
+	if err := registry.Authorize(viewer, pb.JSONTopic(pb.ListInstalledPluginsTopic)); err != nil {
+		t.Errorf("A viewer should list the plugins : %v", err)
+	}
+	err := registry.Authorize(viewer, pb.VersionedTopic(pb.APIVersion2, pb.InstallPluginTopic))
+	if err == nil || err.Code != pb.ErrorCode_PERMISSION_DENIED || err.RequiredRole != "operator" {
+		t.Errorf("A viewer should not install a plugin : %v", err)
+	}
+
+	operator := &registry.Requester{Name: "bob", Role: registry.RoleOperator}
+	if err := registry.Authorize(operator, pb.UninstallPluginTopic); err != nil {
+		t.Errorf("An operator should uninstall a plugin : %v", err)
+	}
+	if err := registry.Authorize(operator, pb.CreatePlugin); err == nil || err.RequiredRole != "admin" {
+		t.Errorf("An operator should not create a plugin : %v", err)
+	}
+	if role := registry.RequiredRole("core.unknown"); role != registry.RoleAdmin {
+		t.Errorf("The unknown topics should require the admin role : %v", role)
+	}
+}
@@ -0,0 +1,239 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+const (
+	/*
+	 * Directory where the last build log of each plugin is saved.
+	 */
+	BUILD_LOG_DIR = "/var/log/agilestack/builds"
+)
+
+var (
+	/*
+	 * Line written by Docker when a build step begins.
+	 *
+	 * Examples :
+	 * - Step 3 : RUN npm install
+	 * - Step 3/7 : RUN npm install
+	 */
+	buildStepRegexp = regexp.MustCompile(`^Step \d+(/\d+)? : `)
+
+	buildIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+/*
+ * Generates a random build identifier.
+ */
+func NewBuildID() string {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+/*
+ * Checks that the given build identifier can be used as a token
+ * of a NATS subject.
+ */
+func ValidateBuildID(buildID string) error {
+	if !buildIDRegexp.MatchString(buildID) {
+		return errors.New("Invalid build identifier : " + buildID)
+	}
+	return nil
+}
+
+/*
+ * Writer capturing the output of a build.
+ *
+ * The output is split into lines. Each line is kept and given to the
+ * publishing function as soon as it is complete.
+ */
+type BuildLogWriter struct {
+	buildID string
+	publish func(line *pb.BuildLogLine)
+
+	mutex    sync.Mutex
+	pending  bytes.Buffer
+	lines    []string
+	lastStep string
+}
+
+/*
+ * Creates a writer for the given build. The publishing function may be nil.
+ */
+func NewBuildLogWriter(buildID string, publish func(line *pb.BuildLogLine)) *BuildLogWriter {
+	return &BuildLogWriter{
+		buildID: buildID,
+		publish: publish,
+	}
+}
+
+func (writer *BuildLogWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.pending.Write(data)
+	for {
+		index := bytes.IndexByte(writer.pending.Bytes(), '\n')
+		if index < 0 {
+			break
+		}
+		line := string(writer.pending.Next(index + 1))
+		writer.addLine(line[:len(line)-1])
+	}
+	return len(data), nil
+}
+
+/*
+ * Adds a line that is not part of Docker's output, such as an error
+ * occurring before the build.
+ */
+func (writer *BuildLogWriter) WriteLine(line string) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.addLine(line)
+}
+
+/*
+ * Flushes the last line if it does not end with a line break.
+ */
+func (writer *BuildLogWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.pending.Len() > 0 {
+		writer.addLine(writer.pending.String())
+		writer.pending.Reset()
+	}
+	return nil
+}
+
+func (writer *BuildLogWriter) addLine(line string) {
+	line = trimCarriageReturn(line)
+	if buildStepRegexp.MatchString(line) {
+		writer.lastStep = line
+	}
+	writer.lines = append(writer.lines, line)
+
+	if writer.publish != nil {
+		writer.publish(&pb.BuildLogLine{
+			BuildId:  writer.buildID,
+			Sequence: int64(len(writer.lines)),
+			Line:     line,
+		})
+	}
+}
+
+/*
+ * Returns the lines written so far.
+ */
+func (writer *BuildLogWriter) Lines() []string {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	lines := make([]string, len(writer.lines))
+	copy(lines, writer.lines)
+	return lines
+}
+
+/*
+ * Returns the last build step that began, or an empty string if
+ * no step began.
+ */
+func (writer *BuildLogWriter) LastStep() string {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.lastStep
+}
+
+func trimCarriageReturn(line string) string {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		return line[:len(line)-1]
+	}
+	return line
+}
+
+/*
+ * Storage of the last build log of each plugin.
+ *
+ * The logs are kept in memory and saved as JSON files, one per plugin,
+ * so that they survive a restart of core.
+ */
+type BuildLogStore struct {
+	directory string
+
+	mutex sync.RWMutex
+	logs  map[string]*pb.BuildLog
+}
+
+func NewBuildLogStore(directory string) *BuildLogStore {
+	return &BuildLogStore{
+		directory: directory,
+		logs:      make(map[string]*pb.BuildLog),
+	}
+}
+
+/*
+ * Saves the given log as the last log of its plugin.
+ *
+ * The log is kept in memory even if it cannot be written on disk.
+ */
+func (store *BuildLogStore) Save(buildLog *pb.BuildLog) error {
+	store.mutex.Lock()
+	store.logs[buildLog.Name] = buildLog
+	store.mutex.Unlock()
+
+	content, err := json.Marshal(buildLog)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(store.directory, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path(buildLog.Name), content, 0644)
+}
+
+/*
+ * Returns the last build log of the given plugin.
+ *
+ * Returns "nil" and no error if the plugin has never been built.
+ */
+func (store *BuildLogStore) Get(pluginName string) (*pb.BuildLog, error) {
+	store.mutex.RLock()
+	buildLog, ok := store.logs[pluginName]
+	store.mutex.RUnlock()
+	if ok {
+		return buildLog, nil
+	}
+
+	content, err := ioutil.ReadFile(store.path(pluginName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buildLog = &pb.BuildLog{}
+	if err := json.Unmarshal(content, buildLog); err != nil {
+		return nil, err
+	}
+	return buildLog, nil
+}
+
+func (store *BuildLogStore) path(pluginName string) string {
+	return filepath.Join(store.directory, filepath.Base(pluginName)+".json")
+}
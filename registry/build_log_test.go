@@ -0,0 +1,111 @@
+package registry_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+)
+
+/*
+ * Tests that the output is split into lines, whatever the way
+ * it is written.
+ */
+func TestBuildLogWriterLines(t *testing.T) {
+	var published []*pb.BuildLogLine
+	writer := registry.NewBuildLogWriter("build-1", func(line *pb.BuildLogLine) {
+		published = append(published, line)
+	})
+
+	writer.Write([]byte("Step 1/2 : FROM scratch\n ---> abc"))
+	writer.Write([]byte("def\r\nStep 2/2 : "))
+	writer.Write([]byte("ADD . /app\n"))
+	writer.Write([]byte("Successfully built"))
+	writer.Close()
+
+	expectedLines := []string{
+		"Step 1/2 : FROM scratch",
+		" ---> abcdef",
+		"Step 2/2 : ADD . /app",
+		"Successfully built",
+	}
+	if !reflect.DeepEqual(writer.Lines(), expectedLines) {
+		t.Errorf("Invalid lines : %q", writer.Lines())
+	}
+
+	if len(published) != len(expectedLines) {
+		t.Fatalf("Invalid number of published lines : %d", len(published))
+	}
+	for index, line := range published {
+		if line.BuildId != "build-1" || line.Sequence != int64(index+1) || line.Line != expectedLines[index] {
+			t.Errorf("Invalid published line : %v", line)
+		}
+	}
+}
+
+/*
+ * Tests that the last step is the one being executed.
+ */
+func TestBuildLogWriterLastStep(t *testing.T) {
+	writer := registry.NewBuildLogWriter("build-1", nil)
+	if writer.LastStep() != "" {
+		t.Errorf("No step should have begun : %s", writer.LastStep())
+	}
+
+	writer.Write([]byte("Step 1 : FROM node\n ---> 123\nStep 2 : RUN npm install\nnpm ERR! missing script\n"))
+	if writer.LastStep() != "Step 2 : RUN npm install" {
+		t.Errorf("Invalid last step : %s", writer.LastStep())
+	}
+}
+
+func TestValidateBuildID(t *testing.T) {
+	if err := registry.ValidateBuildID(registry.NewBuildID()); err != nil {
+		t.Errorf("A generated identifier should be valid : %v", err)
+	}
+	for _, buildID := range []string{"", "a.b", "a*", "a b"} {
+		if registry.ValidateBuildID(buildID) == nil {
+			t.Errorf("The identifier %q should be invalid", buildID)
+		}
+	}
+}
+
+/*
+ * Tests that a saved log can be read back, even by another store.
+ */
+func TestBuildLogStore(t *testing.T) {
+	directory, err := ioutil.TempDir("", "agilestack-build-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	store := registry.NewBuildLogStore(directory)
+	buildLog := &pb.BuildLog{
+		Name:       "todo",
+		BuildId:    "build-1",
+		FailedStep: "Step 2 : RUN npm install",
+		Error:      "The command returned a non-zero code: 1",
+		Lines:      []string{"Step 1 : FROM node", "Step 2 : RUN npm install"},
+	}
+	if err := store.Save(buildLog); err != nil {
+		t.Fatalf("Error while saving the log : %v", err)
+	}
+
+	for _, currentStore := range []*registry.BuildLogStore{store, registry.NewBuildLogStore(directory)} {
+		savedLog, err := currentStore.Get("todo")
+		if err != nil {
+			t.Fatalf("Error while reading the log : %v", err)
+		}
+		if !reflect.DeepEqual(savedLog, buildLog) {
+			t.Errorf("Invalid log : %v", savedLog)
+		}
+	}
+
+	unknownLog, err := store.Get("unknown")
+	if unknownLog != nil || err != nil {
+		t.Errorf("No log should be found : %v, %v", unknownLog, err)
+	}
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+const (
+	/*
+	 * Version of the configuration format written by core.
+	 *
+	 * Version 0 is the format of the first plugins, having only
+	 * a name and an URL.
+	 */
+	CurrentVersion = 1
+
+	/*
+	 * Name of the configuration file, at the root of the plugin's sources.
+	 */
+	FileName = "config.json"
+
+	/*
+	 * Label of the plugin's image holding its configuration, so that
+	 * the configuration of an installed plugin can be read from its
+	 * container.
+	 */
+	Label = "io.agilestack.plugin.configuration"
+)
+
+var (
+	permissionRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+	localeRegexp     = regexp.MustCompile(`^[a-z]{2,3}(?:-[A-Z]{2})?$`)
+)
+
+/*
+ * Returns the configuration of a new plugin : a copy of the given
+ * configuration, which may be nil, with the given name and URL and
+ * the current version.
+ */
+func New(name string, url string, base *pb.PluginConfiguration) *pb.PluginConfiguration {
+	configuration := &pb.PluginConfiguration{}
+	if base != nil {
+		*configuration = *base
+	}
+	configuration.Version = CurrentVersion
+	configuration.Name = name
+	configuration.Url = url
+	return configuration
+}
+
+/*
+ * Parses the content of a configuration file, upgrading it to the
+ * current version.
+ */
+func Parse(content []byte) (*pb.PluginConfiguration, error) {
+	configuration := &pb.PluginConfiguration{}
+	if err := json.Unmarshal(content, configuration); err != nil {
+		return nil, fmt.Errorf("Invalid configuration : %v", err)
+	}
+
+	switch configuration.Version {
+	case 0:
+		configuration.Version = CurrentVersion
+	case CurrentVersion:
+	default:
+		return nil, fmt.Errorf("Unsupported configuration version : %d", configuration.Version)
+	}
+
+	if err := Validate(configuration); err != nil {
+		return nil, err
+	}
+	return configuration, nil
+}
+
+/*
+ * Returns the content of the configuration file.
+ */
+func Marshal(configuration *pb.PluginConfiguration) ([]byte, error) {
+	return json.MarshalIndent(configuration, "", "  ")
+}
+
+/*
+ * Checks the configuration against the schema described in
+ * "schema.json".
+ *
+ * Every problem found is reported in the returned error.
+ */
+func Validate(configuration *pb.PluginConfiguration) error {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if configuration.Version != CurrentVersion {
+		report("unsupported version %d", configuration.Version)
+	}
+	if configuration.Name == "" {
+		report("the name is required")
+	}
+
+	permissions := make(map[string]bool)
+	for _, permission := range configuration.Permissions {
+		if !permissionRegexp.MatchString(permission.Name) {
+			report("invalid permission name %q", permission.Name)
+		} else if permissions[permission.Name] {
+			report("duplicate permission %s", permission.Name)
+		}
+		permissions[permission.Name] = true
+	}
+	checkPermission := func(owner string, name string) {
+		if name != "" && !permissions[name] {
+			report("%s requires the undefined permission %s", owner, name)
+		}
+	}
+
+	routes := make(map[string]bool)
+	for _, route := range configuration.Routes {
+		switch {
+		case !strings.HasPrefix(route.Path, "/"):
+			report("the route path %q must start with \"/\"", route.Path)
+		case routes[route.Path]:
+			report("duplicate route %s", route.Path)
+		}
+		if route.Component == "" {
+			report("the route %s has no component", route.Path)
+		}
+		checkPermission("the route "+route.Path, route.Permission)
+		routes[route.Path] = true
+	}
+
+	for _, entry := range configuration.MenuEntries {
+		if entry.Label == "" {
+			report("a menu entry has no label")
+		}
+		if !routes[entry.Route] {
+			report("the menu entry %q targets the undefined route %q", entry.Label, entry.Route)
+		}
+		checkPermission("the menu entry "+entry.Label, entry.Permission)
+	}
+
+	for _, topic := range configuration.ConsumedTopics {
+		if err := validateTopic(topic, true); err != nil {
+			report("invalid consumed topic %q : %v", topic, err)
+		}
+	}
+	for _, topic := range configuration.ProducedTopics {
+		if err := validateTopic(topic, false); err != nil {
+			report("invalid produced topic %q : %v", topic, err)
+		}
+	}
+
+	locales := make(map[string]bool)
+	for _, resource := range configuration.I18N {
+		if !localeRegexp.MatchString(resource.Locale) {
+			report("invalid locale %q", resource.Locale)
+		} else if locales[resource.Locale] {
+			report("duplicate resource for locale %s", resource.Locale)
+		}
+		if resource.Path == "" {
+			report("the resource for locale %s has no path", resource.Locale)
+		}
+		locales[resource.Locale] = true
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Invalid configuration : " + strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+/*
+ * Checks that the given string is a NATS subject. The wildcards are
+ * only allowed when subscribing.
+ */
+func validateTopic(topic string, wildcards bool) error {
+	if topic == "" || strings.ContainsAny(topic, " \t\r\n") {
+		return errors.New("empty subject or whitespace")
+	}
+	tokens := strings.Split(topic, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return errors.New("empty token")
+		case token == "*" || token == ">":
+			if !wildcards {
+				return errors.New("wildcards are not allowed")
+			}
+			if token == ">" && i != len(tokens)-1 {
+				return errors.New("\">\" must be the last token")
+			}
+		case strings.ContainsAny(token, "*>"):
+			return errors.New("wildcards must be whole tokens")
+		}
+	}
+	return nil
+}
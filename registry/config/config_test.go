@@ -0,0 +1,145 @@
+package config_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry/config"
+)
+
+func newConfiguration() *pb.PluginConfiguration {
+	return config.New("todo", "http://todo", &pb.PluginConfiguration{
+		Routes: []*pb.Route{
+			{Path: "/todo", Component: "TodoList", Permission: "todo.read"},
+			{Path: "/todo/new", Component: "TodoForm", Permission: "todo.write"},
+		},
+		MenuEntries: []*pb.MenuEntry{
+			{Label: "todo.menu", Route: "/todo", Icon: "list", Order: 10, Permission: "todo.read"},
+		},
+		ConsumedTopics: []string{"todo.*.created", "users.>"},
+		ProducedTopics: []string{"todo.item.created"},
+		I18N: []*pb.I18NResource{
+			{Locale: "en", Path: "i18n/en.json"},
+			{Locale: "fr-FR", Path: "i18n/fr.json"},
+		},
+		Permissions: []*pb.Permission{
+			{Name: "todo.read"},
+			{Name: "todo.write", Description: "Create and edit the items"},
+		},
+	})
+}
+
+func TestValidConfiguration(t *testing.T) {
+	configuration := newConfiguration()
+	if configuration.Version != config.CurrentVersion || configuration.Name != "todo" {
+		t.Errorf("Invalid new configuration : %v", configuration)
+	}
+	if err := config.Validate(configuration); err != nil {
+		t.Errorf("The configuration should be valid : %v", err)
+	}
+}
+
+func TestMarshalAndParse(t *testing.T) {
+	configuration := newConfiguration()
+	content, err := config.Marshal(configuration)
+	if err != nil {
+		t.Fatalf("Error while marshalling the configuration : %v", err)
+	}
+
+	parsed, err := config.Parse(content)
+	if err != nil {
+		t.Fatalf("Error while parsing the configuration : %v", err)
+	}
+	if !reflect.DeepEqual(parsed, configuration) {
+		t.Errorf("Invalid parsed configuration : %v", parsed)
+	}
+}
+
+/*
+ * Tests that the configuration files written before the versioning
+ * are upgraded.
+ */
+func TestParseLegacyConfiguration(t *testing.T) {
+	parsed, err := config.Parse([]byte(`{"url":"http://todo","name":"todo"}`))
+	if err != nil {
+		t.Fatalf("Error while parsing the configuration : %v", err)
+	}
+	expected := &pb.PluginConfiguration{Version: config.CurrentVersion, Name: "todo", Url: "http://todo"}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("Invalid parsed configuration : %v", parsed)
+	}
+}
+
+func TestParseUnsupportedVersion(t *testing.T) {
+	if _, err := config.Parse([]byte(`{"version":2,"name":"todo"}`)); err == nil {
+		t.Error("A future version should be rejected")
+	}
+}
+
+func TestInvalidConfigurations(t *testing.T) {
+	invalid := map[string]func(configuration *pb.PluginConfiguration){
+		"missing name":          func(c *pb.PluginConfiguration) { c.Name = "" },
+		"relative route":        func(c *pb.PluginConfiguration) { c.Routes[0].Path = "todo" },
+		"duplicate route":       func(c *pb.PluginConfiguration) { c.Routes[1].Path = "/todo" },
+		"route component":       func(c *pb.PluginConfiguration) { c.Routes[0].Component = "" },
+		"route permission":      func(c *pb.PluginConfiguration) { c.Routes[0].Permission = "todo.admin" },
+		"menu entry label":      func(c *pb.PluginConfiguration) { c.MenuEntries[0].Label = "" },
+		"menu entry route":      func(c *pb.PluginConfiguration) { c.MenuEntries[0].Route = "/other" },
+		"menu entry permission": func(c *pb.PluginConfiguration) { c.MenuEntries[0].Permission = "other" },
+		"consumed topic":        func(c *pb.PluginConfiguration) { c.ConsumedTopics[0] = "todo.>.created" },
+		"produced wildcard":     func(c *pb.PluginConfiguration) { c.ProducedTopics[0] = "todo.*" },
+		"empty token":           func(c *pb.PluginConfiguration) { c.ProducedTopics[0] = "todo..created" },
+		"partial wildcard":      func(c *pb.PluginConfiguration) { c.ConsumedTopics[0] = "todo.item*" },
+		"locale":                func(c *pb.PluginConfiguration) { c.I18N[0].Locale = "english" },
+		"duplicate locale":      func(c *pb.PluginConfiguration) { c.I18N[1].Locale = "en" },
+		"resource path":         func(c *pb.PluginConfiguration) { c.I18N[0].Path = "" },
+		"permission name":       func(c *pb.PluginConfiguration) { c.Permissions[0].Name = "Todo Read" },
+		"duplicate permission":  func(c *pb.PluginConfiguration) { c.Permissions[1].Name = "todo.read" },
+		"version":               func(c *pb.PluginConfiguration) { c.Version = 3 },
+	}
+	for name, change := range invalid {
+		configuration := newConfiguration()
+		change(configuration)
+		if err := config.Validate(configuration); err == nil {
+			t.Errorf("The configuration should be invalid : %s", name)
+		}
+	}
+}
+
+/*
+ * Tests that the schema describes the fields of the configuration.
+ */
+func TestSchemaProperties(t *testing.T) {
+	content, err := ioutil.ReadFile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(content, &schema); err != nil {
+		t.Fatalf("Invalid schema : %v", err)
+	}
+
+	var properties, fields []string
+	for name := range schema.Properties {
+		properties = append(properties, name)
+	}
+	configurationType := reflect.TypeOf(pb.PluginConfiguration{})
+	for i := 0; i < configurationType.NumField(); i++ {
+		tag := configurationType.Field(i).Tag.Get("json")
+		if tag != "" && tag != "-" {
+			fields = append(fields, strings.Split(tag, ",")[0])
+		}
+	}
+	sort.Strings(properties)
+	sort.Strings(fields)
+	if !reflect.DeepEqual(properties, fields) {
+		t.Errorf("The schema properties %v do not match the fields %v", properties, fields)
+	}
+}
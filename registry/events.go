@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+/*
+ * Number of events kept for a subscriber that does not consume them
+ * fast enough. The next events are dropped.
+ */
+const eventBufferSize = 64
+
+/*
+ * Dispatches the events of the plugins to the subscribers.
+ */
+type EventBus struct {
+	mutex       sync.Mutex
+	subscribers map[chan *pb.PluginEvent]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan *pb.PluginEvent]struct{})}
+}
+
+/*
+ * Returns a channel receiving the events published from now on, and a
+ * function to call to unsubscribe. The channel is closed on unsubscription.
+ */
+func (bus *EventBus) Subscribe() (<-chan *pb.PluginEvent, func()) {
+	events := make(chan *pb.PluginEvent, eventBufferSize)
+
+	bus.mutex.Lock()
+	bus.subscribers[events] = struct{}{}
+	bus.mutex.Unlock()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			bus.mutex.Lock()
+			delete(bus.subscribers, events)
+			bus.mutex.Unlock()
+			close(events)
+		})
+	}
+}
+
+/*
+ * Sends the event to every subscriber without blocking.
+ */
+func (bus *EventBus) Publish(event *pb.PluginEvent) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	for events := range bus.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.Printf("Event dropped for a slow subscriber : %v", event)
+		}
+	}
+}
+
+func newPluginEvent(eventType pb.PluginEventType, name string) *pb.PluginEvent {
+	return &pb.PluginEvent{
+		Type:      eventType,
+		Name:      name,
+		Timestamp: time.Now().Unix(),
+	}
+}
@@ -0,0 +1,54 @@
+package registry_test
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+)
+
+/*
+ * Tests that the installation and the uninstallation of a plugin
+ * are published on the registry's bus.
+ */
+func TestRegistryEvents(t *testing.T) {
+	testedRegistry := registry.NewInMemoryRegistry(newFakeStorageClient())
+	events, unsubscribe := testedRegistry.Events().Subscribe()
+	defer unsubscribe()
+
+	install(t, testedRegistry, "agilestack-todo")
+	if _, err := testedRegistry.UninstallPlugin(context.Background(), pb.Plugin{Name: "agilestack-todo"}); err != nil {
+		t.Fatalf("Error while uninstalling the plugin : %v", err)
+	}
+
+	for _, expected := range []pb.PluginEventType{pb.PluginEventType_INSTALLED, pb.PluginEventType_UNINSTALLED} {
+		event := <-events
+		if event.Type != expected || event.Name != "agilestack-todo" || event.Timestamp == 0 {
+			t.Errorf("Invalid event : %v, expected %v", event, expected)
+		}
+	}
+}
+
+/*
+ * Tests that a subscriber that does not consume its events does not
+ * block the publication, and that the channel is closed on unsubscription.
+ */
+func TestSlowSubscriber(t *testing.T) {
+	bus := registry.NewEventBus()
+	events, unsubscribe := bus.Subscribe()
+
+	for i := 0; i < 100; i++ {
+		bus.Publish(&pb.PluginEvent{Type: pb.PluginEventType_CREATED, Name: "agilestack-todo"})
+	}
+	unsubscribe()
+	unsubscribe()
+
+	count := 0
+	for range events {
+		count++
+	}
+	if count == 0 || count >= 100 {
+		t.Errorf("The buffered events should be received and the next ones dropped : %d", count)
+	}
+}
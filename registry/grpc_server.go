@@ -0,0 +1,247 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+ * Creates the plugins, as the NATS subscriber does on the
+ * "core.plugin.create" topic.
+ */
+type PluginCreator interface {
+	CreatePlugin(ctx context.Context, request *pb.NewPluginRequest) *pb.NewPluginResponse
+}
+
+/*
+ * gRPC service exposing the operations available on the NATS topics.
+ */
+type GrpcServer struct {
+	registry Registry
+	creator  PluginCreator
+	events   *EventBus
+
+	/*
+	 * Records the administration calls when the audit log is enabled,
+	 * nil otherwise.
+	 */
+	auditor *Auditor
+
+	/*
+	 * Closed to end the event streams in progress.
+	 */
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewGrpcServer(registry Registry, creator PluginCreator, events *EventBus) *GrpcServer {
+	return &GrpcServer{
+		registry: registry,
+		creator:  creator,
+		events:   events,
+		closed:   make(chan struct{}),
+	}
+}
+
+/*
+ * Records the installations, uninstallations and creations in the audit
+ * log, as the NATS subscriber does for the requests on the topics.
+ */
+func (server *GrpcServer) SetAuditor(auditor *Auditor) {
+	server.auditor = auditor
+}
+
+/*
+ * Registers the service on the given gRPC server.
+ */
+func (server *GrpcServer) Register(grpcServer *grpc.Server) {
+	pb.RegisterRegistryServer(grpcServer, server)
+}
+
+/*
+ * Ends the event streams in progress, so that the gRPC server can be
+ * gracefully stopped.
+ */
+func (server *GrpcServer) Close() {
+	server.closeOnce.Do(func() {
+		close(server.closed)
+	})
+}
+
+func (server *GrpcServer) ListAvailablePlugins(ctx context.Context, _ *pb.Empty) (*pb.Plugins, error) {
+	plugins, err := server.registry.ListAvailablePlugins(ctx)
+	return plugins, grpcError(err)
+}
+
+func (server *GrpcServer) ListInstalledPlugins(ctx context.Context, _ *pb.Empty) (*pb.Plugins, error) {
+	plugins, err := server.registry.ListInstalledPlugins(ctx)
+	return plugins, grpcError(err)
+}
+
+/*
+ * Installs a plugin. A request without plugin, or without the plugin's
+ * name, fails with the "InvalidArgument" code.
+ */
+func (server *GrpcServer) InstallPlugin(ctx context.Context, request *pb.InstallPluginRequest) (*pb.NetResponse, error) {
+	call := server.auditor.audit(ctx, pb.InstallPluginTopic, request)
+	response, err := server.registry.InstallPlugin(ctx, *request)
+	call.end(response, err)
+	return response, grpcError(err)
+}
+
+func (server *GrpcServer) UninstallPlugin(ctx context.Context, plugin *pb.Plugin) (*pb.NetResponse, error) {
+	call := server.auditor.audit(ctx, pb.UninstallPluginTopic, plugin)
+	response, err := server.registry.UninstallPlugin(ctx, *plugin)
+	call.end(response, err)
+	return response, grpcError(err)
+}
+
+/*
+ * Creates a plugin. The build output is streamed on the NATS build topic.
+ *
+ * An invalid request fails with the "InvalidArgument" code. The other
+ * failures, such as a failed build, are reported in the response.
+ */
+func (server *GrpcServer) CreatePlugin(ctx context.Context, request *pb.NewPluginRequest) (*pb.NewPluginResponse, error) {
+	call := server.auditor.audit(ctx, pb.CreatePlugin, request)
+	response := server.creator.CreatePlugin(ctx, request)
+	call.end(response, nil)
+	if len(response.ValidationErrors) > 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", response.Error)
+	}
+	return response, nil
+}
+
+/*
+ * Streams the events of the plugins until the client cancels the call or
+ * the server is closed.
+ */
+func (server *GrpcServer) WatchEvents(_ *pb.Empty, stream pb.Registry_WatchEventsServer) error {
+	events, unsubscribe := server.events.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case event := <-events:
+			if err := stream.Send(event); err != nil {
+				log.Printf("Error while sending the event %v : %v", event, err)
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		case <-server.closed:
+			return nil
+		}
+	}
+}
+
+/*
+ * Topics whose roles are required by the gRPC methods. The methods that
+ * are not listed require the "admin" role.
+ */
+var grpcMethodTopics = map[string]string{
+	"/proto.Registry/ListAvailablePlugins": pb.ListAvailablePluginsTopic,
+	"/proto.Registry/ListInstalledPlugins": pb.ListInstalledPluginsTopic,
+	"/proto.Registry/InstallPlugin":        pb.InstallPluginTopic,
+	"/proto.Registry/UninstallPlugin":      pb.UninstallPluginTopic,
+	"/proto.Registry/CreatePlugin":         pb.CreatePlugin,
+	"/proto.Registry/WatchEvents":          pb.PluginEventsTopic,
+}
+
+/*
+ * Returns the options of a gRPC server checking the token and the role
+ * of each call, as the NATS subscriber does for the requests on the
+ * topics. The token is given in the "authorization" metadata, as
+ * "Bearer <token>".
+ *
+ * The calls without a valid token fail with the "Unauthenticated" code,
+ * the ones not allowed by the requester's role with the
+ * "PermissionDenied" code.
+ */
+func GrpcAuthOptions(options AuthOptions) []grpc.ServerOption {
+	verifier := NewTokenVerifier(options)
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := authorizeGrpcCall(ctx, verifier, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, request)
+		}),
+		grpc.StreamInterceptor(func(service interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := authorizeGrpcCall(stream.Context(), verifier, info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(service, &authenticatedStream{ServerStream: stream, ctx: ctx})
+		}),
+	}
+}
+
+/*
+ * Checks the token and the role of a call of the given method.
+ *
+ * Returns the context of the call holding the requester.
+ */
+func authorizeGrpcCall(ctx context.Context, verifier *TokenVerifier, method string) (context.Context, error) {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md["authorization"]) > 0 {
+		authorization = md["authorization"][0]
+	}
+
+	requester, err := verifier.VerifyBearer(authorization)
+	if err != nil {
+		log.Printf("Call of %s rejected : %v", method, err)
+		return nil, grpc.Errorf(codes.Unauthenticated, "%s", err.Error())
+	}
+	topic, ok := grpcMethodTopics[method]
+	if !ok {
+		topic = method
+	}
+	if authErr := Authorize(requester, topic); authErr != nil {
+		log.Printf("Call of %s rejected : %s", method, authErr.Message)
+		return nil, grpc.Errorf(codes.PermissionDenied, "%s", authErr.Message)
+	}
+	return withRequester(ctx, requester), nil
+}
+
+/*
+ * Stream whose context holds the requester of the call.
+ */
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *authenticatedStream) Context() context.Context {
+	return stream.ctx
+}
+
+/*
+ * Converts an error of the registry to a gRPC error with a status code.
+ */
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(ValidationErrors); ok {
+		return grpc.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	switch err {
+	case ErrPluginBusy:
+		return grpc.Errorf(codes.Aborted, "%s", err.Error())
+	case ErrNotLeader:
+		return grpc.Errorf(codes.Unavailable, "%s", err.Error())
+	case context.Canceled:
+		return grpc.Errorf(codes.Canceled, "%s", err.Error())
+	case context.DeadlineExceeded:
+		return grpc.Errorf(codes.DeadlineExceeded, "%s", err.Error())
+	}
+	return grpc.Errorf(codes.Internal, "%s", err.Error())
+}
@@ -0,0 +1,215 @@
+package registry_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+ * Plugin creator replying with the given response.
+ */
+type fakeCreator struct {
+	response *pb.NewPluginResponse
+}
+
+func (creator fakeCreator) CreatePlugin(ctx context.Context, request *pb.NewPluginRequest) *pb.NewPluginResponse {
+	return creator.response
+}
+
+func TestGrpcInstallPlugin(t *testing.T) {
+	client := newFakeStorageClient()
+	testedRegistry := registry.NewInMemoryRegistry(client)
+	server := registry.NewGrpcServer(testedRegistry, fakeCreator{}, testedRegistry.Events())
+
+	response, err := server.InstallPlugin(context.Background(), &pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: "agilestack-todo"}})
+	if err != nil || response.Response != pb.Responses_ACK {
+		t.Fatalf("Invalid installation : %v, %v", response, err)
+	}
+	plugins, err := server.ListInstalledPlugins(context.Background(), &pb.Empty{})
+	if err != nil || len(plugins.Plugins) != 1 {
+		t.Errorf("Invalid installed plugins : %v, %v", plugins, err)
+	}
+}
+
+/*
+ * Tests that an operation rejected by the registry because the plugin
+ * is busy fails with the "Aborted" code.
+ */
+func TestGrpcErrorCodes(t *testing.T) {
+	client := newFakeStorageClient()
+	client.blocker = make(chan struct{})
+	testedRegistry := registry.NewInMemoryRegistryWithPolicy(client, registry.RejectConcurrentOperations)
+	server := registry.NewGrpcServer(testedRegistry, fakeCreator{}, testedRegistry.Events())
+
+	request := &pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: "agilestack-todo"}}
+	go server.InstallPlugin(context.Background(), request)
+	waitForOperation(t, client, "agilestack-todo")
+
+	_, err := server.InstallPlugin(context.Background(), request)
+	close(client.blocker)
+	if grpc.Code(err) != codes.Aborted {
+		t.Errorf("A concurrent operation should be aborted : %v", err)
+	}
+}
+
+func TestGrpcInvalidCreation(t *testing.T) {
+	creator := fakeCreator{response: &pb.NewPluginResponse{
+		Error:            "name: Invalid plugin name",
+		ValidationErrors: []*pb.ValidationError{{Field: "name", Message: "Invalid plugin name"}},
+	}}
+	server := registry.NewGrpcServer(registry.NewInMemoryRegistry(newFakeStorageClient()), creator, registry.NewEventBus())
+
+	if _, err := server.CreatePlugin(context.Background(), &pb.NewPluginRequest{Name: "Todo"}); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("An invalid request should have the InvalidArgument code : %v", err)
+	}
+}
+
+/*
+ * Tests that the requests without plugin, or without the plugin's name,
+ * fail with the "InvalidArgument" code instead of crashing the server.
+ */
+func TestGrpcInvalidInstallation(t *testing.T) {
+	client := newFakeStorageClient()
+	testedRegistry := registry.NewInMemoryRegistry(client)
+	server := registry.NewGrpcServer(testedRegistry, fakeCreator{}, testedRegistry.Events())
+
+	for _, request := range []*pb.InstallPluginRequest{{}, {Plugin: &pb.Plugin{}}} {
+		if _, err := server.InstallPlugin(context.Background(), request); grpc.Code(err) != codes.InvalidArgument {
+			t.Errorf("The installation of %v should have the InvalidArgument code : %v", request, err)
+		}
+	}
+	if _, err := server.UninstallPlugin(context.Background(), &pb.Plugin{}); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("The uninstallation without name should have the InvalidArgument code : %v", err)
+	}
+	if client.installations != 0 {
+		t.Errorf("No plugin should be installed : %d", client.installations)
+	}
+}
+
+/*
+ * Tests that the event streams end when the server is closed.
+ */
+func TestGrpcWatchEventsEndsOnClose(t *testing.T) {
+	server := registry.NewGrpcServer(registry.NewInMemoryRegistry(newFakeStorageClient()), fakeCreator{}, registry.NewEventBus())
+	stream := &fakeEventStream{ctx: context.Background()}
+
+	ended := make(chan error)
+	go func() {
+		ended <- server.WatchEvents(&pb.Empty{}, stream)
+	}()
+	server.Close()
+	if err := <-ended; err != nil {
+		t.Errorf("The stream should end without error : %v", err)
+	}
+}
+
+/*
+ * Tests that the calls are rejected without a token, or when the
+ * requester's role does not allow them.
+ */
+func TestGrpcAuthentication(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer(registry.GrpcAuthOptions(registry.AuthOptions{HMACSecret: testSecret})...)
+	registry.NewGrpcServer(registry.NewInMemoryRegistry(newFakeStorageClient()), fakeCreator{}, registry.NewEventBus()).Register(grpcServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	connection, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	client := pb.NewRegistryClient(connection)
+
+	if _, err := client.ListInstalledPlugins(context.Background(), &pb.Empty{}); grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("A call without token should be rejected : %v", err)
+	}
+
+	token := newToken(t, jwt.SigningMethodHS256, testSecret, "alice", "viewer")
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	if _, err := client.ListInstalledPlugins(ctx, &pb.Empty{}); err != nil {
+		t.Errorf("A viewer should list the plugins : %v", err)
+	}
+	request := &pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: "agilestack-todo"}}
+	if _, err := client.InstallPlugin(ctx, request); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("A viewer should not install a plugin : %v", err)
+	}
+	stream, err := client.WatchEvents(context.Background(), &pb.Empty{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("A stream without token should be rejected : %v", err)
+	}
+}
+
+/*
+ * Tests that the calls are recorded in the audit log with their requester.
+ */
+func TestGrpcAudit(t *testing.T) {
+	auditLog, _, remove := openTestAuditLog(t, registry.DefaultAuditOptions())
+	defer remove()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newFakeStorageClient()
+	grpcServer := grpc.NewServer(registry.GrpcAuthOptions(registry.AuthOptions{HMACSecret: testSecret})...)
+	server := registry.NewGrpcServer(registry.NewInMemoryRegistry(client), fakeCreator{}, registry.NewEventBus())
+	server.SetAuditor(registry.NewAuditor(auditLog, client))
+	server.Register(grpcServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	connection, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	token := newToken(t, jwt.SigningMethodHS256, testSecret, "alice", "admin")
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	request := &pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: "agilestack-todo"}}
+	if _, err := pb.NewRegistryClient(connection).InstallPlugin(ctx, request); err != nil {
+		t.Fatalf("Invalid installation : %v", err)
+	}
+
+	entries, err := auditLog.Query(&pb.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Invalid entries : %v", entries)
+	}
+	entry := entries[0]
+	if entry.Action != "install" || entry.Plugin != "agilestack-todo" || entry.Actor != "alice" || entry.Role != "admin" || !entry.Status {
+		t.Errorf("Invalid entry : %v", entry)
+	}
+}
+
+type fakeEventStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events []*pb.PluginEvent
+}
+
+func (stream *fakeEventStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *fakeEventStream) Send(event *pb.PluginEvent) error {
+	stream.events = append(stream.events, event)
+	return nil
+}
@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+ * HTTP handler exposing the health of core :
+ * - "/health" answers as long as the process is alive.
+ * - "/ready" answers with the status 200 when core is able to handle
+ *   requests, with the status 503 otherwise.
+ * - "/metrics" exposes the Prometheus metrics.
+ */
+type HealthHandler struct {
+	mux *http.ServeMux
+
+	mutex          sync.RWMutex
+	readinessCheck func() bool
+	metrics        http.Handler
+}
+
+func NewHealthHandler() *HealthHandler {
+	handler := &HealthHandler{mux: http.NewServeMux(), metrics: promhttp.Handler()}
+	handler.mux.HandleFunc("/health", handler.serveHealth)
+	handler.mux.HandleFunc("/ready", handler.serveReadiness)
+	handler.mux.HandleFunc("/metrics", handler.serveMetrics)
+	return handler
+}
+
+/*
+ * Sets the function telling whether or not core is ready.
+ *
+ * As long as no function is set, core is considered as not ready.
+ */
+func (handler *HealthHandler) SetReadinessCheck(readinessCheck func() bool) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.readinessCheck = readinessCheck
+}
+
+/*
+ * Sets the handler of the metrics, such as the one returned by
+ * NewMetricsHandler.
+ *
+ * As long as no handler is set, only the metrics of the process and the
+ * ones of core that do not need the storage of the plugins are exposed.
+ */
+func (handler *HealthHandler) SetMetricsHandler(metrics http.Handler) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.metrics = metrics
+}
+
+func (handler *HealthHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	handler.mux.ServeHTTP(writer, request)
+}
+
+func (handler *HealthHandler) serveHealth(writer http.ResponseWriter, request *http.Request) {
+	writer.Write([]byte("OK\n"))
+}
+
+func (handler *HealthHandler) serveReadiness(writer http.ResponseWriter, request *http.Request) {
+	handler.mutex.RLock()
+	readinessCheck := handler.readinessCheck
+	handler.mutex.RUnlock()
+
+	if readinessCheck == nil || !readinessCheck() {
+		http.Error(writer, "NOT READY", http.StatusServiceUnavailable)
+		return
+	}
+	writer.Write([]byte("READY\n"))
+}
+
+func (handler *HealthHandler) serveMetrics(writer http.ResponseWriter, request *http.Request) {
+	handler.mutex.RLock()
+	metrics := handler.metrics
+	handler.mutex.RUnlock()
+
+	metrics.ServeHTTP(writer, request)
+}
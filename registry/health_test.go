@@ -0,0 +1,38 @@
+package registry_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eogile/agilestack-core/registry"
+)
+
+func TestHealth(t *testing.T) {
+	handler := registry.NewHealthHandler()
+	assertStatus(t, handler, "/health", http.StatusOK)
+}
+
+func TestReadiness(t *testing.T) {
+	handler := registry.NewHealthHandler()
+
+	/*
+	 * Not ready as long as no check is set.
+	 */
+	assertStatus(t, handler, "/ready", http.StatusServiceUnavailable)
+
+	ready := false
+	handler.SetReadinessCheck(func() bool { return ready })
+	assertStatus(t, handler, "/ready", http.StatusServiceUnavailable)
+
+	ready = true
+	assertStatus(t, handler, "/ready", http.StatusOK)
+}
+
+func assertStatus(t *testing.T, handler http.Handler, path string, expectedStatus int) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	if recorder.Code != expectedStatus {
+		t.Errorf("Invalid status for %s : %d", path, recorder.Code)
+	}
+}
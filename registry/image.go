@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"errors"
+	"regexp"
+	"time"
+)
+
+const (
+	/*
+	 * Tag of the plugins' images when the request gives none.
+	 */
+	DEFAULT_IMAGE_TAG = "latest"
+
+	/*
+	 * Annotations of the OCI image specification applied to every
+	 * plugin image.
+	 */
+	OCI_TITLE_LABEL    = "org.opencontainers.image.title"
+	OCI_VERSION_LABEL  = "org.opencontainers.image.version"
+	OCI_SOURCE_LABEL   = "org.opencontainers.image.source"
+	OCI_REVISION_LABEL = "org.opencontainers.image.revision"
+	OCI_CREATED_LABEL  = "org.opencontainers.image.created"
+)
+
+var (
+	imageTagRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	buildArgRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+/*
+ * Checks that the given string is a valid Docker image tag.
+ */
+func ValidateImageTag(tag string) error {
+	if !imageTagRegexp.MatchString(tag) {
+		return errors.New("Invalid image tag : " + tag)
+	}
+	return nil
+}
+
+/*
+ * Checks that the given string can be used as the name of a build argument.
+ */
+func ValidateBuildArgName(name string) error {
+	if !buildArgRegexp.MatchString(name) {
+		return errors.New("Invalid build argument name : " + name)
+	}
+	return nil
+}
+
+/*
+ * Information describing how a plugin image was built.
+ */
+type ImageOrigin struct {
+	Name    string
+	Tag     string
+	Source  string
+	Created time.Time
+
+	/*
+	 * Commit from which the image was built, when the sources are
+	 * a git working copy.
+	 */
+	Revision string
+}
+
+/*
+ * Returns the OCI labels describing the image. The labels whose value
+ * is unknown are omitted.
+ */
+func (origin ImageOrigin) Labels() map[string]string {
+	labels := map[string]string{
+		OCI_TITLE_LABEL:   origin.Name,
+		OCI_VERSION_LABEL: origin.Tag,
+		OCI_CREATED_LABEL: origin.Created.UTC().Format(time.RFC3339),
+	}
+	if origin.Source != "" {
+		labels[OCI_SOURCE_LABEL] = origin.Source
+	}
+	if origin.Revision != "" {
+		labels[OCI_REVISION_LABEL] = origin.Revision
+	}
+	return labels
+}
@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+/*
+ * Registry where the plugin images are pushed.
+ */
+type RegistryOptions struct {
+	/*
+	 * Address of the registry, such as "registry.eogile.com:5000".
+	 * When empty, the images cannot be pushed.
+	 */
+	Address string
+
+	Username string
+	Password string
+	Email    string
+}
+
+func (options RegistryOptions) Enabled() bool {
+	return options.Address != ""
+}
+
+/*
+ * Returns the name of the repository of the given image in the registry.
+ */
+func (options RegistryOptions) Repository(imageName string) string {
+	return strings.TrimSuffix(options.Address, "/") + "/" + imageName
+}
+
+/*
+ * Message of the JSON stream written by the Docker daemon while
+ * pushing an image.
+ */
+type pushMessage struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux struct {
+		Tag    string `json:"Tag"`
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+/*
+ * Writer decoding the JSON stream of a push.
+ *
+ * The status changes of the layers are written to the output, one per
+ * line. The progress bars are skipped. The digest of the pushed image
+ * and the error reported by the daemon, if any, are kept.
+ */
+type PushProgressWriter struct {
+	output io.Writer
+
+	mutex   sync.Mutex
+	pending bytes.Buffer
+	digest  string
+	err     error
+}
+
+func NewPushProgressWriter(output io.Writer) *PushProgressWriter {
+	return &PushProgressWriter{output: output}
+}
+
+func (writer *PushProgressWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	writer.pending.Write(data)
+	for {
+		index := bytes.IndexByte(writer.pending.Bytes(), '\n')
+		if index < 0 {
+			break
+		}
+		writer.decode(writer.pending.Next(index + 1))
+	}
+	return len(data), nil
+}
+
+/*
+ * Decodes the last message if it does not end with a line break.
+ */
+func (writer *PushProgressWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.pending.Len() > 0 {
+		writer.decode(writer.pending.Bytes())
+		writer.pending.Reset()
+	}
+	return nil
+}
+
+func (writer *PushProgressWriter) decode(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var message pushMessage
+	if err := json.Unmarshal(line, &message); err != nil {
+		writer.writeLine(string(line))
+		return
+	}
+
+	switch {
+	case message.Error != "":
+		writer.err = errors.New(message.Error)
+		writer.writeLine("Error: " + message.Error)
+	case message.ErrorDetail.Message != "":
+		writer.err = errors.New(message.ErrorDetail.Message)
+		writer.writeLine("Error: " + message.ErrorDetail.Message)
+	case message.Aux.Digest != "":
+		writer.digest = message.Aux.Digest
+		writer.writeLine(message.Aux.Tag + ": digest: " + message.Aux.Digest)
+	case message.Progress != "":
+		return
+	case message.ID != "":
+		writer.writeLine(message.ID + ": " + message.Status)
+	case message.Status != "":
+		writer.writeLine(message.Status)
+	}
+}
+
+func (writer *PushProgressWriter) writeLine(line string) {
+	if writer.output != nil {
+		io.WriteString(writer.output, line+"\n")
+	}
+}
+
+/*
+ * Returns the digest of the pushed image, or an empty string if the
+ * daemon did not report it.
+ */
+func (writer *PushProgressWriter) Digest() string {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.digest
+}
+
+/*
+ * Returns the error reported by the daemon, if any.
+ */
+func (writer *PushProgressWriter) Err() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.err
+}
@@ -0,0 +1,70 @@
+package registry_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eogile/agilestack-core/registry"
+)
+
+func TestRegistryRepository(t *testing.T) {
+	options := registry.RegistryOptions{Address: "registry.eogile.com:5000/"}
+	if !options.Enabled() {
+		t.Error("The registry should be enabled")
+	}
+	if repository := options.Repository("agilestack-todo"); repository != "registry.eogile.com:5000/agilestack-todo" {
+		t.Errorf("Invalid repository : %s", repository)
+	}
+	if (registry.RegistryOptions{}).Enabled() {
+		t.Error("The registry should be disabled")
+	}
+}
+
+/*
+ * Tests that the status changes and the digest are extracted from the
+ * daemon's stream, whatever the way it is written.
+ */
+func TestPushProgressWriter(t *testing.T) {
+	var output bytes.Buffer
+	writer := registry.NewPushProgressWriter(&output)
+
+	stream := `{"status":"The push refers to a repository [registry.eogile.com/agilestack-todo]"}` + "\r\n" +
+		`{"status":"Preparing","progressDetail":{},"id":"5f70bf18a086"}` + "\r\n" +
+		`{"status":"Pushing","progressDetail":{"current":512,"total":1024},"progress":"[==>   ] 512B/1kB","id":"5f70bf18a086"}` + "\r\n" +
+		`{"status":"Pushed","progressDetail":{},"id":"5f70bf18a086"}` + "\r\n" +
+		`{"status":"1.0: digest: sha256:0123 size: 528"}` + "\r\n" +
+		`{"progressDetail":{},"aux":{"Tag":"1.0","Digest":"sha256:0123","Size":528}}`
+	writer.Write([]byte(stream[:70]))
+	writer.Write([]byte(stream[70:]))
+	writer.Close()
+
+	expected := "The push refers to a repository [registry.eogile.com/agilestack-todo]\n" +
+		"5f70bf18a086: Preparing\n" +
+		"5f70bf18a086: Pushed\n" +
+		"1.0: digest: sha256:0123 size: 528\n" +
+		"1.0: digest: sha256:0123\n"
+	if output.String() != expected {
+		t.Errorf("Invalid output : %q", output.String())
+	}
+	if writer.Digest() != "sha256:0123" {
+		t.Errorf("Invalid digest : %s", writer.Digest())
+	}
+	if writer.Err() != nil {
+		t.Errorf("No error expected : %v", writer.Err())
+	}
+}
+
+func TestPushProgressWriterError(t *testing.T) {
+	var output bytes.Buffer
+	writer := registry.NewPushProgressWriter(&output)
+
+	writer.Write([]byte(`{"errorDetail":{"message":"unauthorized: authentication required"},"error":"unauthorized: authentication required"}` + "\r\n"))
+	writer.Close()
+
+	if writer.Err() == nil || writer.Err().Error() != "unauthorized: authentication required" {
+		t.Errorf("Invalid error : %v", writer.Err())
+	}
+	if output.String() != "Error: unauthorized: authentication required\n" {
+		t.Errorf("Invalid output : %q", output.String())
+	}
+}
@@ -0,0 +1,68 @@
+package registry_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eogile/agilestack-core/registry"
+)
+
+func TestValidateImageTag(t *testing.T) {
+	for _, tag := range []string{"latest", "1.2.3", "v1.0-rc.1", "feature_x", "_build"} {
+		if err := registry.ValidateImageTag(tag); err != nil {
+			t.Errorf("The tag %s should be valid : %v", tag, err)
+		}
+	}
+	for _, tag := range []string{"", ".hidden", "-rc", "a:b", "a/b", strings.Repeat("a", 129)} {
+		if err := registry.ValidateImageTag(tag); err == nil {
+			t.Errorf("The tag %q should be invalid", tag)
+		}
+	}
+}
+
+func TestValidateBuildArgName(t *testing.T) {
+	for _, name := range []string{"VERSION", "http_proxy", "_X1"} {
+		if err := registry.ValidateBuildArgName(name); err != nil {
+			t.Errorf("The name %s should be valid : %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "1X", "MY-ARG", "A B"} {
+		if err := registry.ValidateBuildArgName(name); err == nil {
+			t.Errorf("The name %q should be invalid", name)
+		}
+	}
+}
+
+func TestImageOriginLabels(t *testing.T) {
+	created := time.Date(2016, 5, 12, 10, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	origin := registry.ImageOrigin{
+		Name:     "todo",
+		Tag:      "1.0",
+		Source:   "https://github.com/eogile/todo.git",
+		Revision: "0123456789abcdef",
+		Created:  created,
+	}
+	expected := map[string]string{
+		registry.OCI_TITLE_LABEL:    "todo",
+		registry.OCI_VERSION_LABEL:  "1.0",
+		registry.OCI_SOURCE_LABEL:   "https://github.com/eogile/todo.git",
+		registry.OCI_REVISION_LABEL: "0123456789abcdef",
+		registry.OCI_CREATED_LABEL:  "2016-05-12T08:30:00Z",
+	}
+	if labels := origin.Labels(); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Invalid labels : %v", labels)
+	}
+
+	/*
+	 * Unknown source and revision.
+	 */
+	origin.Source = ""
+	origin.Revision = ""
+	delete(expected, registry.OCI_SOURCE_LABEL)
+	delete(expected, registry.OCI_REVISION_LABEL)
+	if labels := origin.Labels(); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Invalid labels : %v", labels)
+	}
+}
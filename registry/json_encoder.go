@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats"
+)
+
+/*
+ * Name of the NATS encoder of the protobuf messages in their JSON
+ * mapping, used on the "core.json.*" topics.
+ */
+const PROTO_JSON_ENCODER = "protojson"
+
+var errNotProtoMessage = errors.New("The JSON encoder only handles protobuf messages")
+
+func init() {
+	nats.RegisterEncoder(PROTO_JSON_ENCODER, &ProtoJSONEncoder{})
+}
+
+/*
+ * Encodes the protobuf messages with the JSON mapping of proto3 : the
+ * fields are named as in "registry.proto", the enums by their name and
+ * the 64-bit integers are strings.
+ *
+ * The fields having their default value are emitted, so that the
+ * clients do not need to know the defaults.
+ */
+type ProtoJSONEncoder struct{}
+
+var protoJSONMarshaler = jsonpb.Marshaler{EmitDefaults: true}
+
+func (encoder *ProtoJSONEncoder) Encode(subject string, v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, errNotProtoMessage
+	}
+
+	var buffer bytes.Buffer
+	if err := protoJSONMarshaler.Marshal(&buffer, message); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+/*
+ * Decodes a message. An empty payload is decoded as an empty message,
+ * so that the requests without parameters can be sent without body.
+ */
+func (encoder *ProtoJSONEncoder) Decode(subject string, data []byte, vPtr interface{}) error {
+	if _, ok := vPtr.(*interface{}); ok {
+		return nil
+	}
+	message, ok := vPtr.(proto.Message)
+	if !ok {
+		return errNotProtoMessage
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		message.Reset()
+		return nil
+	}
+	return jsonpb.Unmarshal(bytes.NewReader(data), message)
+}
@@ -0,0 +1,61 @@
+package registry_test
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+)
+
+func TestProtoJSONEncoder(t *testing.T) {
+	encoder := &registry.ProtoJSONEncoder{}
+
+	data, err := encoder.Encode(pb.ListInstalledPluginsTopic, &pb.Plugin{Name: "agilestack-todo", PluginStatus: pb.PluginStatus_OK})
+	if err != nil {
+		t.Fatalf("Error while encoding the plugin : %v", err)
+	}
+	if string(data) != `{"name":"agilestack-todo","pluginStatus":"OK"}` {
+		t.Errorf("Invalid JSON : %s", data)
+	}
+
+	/*
+	 * The default values are emitted.
+	 */
+	data, _ = encoder.Encode(pb.InstallPluginTopic, &pb.NetResponse{})
+	if string(data) != `{"response":"ACK","details":""}` {
+		t.Errorf("Invalid JSON : %s", data)
+	}
+
+	event := &pb.PluginEvent{}
+	err = encoder.Decode(pb.PluginEventsTopic, []byte(`{"type":"UNINSTALLED","name":"agilestack-todo","timestamp":"1500000000"}`), event)
+	if err != nil {
+		t.Fatalf("Error while decoding the event : %v", err)
+	}
+	expected := &pb.PluginEvent{Type: pb.PluginEventType_UNINSTALLED, Name: "agilestack-todo", Timestamp: 1500000000}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Invalid event : %v", event)
+	}
+}
+
+/*
+ * Tests that the requests without parameters can be sent without body,
+ * and that the invalid payloads are rejected.
+ */
+func TestProtoJSONEncoderInvalidPayloads(t *testing.T) {
+	encoder := &registry.ProtoJSONEncoder{}
+
+	request := &pb.NameRequest{Name: "agilestack-todo"}
+	if err := encoder.Decode(pb.GetBuildLogTopic, nil, request); err != nil || request.Name != "" {
+		t.Errorf("An empty payload should be decoded as an empty message : %v, %v", request, err)
+	}
+	if err := encoder.Decode(pb.GetBuildLogTopic, []byte(`{"name":`), request); err == nil {
+		t.Error("A truncated payload should be rejected")
+	}
+	if err := encoder.Decode(pb.GetBuildLogTopic, []byte(`{"name":"todo"}`), &struct{ Name string }{}); err == nil {
+		t.Error("Only the protobuf messages should be decoded")
+	}
+	if _, err := encoder.Encode(pb.GetBuildLogTopic, "todo"); err == nil {
+		t.Error("Only the protobuf messages should be encoded")
+	}
+}
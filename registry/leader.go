@@ -0,0 +1,204 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/nats-io/nats"
+)
+
+/*
+ * Tells whether or not the current core instance is the one running
+ * the tasks that must not be run concurrently by several instances
+ * (reconciliation, shutdown hooks...).
+ */
+type LeaderElector interface {
+	IsLeader() bool
+
+	/*
+	 * Tells whether or not another instance answers the requests meant
+	 * for the leader, so that the current instance can ignore them.
+	 */
+	LeaderElsewhere() bool
+}
+
+/*
+ * Error returned when a plugin is installed, uninstalled or deleted, or
+ * when the garbage is collected, on a core instance which is not the
+ * leader.
+ *
+ * The operations on a plugin are serialized by locks held in the memory of
+ * the instance, so only the leader changes the plugins : when the leader
+ * election is enabled, every instance receives the requests of the
+ * changing topics and the leader handles them (see "leaderTopics"). While
+ * no leader is elected, the requests are answered with this error. The
+ * gRPC and REST calls must be sent to the leader.
+ */
+var ErrNotLeader = errors.New("This core instance is not the leader : the plugins are changed by the leader only")
+
+/*
+ * Elector used when a single core instance is running : the instance
+ * is always the leader.
+ */
+type singleInstanceElector struct{}
+
+func (singleInstanceElector) IsLeader() bool {
+	return true
+}
+
+func (singleInstanceElector) LeaderElsewhere() bool {
+	return false
+}
+
+/*
+ * Leader election based on heartbeats exchanged over NATS.
+ *
+ * Every instance periodically publishes its identifier. The leader is the
+ * instance with the lowest identifier among the ones heard recently.
+ * Since the identifiers start with the instance's start time, the oldest
+ * instance is the leader, so that starting a new replica does not change
+ * the leader.
+ *
+ * An instance does not consider itself as the leader until it has listened
+ * to the heartbeats long enough to know the other instances.
+ */
+type NatsLeaderElector struct {
+	connection *nats.Conn
+	id         string
+	interval   time.Duration
+
+	started      time.Time
+	subscription *nats.Subscription
+	stop         chan struct{}
+	stopOnce     sync.Once
+
+	mutex sync.Mutex
+	peers map[string]time.Time
+}
+
+/*
+ * Number of heartbeat intervals after which a silent instance is
+ * considered as gone.
+ */
+const heartbeatsBeforeExpiry = 3
+
+/*
+ * Number of heartbeat intervals after which a silent instance is no
+ * longer expected to answer the requests meant for the leader.
+ */
+const heartbeatsBeforeSuspicion = 2
+
+func NewNatsLeaderElector(connection *nats.Conn, id string, interval time.Duration) *NatsLeaderElector {
+	return &NatsLeaderElector{
+		connection: connection,
+		id:         id,
+		interval:   interval,
+		stop:       make(chan struct{}),
+		peers:      make(map[string]time.Time),
+	}
+}
+
+/*
+ * Computes an identifier for the current instance.
+ */
+func NewInstanceID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%020d-%s-%d", time.Now().UnixNano(), hostname, os.Getpid())
+}
+
+/*
+ * Starts listening to and publishing the heartbeats.
+ */
+func (elector *NatsLeaderElector) Start() error {
+	subscription, err := elector.connection.Subscribe(pb.LeaderHeartbeatTopic, func(m *nats.Msg) {
+		elector.mutex.Lock()
+		defer elector.mutex.Unlock()
+		elector.peers[string(m.Data)] = time.Now()
+	})
+	if err != nil {
+		return err
+	}
+	elector.subscription = subscription
+	elector.started = time.Now()
+
+	go func() {
+		ticker := time.NewTicker(elector.interval)
+		defer ticker.Stop()
+
+		elector.heartbeat()
+		for {
+			select {
+			case <-elector.stop:
+				return
+			case <-ticker.C:
+				elector.heartbeat()
+			}
+		}
+	}()
+	return nil
+}
+
+/*
+ * Stops taking part in the election. Can be called more than once.
+ */
+func (elector *NatsLeaderElector) Stop() {
+	elector.stopOnce.Do(func() {
+		close(elector.stop)
+		if elector.subscription != nil {
+			elector.subscription.Unsubscribe()
+		}
+	})
+}
+
+func (elector *NatsLeaderElector) heartbeat() {
+	if err := elector.connection.Publish(pb.LeaderHeartbeatTopic, []byte(elector.id)); err != nil {
+		log.Printf("Error while publishing the leader heartbeat : %v", err)
+	}
+}
+
+func (elector *NatsLeaderElector) IsLeader() bool {
+	expiry := time.Duration(heartbeatsBeforeExpiry) * elector.interval
+	if elector.started.IsZero() || time.Since(elector.started) < expiry {
+		return false
+	}
+
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+
+	for id, lastSeen := range elector.peers {
+		if time.Since(lastSeen) > expiry {
+			delete(elector.peers, id)
+			continue
+		}
+		if id < elector.id {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * Another instance answers the requests meant for the leader if an
+ * instance with a lower identifier was heard recently : either it is the
+ * leader, or it answers that no leader is elected yet.
+ *
+ * Therefore, while no leader is elected, the instance with the lowest
+ * identifier answers the requests.
+ */
+func (elector *NatsLeaderElector) LeaderElsewhere() bool {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+
+	suspicion := time.Duration(heartbeatsBeforeSuspicion) * elector.interval
+	for id, lastSeen := range elector.peers {
+		if id < elector.id && time.Since(lastSeen) <= suspicion {
+			return true
+		}
+	}
+	return false
+}
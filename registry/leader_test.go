@@ -0,0 +1,65 @@
+package registry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eogile/agilestack-core/registry"
+)
+
+const heartbeatInterval = 50 * time.Millisecond
+
+/*
+ * Tests that a single leader is elected among several instances, and
+ * that another one is elected when the leader stops.
+ */
+func TestLeaderElection(t *testing.T) {
+	connection := registry.EstablishConnection(localhostNatsServerURL)
+	defer connection.Close()
+
+	oldest := registry.NewNatsLeaderElector(connection.Conn, "1-oldest", heartbeatInterval)
+	youngest := registry.NewNatsLeaderElector(connection.Conn, "2-youngest", heartbeatInterval)
+	for _, elector := range []*registry.NatsLeaderElector{oldest, youngest} {
+		if err := elector.Start(); err != nil {
+			t.Fatalf("Error while starting the election : %v", err)
+		}
+	}
+	defer youngest.Stop()
+
+	/*
+	 * No leader until the instances know each other.
+	 */
+	if oldest.IsLeader() || youngest.IsLeader() {
+		t.Error("No instance should be the leader right after starting")
+	}
+
+	/*
+	 * Meanwhile, the oldest instance answers the requests meant for
+	 * the leader.
+	 */
+	time.Sleep(heartbeatInterval / 2)
+	if oldest.LeaderElsewhere() || !youngest.LeaderElsewhere() {
+		t.Error("Only the oldest instance should answer the requests before the election")
+	}
+
+	time.Sleep(4 * heartbeatInterval)
+	if !oldest.IsLeader() {
+		t.Error("The oldest instance should be the leader")
+	}
+	if youngest.IsLeader() || !youngest.LeaderElsewhere() {
+		t.Error("The youngest instance should not be the leader")
+	}
+
+	/*
+	 * The leader leaves.
+	 */
+	oldest.Stop()
+	time.Sleep(5 * heartbeatInterval / 2)
+	if youngest.LeaderElsewhere() {
+		t.Error("The youngest instance should answer the requests once the leader is silent")
+	}
+	time.Sleep(2 * heartbeatInterval)
+	if !youngest.IsLeader() {
+		t.Error("The youngest instance should be the leader once the oldest stopped")
+	}
+}
@@ -1,16 +1,13 @@
 package registry_test
 
 import (
+	"context"
 	"log"
 	"os"
-	"regexp"
-	"runtime"
 	"testing"
 
 	"strings"
 
-	"time"
-
 	pb "github.com/eogile/agilestack-core/proto"
 	"github.com/eogile/agilestack-core/registry"
 	"github.com/eogile/agilestack-core/registry/storage"
@@ -26,6 +23,7 @@ var dockerClient *dockerclient.DockerClient
  *
  */
 var localhostNatsServerURL string
+var natsServer *registry.EmbeddedNatsServer
 
 /*
  * Configures the date and time format for logs messages.
@@ -52,17 +50,17 @@ func TestMain(m *testing.M) {
 	/*
 	 * Cleaning the docker environment before launching the tests
 	 */
-	uninstallAllPlugins(true)
-
-	/*
-	 * Starts the NATS server
-	 */
-	installNatsServer()
+	uninstallAllPlugins()
 
 	/*
-	 * Computes the URL of the NATS server
+	 * Starts the NATS server, in the process of the tests
 	 */
-	localhostNatsServerURL = natsServerURL()
+	var err error
+	natsServer, err = registry.StartEmbeddedNatsServer("127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Error while starting the NATS server : %v", err)
+	}
+	localhostNatsServerURL = natsServer.URL()
 
 	/*
 	 * Launching the tests
@@ -72,7 +70,9 @@ func TestMain(m *testing.M) {
 	/*
 	 * Removing the installed plugins
 	 */
-	uninstallAllPlugins(true)
+	uninstallAllPlugins()
+	dockerWrapper.Close()
+	natsServer.Shutdown()
 
 	os.Exit(exitCode)
 }
@@ -83,33 +83,33 @@ func TestMain(m *testing.M) {
  * - Initializes the map of registered plugins
  */
 func setUp() {
-	uninstallAllRunningPlugins(false)
+	uninstallAllRunningPlugins()
 }
 
 /*
  * Helper method to remove all running containers.
  */
-func uninstallAllRunningPlugins(includingNats bool) {
-	uninstallPlugins(includingNats, false)
+func uninstallAllRunningPlugins() {
+	uninstallPlugins(false)
 }
 
 /*
  * Helper method to remove all containers including stopped.
  */
-func uninstallAllPlugins(includingNats bool) {
-	uninstallPlugins(includingNats, true)
+func uninstallAllPlugins() {
+	uninstallPlugins(true)
 }
 
 /*
  * Helper method to remove containers.
  */
-func uninstallPlugins(includingNats bool, all bool) {
+func uninstallPlugins(all bool) {
 	listOps := docker.ListContainersOptions{All: all}
 	containers, _ := dockerClient.ListContainers(listOps)
 
 	for _, container := range containers {
 		pluginName := storage.GetPluginName(container.Image)
-		if (includingNats && "nats" == pluginName) || strings.HasPrefix(pluginName, "agilestack-") {
+		if strings.HasPrefix(pluginName, "agilestack-") {
 			log.Printf("Removing container %s", pluginName)
 			dockerClient.StopContainer(container.ID, 10)
 			removeOpts := docker.RemoveContainerOptions{ID: container.ID}
@@ -123,7 +123,7 @@ func uninstallPlugins(includingNats bool, all bool) {
  * in the list of available plugins equals the given boolean.
  */
 func assertThatPluginsIsAvailable(t *testing.T, pluginName string, expectedResult bool) {
-	plugins, err := testRegistry.ListAvailablePlugins()
+	plugins, err := testRegistry.ListAvailablePlugins(context.Background())
 	if err != nil {
 		t.Errorf("Error should not be nil : %v", err)
 		return
@@ -140,7 +140,7 @@ func assertThatPluginsIsAvailable(t *testing.T, pluginName string, expectedResul
  * in the list of installed plugins equals the given boolean.
  */
 func assertThatPluginsIsInstalled(t *testing.T, pluginName string, expectedResult bool) {
-	plugins, err := testRegistry.ListInstalledPlugins()
+	plugins, err := testRegistry.ListInstalledPlugins(context.Background())
 	if err != nil {
 		t.Errorf("Error should not be nil : %v", err)
 		return
@@ -152,53 +152,6 @@ func assertThatPluginsIsInstalled(t *testing.T, pluginName string, expectedResul
 	}
 }
 
-/*
- * Starts a container running a NATS server
- */
-func installNatsServer() {
-	/*
-	 * Container configuration
-	 */
-	containerConfig := docker.Config{
-		Image: "nats",
-	}
-	containerConfig.ExposedPorts = map[docker.Port]struct{}{
-		"4222/tcp": {},
-	}
-
-	portBindings := map[docker.Port][]docker.PortBinding{
-		"4222/tcp": []docker.PortBinding{
-			{
-				HostIP:   "0.0.0.0",
-				HostPort: "4222",
-			},
-		},
-	}
-
-	/*
-	 * Host configuration
-	 */
-	hostConfig := docker.HostConfig{
-		PublishAllPorts: true,
-		NetworkMode:     "agilestacknet",
-		PortBindings:    portBindings,
-	}
-
-	containerOptions := docker.CreateContainerOptions{
-		Name:       "nats",
-		Config:     &containerConfig,
-		HostConfig: &hostConfig,
-	}
-
-	container, err := dockerClient.CreateContainer(containerOptions)
-	if err != nil {
-		log.Printf("Error on createContainer : %v", err)
-	}
-	log.Printf("container for image nats created with ID: %s ", container.ID)
-	dockerClient.StartContainer(container.ID, nil)
-	time.Sleep(500 * time.Millisecond)
-}
-
 func pluginsArrayContains(plugins []*pb.Plugin, pluginName string) bool {
 	for _, item := range plugins {
 		if item.Name == pluginName {
@@ -207,20 +160,3 @@ func pluginsArrayContains(plugins []*pb.Plugin, pluginName string) bool {
 	}
 	return false
 }
-
-/*
- * Computes the URL of the NATS server.
- */
-func natsServerURL() string {
-	dockerEndpoint := os.Getenv("DOCKER_HOST")
-	if dockerEndpoint == "" {
-		if runtime.GOOS == "darwin" {
-			log.Fatal("DOCKER_HOST should be set on a MacOSX")
-		}
-		return "nats://localhost:4222"
-	}
-
-	regex := regexp.MustCompile("tcp://([^:]+):")
-	log.Printf("NATS SERVER = %s", "nats://"+regex.FindStringSubmatch(dockerEndpoint)[1]+":4222")
-	return "nats://" + regex.FindStringSubmatch(dockerEndpoint)[1] + ":4222"
-}
@@ -0,0 +1,264 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry/storage"
+	"github.com/nats-io/nats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	/*
+	 * Prefix of the names of the metrics.
+	 */
+	metricsNamespace = "agilestack_core"
+
+	/*
+	 * Time given to the listing of the plugins when the metrics
+	 * are collected.
+	 */
+	pluginsMetricsTimeout = 10 * time.Second
+)
+
+var (
+	natsRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "nats_requests_total",
+		Help:      "Number of requests received on each NATS topic.",
+	}, []string{"topic"})
+
+	natsRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "nats_request_errors_total",
+		Help:      "Number of requests on each NATS topic that were rejected or failed.",
+	}, []string{"topic"})
+
+	natsRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "nats_request_duration_seconds",
+		Help:      "Time taken to handle the requests on each NATS topic.",
+		Buckets:   []float64{.005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"topic"})
+
+	dockerCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "docker_call_duration_seconds",
+		Help:      "Latency of the calls to the Docker API, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	dockerCallErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "docker_call_errors_total",
+		Help:      "Number of calls to the Docker API that failed, by operation.",
+	}, []string{"operation"})
+
+	imageBuildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "image_build_duration_seconds",
+		Help:      "Time taken to build the plugins' images, by result (\"success\" or \"failure\").",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200},
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(natsRequests, natsRequestErrors, natsRequestDuration,
+		dockerCallDuration, dockerCallErrors, imageBuildDuration)
+	storage.ObserveDockerCall = observeDockerCall
+}
+
+/*
+ * Records a request handled on the given topic.
+ */
+func observeRequest(topic string, status bool, duration time.Duration) {
+	natsRequests.WithLabelValues(topic).Inc()
+	if !status {
+		natsRequestErrors.WithLabelValues(topic).Inc()
+	}
+	natsRequestDuration.WithLabelValues(topic).Observe(duration.Seconds())
+}
+
+/*
+ * Reports in the metrics the requests received on a topic.
+ *
+ * The handlers of the topic reply on the connection returned by
+ * "replies", whose encoder keeps the response to each request being
+ * handled, so that the failed requests are counted.
+ */
+type requestsMeter struct {
+	encoder nats.Encoder
+
+	mutex     sync.Mutex
+	responses map[string]interface{}
+}
+
+func newRequestsMeter(encoder nats.Encoder) *requestsMeter {
+	return &requestsMeter{encoder: encoder, responses: make(map[string]interface{})}
+}
+
+func (meter *requestsMeter) replies(connection *nats.EncodedConn) *nats.EncodedConn {
+	return &nats.EncodedConn{Conn: connection.Conn, Enc: meter}
+}
+
+/*
+ * Returns the handler calling the given one and reporting the request
+ * in the metrics.
+ *
+ * The requests that cannot be decoded are replied to with an error.
+ */
+func (meter *requestsMeter) handler(subscriber *natsSubscriber, replies *nats.EncodedConn, topic string, handler nats.Handler) nats.Handler {
+	return func(message *nats.Msg) {
+		start := time.Now()
+		if message.Reply != "" {
+			meter.mutex.Lock()
+			meter.responses[message.Reply] = nil
+			meter.mutex.Unlock()
+		}
+
+		if err := invokeHandler(replies.Enc, handler, message, message.Data); err != nil {
+			log.Printf("Error while decoding the request on %s : %v", message.Subject, err)
+			subscriber.rejectInvalidRequest(replies, topic, message, err)
+		}
+
+		meter.mutex.Lock()
+		response := meter.responses[message.Reply]
+		delete(meter.responses, message.Reply)
+		meter.mutex.Unlock()
+		observeRequest(topic, requestSucceeded(response), time.Since(start))
+	}
+}
+
+/*
+ * The responses sent in a "ResponseEnvelope" were kept before being
+ * put in the envelope : only the envelopes holding an error are kept.
+ */
+func (meter *requestsMeter) Encode(subject string, v interface{}) ([]byte, error) {
+	envelope, isEnvelope := v.(*pb.ResponseEnvelope)
+	if !isEnvelope || envelope.Error != nil {
+		meter.mutex.Lock()
+		if _, ok := meter.responses[subject]; ok {
+			meter.responses[subject] = v
+		}
+		meter.mutex.Unlock()
+	}
+	return meter.encoder.Encode(subject, v)
+}
+
+func (meter *requestsMeter) Decode(subject string, data []byte, vPtr interface{}) error {
+	return meter.encoder.Decode(subject, data, vPtr)
+}
+
+/*
+ * Returns whether or not the request replied to with the given response
+ * succeeded. The requests without response are considered successful.
+ */
+func requestSucceeded(response interface{}) bool {
+	switch response := response.(type) {
+	case *pb.ResponseEnvelope:
+		return response.Error == nil
+	case *pb.PluginConfigurationResponse:
+		return response.Error == ""
+	case *pb.GarbageCollectionReport:
+		return response.Error == ""
+	case *pb.AuditEntries:
+		return response.Error == ""
+	}
+	entry := &pb.AuditEntry{Status: true}
+	setAuditResult(entry, response)
+	return entry.Status
+}
+
+/*
+ * Records a call to the Docker API, begun at the given time.
+ */
+func observeDockerCall(operation string, start time.Time, err error) {
+	dockerCallDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		dockerCallErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+/*
+ * Records a build of a plugin's image, begun at the given time.
+ */
+func observeImageBuild(start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	imageBuildDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+/*
+ * Returns the handler of the "/metrics" endpoint, exposing the metrics
+ * of core and the number of plugins of the given storage.
+ *
+ * The plugins are listed from the storage rather than from the registry,
+ * which logs each listing.
+ */
+func NewMetricsHandler(storageClient PluginStorageClient) http.Handler {
+	plugins := prometheus.NewRegistry()
+	plugins.MustRegister(&pluginsCollector{storageClient: storageClient})
+	return promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, plugins}, promhttp.HandlerOpts{})
+}
+
+var pluginsDescription = prometheus.NewDesc(
+	prometheus.BuildFQName(metricsNamespace, "", "plugins"),
+	"Number of plugins, by list (\"installed\" or \"available\") and status.",
+	[]string{"list", "status"}, nil)
+
+/*
+ * Counts the installed and the available plugins each time the metrics
+ * are collected.
+ */
+type pluginsCollector struct {
+	storageClient PluginStorageClient
+}
+
+func (collector *pluginsCollector) Describe(descriptions chan<- *prometheus.Desc) {
+	descriptions <- pluginsDescription
+}
+
+func (collector *pluginsCollector) Collect(metrics chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginsMetricsTimeout)
+	defer cancel()
+
+	lists := []struct {
+		name string
+		list func(ctx context.Context) (*pb.Plugins, error)
+	}{
+		{"installed", collector.storageClient.ListInstalledPlugins},
+		{"available", collector.storageClient.ListInstallablePlugins},
+	}
+	for _, list := range lists {
+		plugins, err := list.list(ctx)
+		if err != nil {
+			log.Printf("Error while listing the %s plugins for the metrics : %v", list.name, err)
+			metrics <- prometheus.NewInvalidMetric(pluginsDescription, err)
+			continue
+		}
+
+		/*
+		 * Every status is reported, so that the series do not disappear
+		 * when no plugin has the status.
+		 */
+		counts := make(map[pb.PluginStatus]int)
+		for status := range pb.PluginStatus_name {
+			counts[pb.PluginStatus(status)] = 0
+		}
+		for _, plugin := range plugins.GetPlugins() {
+			counts[plugin.PluginStatus]++
+		}
+		for status, count := range counts {
+			metrics <- prometheus.MustNewConstMetric(pluginsDescription, prometheus.GaugeValue,
+				float64(count), list.name, status.String())
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package registry_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eogile/agilestack-core/registry"
+)
+
+/*
+ * Tests that the metrics are exposed, the plugins being counted by
+ * list and status.
+ */
+func TestMetrics(t *testing.T) {
+	storageClient := newFakeStorageClient()
+	storageClient.InstallPlugin(context.Background(), "todo", "")
+	storageClient.InstallPlugin(context.Background(), "blog", "")
+
+	handler := registry.NewHealthHandler()
+	assertStatus(t, handler, "/metrics", http.StatusOK)
+
+	handler.SetMetricsHandler(registry.NewMetricsHandler(storageClient))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Invalid status : %d", recorder.Code)
+	}
+
+	body := recorder.Body.String()
+	expectedLines := []string{
+		`agilestack_core_plugins{list="installed",status="OK"} 2`,
+		`agilestack_core_plugins{list="installed",status="NOTINSTALLED"} 0`,
+		`agilestack_core_plugins{list="available",status="NOTINSTALLED"} 0`,
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(body, line) {
+			t.Errorf("Missing line %q in the metrics :\n%s", line, body)
+		}
+	}
+}
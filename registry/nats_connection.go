@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/nats-io/nats/encoders/protobuf"
+)
+
+type ConnectionOptions struct {
+	/*
+	 * Delay before the second connection attempt at startup.
+	 *
+	 * The delay doubles after each failed attempt, up to "MaxBackoff".
+	 */
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	/*
+	 * Maximum number of connection attempts at startup.
+	 * Zero means no limit.
+	 */
+	MaxAttempts int
+
+	/*
+	 * Delay between two reconnection attempts once the connection
+	 * has been lost, and the maximum number of attempts.
+	 * A negative number means no limit.
+	 */
+	ReconnectWait time.Duration
+	MaxReconnects int
+
+	/*
+	 * Size in bytes of the buffer holding the messages published while
+	 * the connection is lost. The messages are sent once the connection
+	 * is re-established.
+	 *
+	 * When the buffer is full, publishing fails. A negative size disables
+	 * the buffering : publishing fails as long as the connection is lost.
+	 */
+	ReconnectBufferSize int
+}
+
+func DefaultConnectionOptions() ConnectionOptions {
+	return ConnectionOptions{
+		InitialBackoff:      500 * time.Millisecond,
+		MaxBackoff:          30 * time.Second,
+		MaxAttempts:         10,
+		ReconnectWait:       2 * time.Second,
+		MaxReconnects:       -1,
+		ReconnectBufferSize: 8 * 1024 * 1024,
+	}
+}
+
+/*
+ * Establishes a connection to the NATS server.
+ *
+ * The program exits if the server cannot be reached.
+ */
+func EstablishConnection(natsServerURL string) *nats.EncodedConn {
+	connection, err := Connect(context.Background(), natsServerURL, DefaultConnectionOptions())
+	if err != nil {
+		log.Fatalf("Error while connecting to the Nats server : %v", err)
+	}
+	return connection
+}
+
+/*
+ * Option setting the size of the buffer holding the messages published
+ * while reconnecting, which the NATS client has no option for.
+ */
+func reconnectBufferSize(size int) nats.Option {
+	return func(natsOptions *nats.Options) error {
+		natsOptions.ReconnectBufSize = size
+		return nil
+	}
+}
+
+/*
+ * Establishes a protobuf connection to the NATS server.
+ *
+ * The connection is retried with an exponential backoff until it
+ * succeeds, the maximum number of attempts is reached or the context
+ * is cancelled.
+ *
+ * The given NATS options are applied after the ones computed from
+ * the connection options, typically to register handlers.
+ */
+func Connect(ctx context.Context, natsServerURL string, options ConnectionOptions, natsOptions ...nats.Option) (*nats.EncodedConn, error) {
+	allOptions := []nats.Option{
+		nats.ReconnectWait(options.ReconnectWait),
+		nats.MaxReconnects(options.MaxReconnects),
+		reconnectBufferSize(options.ReconnectBufferSize),
+	}
+	allOptions = append(allOptions, natsOptions...)
+
+	backoff := options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		connection, err := nats.Connect(natsServerURL, allOptions...)
+		if err == nil {
+			protobufConnection, protobufErr := nats.NewEncodedConn(connection, protobuf.PROTOBUF_ENCODER)
+			if protobufErr != nil {
+				log.Printf("Error while establishing a protobuf connection to the Nats server : %v",
+					protobufErr)
+				connection.Close()
+				return nil, protobufErr
+			}
+			return protobufConnection, nil
+		}
+
+		log.Printf("Connection attempt %d to the Nats server %s failed : %v",
+			attempt, natsServerURL, err)
+		if options.MaxAttempts > 0 && attempt >= options.MaxAttempts {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > options.MaxBackoff {
+			backoff = options.MaxBackoff
+		}
+	}
+}
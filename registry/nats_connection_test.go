@@ -0,0 +1,72 @@
+package registry_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eogile/agilestack-core/registry"
+)
+
+/*
+ * Tests that the connection gives up after the maximum number
+ * of attempts.
+ */
+func TestConnectMaxAttempts(t *testing.T) {
+	options := registry.DefaultConnectionOptions()
+	options.InitialBackoff = 10 * time.Millisecond
+	options.MaxAttempts = 3
+
+	start := time.Now()
+	_, err := registry.Connect(context.Background(), "nats://localhost:1", options)
+	if err == nil {
+		t.Fatal("The connection should fail")
+	}
+
+	/*
+	 * Two waits : 10ms then 20ms.
+	 */
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("The attempts were not delayed : %v", elapsed)
+	}
+}
+
+/*
+ * Tests that the connection attempts stop when the context is cancelled.
+ */
+func TestConnectCancelled(t *testing.T) {
+	options := registry.DefaultConnectionOptions()
+	options.InitialBackoff = time.Hour
+	options.MaxAttempts = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := registry.Connect(ctx, "nats://localhost:1", options)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+/*
+ * Tests that the subscriber is ready once created, and not
+ * anymore after its shutdown.
+ */
+func TestSubscriberReadiness(t *testing.T) {
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !subscriber.IsReady() {
+		t.Error("The subscriber should be ready")
+	}
+
+	subscriber.Shutdown(context.Background())
+	if subscriber.IsReady() {
+		t.Error("The subscriber should not be ready after shutdown")
+	}
+
+	/*
+	 * A second shutdown, for instance on a second signal, does nothing.
+	 */
+	subscriber.Shutdown(context.Background())
+}
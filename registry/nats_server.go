@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/gnatsd/server"
+)
+
+/*
+ * Time given to the embedded NATS server to accept connections.
+ */
+const embeddedNatsStartTimeout = 10 * time.Second
+
+/*
+ * NATS server running in the process of core, so that core can be
+ * deployed without an external server.
+ */
+type EmbeddedNatsServer struct {
+	server *server.Server
+}
+
+/*
+ * Starts a NATS server listening on the given address, such as
+ * "0.0.0.0:4222". A zero port means a random port.
+ */
+func StartEmbeddedNatsServer(listenAddress string) (*EmbeddedNatsServer, error) {
+	host, portValue, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port in %s : %v", listenAddress, err)
+	}
+	if port == 0 {
+		port = server.RANDOM_PORT
+	}
+
+	natsServer := server.New(&server.Options{
+		Host:   host,
+		Port:   port,
+		NoSigs: true,
+	})
+	go natsServer.Start()
+
+	if !natsServer.ReadyForConnections(embeddedNatsStartTimeout) {
+		natsServer.Shutdown()
+		return nil, errors.New("The embedded NATS server did not start listening on " + listenAddress)
+	}
+	return &EmbeddedNatsServer{server: natsServer}, nil
+}
+
+/*
+ * Returns the URL of the server, to which core and the tests connect.
+ *
+ * When listening on every interface, the URL designates the loopback
+ * interface.
+ */
+func (embedded *EmbeddedNatsServer) URL() string {
+	address := embedded.server.Addr().(*net.TCPAddr)
+	host := address.IP.String()
+	if address.IP.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "nats://" + net.JoinHostPort(host, strconv.Itoa(address.Port))
+}
+
+func (embedded *EmbeddedNatsServer) Shutdown() {
+	embedded.server.Shutdown()
+}
@@ -0,0 +1,40 @@
+package registry_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/eogile/agilestack-core/registry"
+)
+
+/*
+ * Tests that an embedded server listening on a random port can be
+ * connected to with its URL.
+ */
+func TestEmbeddedNatsServer(t *testing.T) {
+	natsServer, err := registry.StartEmbeddedNatsServer("0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("Error while starting the server : %v", err)
+	}
+	defer natsServer.Shutdown()
+
+	url := natsServer.URL()
+	if !strings.HasPrefix(url, "nats://127.0.0.1:") || strings.HasSuffix(url, ":0") {
+		t.Errorf("Invalid URL : %s", url)
+	}
+
+	connection, err := registry.Connect(context.Background(), url, registry.DefaultConnectionOptions())
+	if err != nil {
+		t.Fatalf("Error while connecting to the server : %v", err)
+	}
+	connection.Close()
+}
+
+func TestEmbeddedNatsServerInvalidAddress(t *testing.T) {
+	for _, address := range []string{"4222", "localhost:nats"} {
+		if _, err := registry.StartEmbeddedNatsServer(address); err == nil {
+			t.Errorf("The address %s should be rejected", address)
+		}
+	}
+}
@@ -1,41 +1,326 @@
 package registry
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry/storage"
 	"github.com/nats-io/nats"
-	"github.com/nats-io/nats/encoders/protobuf"
 )
 
+/*
+ * What to do with the running plugins when core exits.
+ */
+type ExitPolicy int
+
+const (
+	/*
+	 * The plugins keep running after core exits.
+	 */
+	KeepPluginsOnExit ExitPolicy = iota
+
+	/*
+	 * The plugins are stopped and removed when core exits, except
+	 * the preserved ones.
+	 */
+	StopPluginsOnExit
+)
+
+/*
+ * Parses the textual form of an exit policy : "keep" or "stop".
+ */
+func ParseExitPolicy(value string) (ExitPolicy, error) {
+	switch value {
+	case "keep":
+		return KeepPluginsOnExit, nil
+	case "stop":
+		return StopPluginsOnExit, nil
+	}
+	return KeepPluginsOnExit, fmt.Errorf("Invalid exit policy : %s", value)
+}
+
+/*
+ * Textual form of the exit policy, as parsed by ParseExitPolicy.
+ */
+func (policy ExitPolicy) String() string {
+	if policy == StopPluginsOnExit {
+		return "stop"
+	}
+	return "keep"
+}
+
+type SubscriberOptions struct {
+	/*
+	 * What to do with the running plugins on shutdown.
+	 */
+	ExitPolicy ExitPolicy
+
+	/*
+	 * Plugins that are never stopped on shutdown.
+	 */
+	PreservedPlugins []string
+
+	/*
+	 * Connection and reconnection to the NATS server.
+	 */
+	Connection ConnectionOptions
+
+	/*
+	 * NATS queue group used to subscribe to the request topics, so that
+	 * each request is handled by a single core instance. When empty,
+	 * every instance handles every request.
+	 */
+	QueueGroup string
+
+	/*
+	 * Whether or not several core instances may run at the same time.
+	 * If so, a leader is elected among them. Otherwise, the current
+	 * instance is always the leader.
+	 *
+	 * Only the leader installs, uninstalls and deletes the plugins.
+	 */
+	LeaderElection bool
+
+	/*
+	 * Interval between two leader election heartbeats.
+	 */
+	HeartbeatInterval time.Duration
+
+	/*
+	 * Directory where the last build log of each plugin is saved.
+	 */
+	BuildLogDir string
+
+	/*
+	 * Directory under which the directories given in the plugin
+	 * creation requests must be.
+	 */
+	BuildRoot string
+
+	/*
+	 * Hosts from which the Docker daemon may download the remote build
+	 * contexts. None is allowed by default.
+	 */
+	RemoteContextHosts []string
+
+	/*
+	 * Registry where the plugin images are pushed.
+	 */
+	Registry RegistryOptions
+
+	/*
+	 * What the garbage collection removes, and the interval between two
+	 * scheduled collections. Zero disables the scheduled collections :
+	 * the garbage is then only collected on demand.
+	 */
+	GCPolicy   storage.GCPolicy
+	GCInterval time.Duration
+
+	/*
+	 * Keys of the requesters' tokens. When no key is configured, the
+	 * requests are not authenticated.
+	 */
+	Auth AuthOptions
+
+	/*
+	 * Audit log of the plugin administration actions.
+	 */
+	Audit AuditOptions
+}
+
+func DefaultSubscriberOptions() SubscriberOptions {
+	return SubscriberOptions{
+		ExitPolicy:        KeepPluginsOnExit,
+		PreservedPlugins:  []string{"backoffice"},
+		Connection:        DefaultConnectionOptions(),
+		QueueGroup:        "agilestack-core",
+		LeaderElection:    false,
+		HeartbeatInterval: 2 * time.Second,
+		BuildLogDir:       BUILD_LOG_DIR,
+		BuildRoot:         PLUGIN_BUILD_ROOT,
+		GCPolicy:          storage.DefaultGCPolicy(),
+		GCInterval:        6 * time.Hour,
+		Audit:             DefaultAuditOptions(),
+	}
+}
+
 type natsSubscriber struct {
 	registry      Registry
+	storageClient *DockerStorageClient
+	events        *EventBus
 	connection    *nats.EncodedConn
 	pluginFactory pluginFactory
+	buildLogs     *BuildLogStore
+
+	/*
+	 * Connection sharing the protobuf connection's link to the NATS
+	 * server, encoding the messages of the "core.json.*" topics.
+	 */
+	jsonConnection *nats.EncodedConn
+
+	/*
+	 * Checks the requests when the authentication is enabled, nil otherwise.
+	 */
+	auth *authGuard
+
+	/*
+	 * Audit log of the administration actions, nil when disabled.
+	 */
+	audit *AuditLog
+
+	/*
+	 * Records the audited requests in the audit log, nil when disabled.
+	 */
+	auditor *Auditor
 
 	natsServerURL string
+	options       SubscriberOptions
+
+	elector LeaderElector
+
+	subscriptions      []*topicSubscription
+	subscriptionsMutex sync.Mutex
+
+	/*
+	 * Topics served in each version of the API, by version.
+	 */
+	apiTopics map[string][]string
+
+	/*
+	 * 1 when the subscriber is connected and subscribed to
+	 * its topics, 0 otherwise.
+	 */
+	ready int32
+
+	/*
+	 * Requests currently handled.
+	 *
+	 * The mutex guarantees that no request begins while the
+	 * shutdown waits for the requests in progress.
+	 */
+	inFlight      sync.WaitGroup
+	inFlightMutex sync.RWMutex
+	closing       bool
+
+	/*
+	 * Parent context of the requests. It is cancelled when the
+	 * shutdown deadline is exceeded.
+	 */
+	context context.Context
+	cancel  context.CancelFunc
+
+	/*
+	 * Closed on shutdown to stop the scheduled garbage collections.
+	 */
+	stopGC     chan struct{}
+	stopGCOnce sync.Once
+
+	/*
+	 * Stops the forwarding of the plugins' events to NATS.
+	 */
+	stopEvents func()
+}
+
+/*
+ * Subscription to a topic, kept to be able to subscribe again.
+ */
+type topicSubscription struct {
+	topic        string
+	connection   *nats.EncodedConn
+	handler      nats.Handler
+	subscription *nats.Subscription
+}
+
+/*
+ * Returns the handler of a topic, replying on the given connection so
+ * that the replies have the encoding of the requests.
+ */
+type handlerFactory func(connection *nats.EncodedConn) nats.Handler
+
+func NewNatsSubscriber(natsServerURL string) (*natsSubscriber, error) {
+	return NewNatsSubscriberWithOptions(natsServerURL, DefaultSubscriberOptions())
 }
 
-func NewNatsSubscriber(natsServerURL string) *natsSubscriber {
+/*
+ * Connects to the NATS server and subscribes to the topics.
+ *
+ * Returns an error if the audit log cannot be opened, or if the
+ * connection or the leader election cannot be established.
+ */
+func NewNatsSubscriberWithOptions(natsServerURL string, options SubscriberOptions) (*natsSubscriber, error) {
 	subscriber := &natsSubscriber{}
 	subscriber.natsServerURL = natsServerURL
+	subscriber.options = options
+	subscriber.apiTopics = make(map[string][]string)
+	if options.Audit.Path != "" {
+		audit, err := OpenAuditLog(options.Audit)
+		if err != nil {
+			return nil, fmt.Errorf("Error while opening the audit log : %v", err)
+		}
+		subscriber.audit = audit
+	}
+	if options.Auth.Enabled() {
+		subscriber.auth = newAuthGuard(options.Auth)
+	} else {
+		log.Println("The authentication is disabled : anyone on the NATS bus can send requests to core")
+	}
+	subscriber.context, subscriber.cancel = context.WithCancel(context.Background())
 
 	/*
 	 * Initializing the registry
 	 */
 	dockerWrapper := NewDockerStorageClient()
-	subscriber.registry = NewInMemoryRegistry(dockerWrapper)
+	inMemoryRegistry := NewInMemoryRegistry(dockerWrapper)
+	subscriber.registry = inMemoryRegistry
+	subscriber.storageClient = dockerWrapper
+	if subscriber.audit != nil {
+		subscriber.auditor = NewAuditor(subscriber.audit, dockerWrapper)
+	}
+	subscriber.events = inMemoryRegistry.Events()
 
-	connection := EstablishConnection(natsServerURL)
+	connection, err := Connect(subscriber.context, natsServerURL, options.Connection,
+		nats.DisconnectHandler(subscriber.onDisconnect),
+		nats.ReconnectHandler(subscriber.onReconnect),
+		nats.ClosedHandler(subscriber.onClose))
+	if err != nil {
+		subscriber.release()
+		return nil, fmt.Errorf("Error while connecting to the Nats server : %v", err)
+	}
 	subscriber.connection = connection
 
+	jsonConnection, err := nats.NewEncodedConn(connection.Conn, PROTO_JSON_ENCODER)
+	if err != nil {
+		subscriber.release()
+		return nil, fmt.Errorf("Error while establishing a JSON connection to the Nats server : %v", err)
+	}
+	subscriber.jsonConnection = jsonConnection
+
+	/*
+	 * Initializing the leader election.
+	 */
+	subscriber.elector = singleInstanceElector{}
+	if options.LeaderElection {
+		elector := NewNatsLeaderElector(connection.Conn, NewInstanceID(), options.HeartbeatInterval)
+		if err := elector.Start(); err != nil {
+			subscriber.release()
+			return nil, fmt.Errorf("Error while starting the leader election : %v", err)
+		}
+		subscriber.elector = elector
+	}
+	inMemoryRegistry.SetLeaderElector(subscriber.elector)
+
 	/*
 	 * Initializing the plugins factory.
 	 */
-	subscriber.pluginFactory = NewPluginFactory()
+	subscriber.pluginFactory = NewPluginFactory(dockerWrapper, options.BuildRoot, options.RemoteContextHosts, options.Registry)
+	subscriber.buildLogs = NewBuildLogStore(options.BuildLogDir)
 
 	/*
 	 * Initializing NATS subscriptions
@@ -45,155 +330,789 @@ func NewNatsSubscriber(natsServerURL string) *natsSubscriber {
 	subscriber.subscribeToInstallPlugin()
 	subscriber.subscribeToUninstallPlugin()
 	subscriber.subscribeToCreatePlugin()
+	subscriber.subscribeToListPluginTemplates()
+	subscriber.subscribeToGetBuildLog()
+	subscriber.subscribeToUploadArchive()
+	subscriber.subscribeToGetPluginConfiguration()
+	subscriber.subscribeToPushPlugin()
+	subscriber.subscribeToDeletePlugin()
+	subscriber.subscribeToGarbageCollection()
+	subscriber.subscribeToAPIVersions()
+	subscriber.subscribeToAuditQuery()
 
-	return subscriber
+	events, stopEvents := subscriber.events.Subscribe()
+	subscriber.stopEvents = stopEvents
+	go subscriber.forwardEvents(events)
+
+	subscriber.stopGC = make(chan struct{})
+	if options.GCInterval > 0 {
+		go subscriber.scheduleGarbageCollection(options.GCInterval)
+	}
+
+	atomic.StoreInt32(&subscriber.ready, 1)
+	return subscriber, nil
+}
+
+/*
+ * Releases what was acquired by a subscriber whose creation failed.
+ */
+func (subscriber *natsSubscriber) release() {
+	subscriber.cancel()
+	if subscriber.connection != nil {
+		subscriber.connection.Close()
+	}
+	if subscriber.audit != nil {
+		subscriber.audit.Close()
+	}
+	if subscriber.storageClient != nil {
+		subscriber.storageClient.Close()
+	}
+}
+
+/*
+ * Returns the registry of the plugins handling the requests.
+ */
+func (subscriber *natsSubscriber) Registry() Registry {
+	return subscriber.registry
+}
+
+/*
+ * Returns the storage where the plugins are installed.
+ */
+func (subscriber *natsSubscriber) StorageClient() PluginStorageClient {
+	return subscriber.storageClient
 }
 
 /*
- * Establishes a connection to the NATS server.
+ * Returns the auditor recording the administration requests, nil when
+ * the audit log is disabled.
  */
-func EstablishConnection(natsServerURL string) *nats.EncodedConn {
-	connection, err := nats.Connect(natsServerURL)
+func (subscriber *natsSubscriber) Auditor() *Auditor {
+	return subscriber.auditor
+}
+
+/*
+ * Returns the bus where the events of the plugins are published.
+ */
+func (subscriber *natsSubscriber) Events() *EventBus {
+	return subscriber.events
+}
+
+/*
+ * Publishes the events of the plugins on the "core.events" topic until
+ * the channel is closed.
+ */
+func (subscriber *natsSubscriber) forwardEvents(events <-chan *pb.PluginEvent) {
+	for event := range events {
+		if err := subscriber.publish(pb.PluginEventsTopic, event); err != nil {
+			log.Printf("Error while publishing the event %v : %v", event, err)
+		}
+	}
+}
+
+/*
+ * Publishes a message on the given topic and on its JSON form, for the
+ * messages that are not replies, such as the events or the build output.
+ */
+func (subscriber *natsSubscriber) publish(topic string, message interface{}) error {
+	if err := subscriber.connection.Publish(topic, message); err != nil {
+		return err
+	}
+	return subscriber.jsonConnection.Publish(pb.JSONTopic(topic), message)
+}
+
+/*
+ * Subscribes the given handler to the given topic in every version of
+ * the API using the same messages : the topic without version, the v1
+ * topic and, unless its messages changed, the v2 topic.
+ */
+func (subscriber *natsSubscriber) subscribe(topic string, newHandler handlerFactory) {
+	subscriber.subscribeExactly(topic, newHandler)
+	subscriber.subscribeVersion(pb.APIVersion1, topic, newHandler)
+	if !pb.ChangedInV2(topic) {
+		subscriber.subscribeVersion(pb.APIVersion2, topic, newHandler)
+	}
+}
+
+/*
+ * Subscribes the given handler to the topic of the given API version.
+ */
+func (subscriber *natsSubscriber) subscribeVersion(version string, topic string, newHandler handlerFactory) {
+	versionedTopic := pb.VersionedTopic(version, topic)
+	subscriber.subscribeExactly(versionedTopic, newHandler)
+
+	subscriber.subscriptionsMutex.Lock()
+	defer subscriber.subscriptionsMutex.Unlock()
+	subscriber.apiTopics[version] = append(subscriber.apiTopics[version], versionedTopic)
+}
+
+/*
+ * Subscribes the given handler to the given topic, in the queue group
+ * if any, and to the JSON form of the topic.
+ *
+ * The subscriptions are kept so that they can be cancelled on shutdown.
+ */
+func (subscriber *natsSubscriber) subscribeExactly(topic string, newHandler handlerFactory) {
+	subscriber.subscribeWith(subscriber.connection, topic, newHandler)
+	subscriber.subscribeWith(subscriber.jsonConnection, pb.JSONTopic(topic), newHandler)
+}
+
+/*
+ * When the authentication is enabled, the requests are checked before
+ * being handed to the handler. The requests of the audited topics are
+ * recorded in the audit log. Every request is reported in the metrics.
+ */
+func (subscriber *natsSubscriber) subscribeWith(connection *nats.EncodedConn, topic string, newHandler handlerFactory) {
+	meter := newRequestsMeter(connection.Enc)
+	replies := meter.replies(connection)
+
+	handler := newHandler(replies)
+	if subscriber.auditor != nil && isAudited(topic) {
+		handler = subscriber.auditedHandler(replies, topic, newHandler)
+	} else if subscriber.auth != nil {
+		handler = subscriber.auth.handler(replies, topic, newHandler)
+	}
+	handler = meter.handler(subscriber, replies, topic, handler)
+
+	subscription, err := subscriber.subscribeToTopic(connection, topic, handler)
 	if err != nil {
-		log.Fatalf("Error while connecting to the Nats server : %v", err)
+		log.Printf("Error while subscribing to %s : %v", topic, err)
+	}
+
+	subscriber.subscriptionsMutex.Lock()
+	defer subscriber.subscriptionsMutex.Unlock()
+	subscriber.subscriptions = append(subscriber.subscriptions, &topicSubscription{
+		topic:        topic,
+		connection:   connection,
+		handler:      handler,
+		subscription: subscription,
+	})
+}
+
+/*
+ * Topics of the requests changing the plugins, which only the leader
+ * handles (see "ErrNotLeader").
+ */
+var leaderTopics = map[string]bool{
+	pb.InstallPluginTopic:     true,
+	pb.UninstallPluginTopic:   true,
+	pb.DeletePluginTopic:      true,
+	pb.GarbageCollectionTopic: true,
+}
+
+/*
+ * When the leader election is enabled, every instance subscribes to the
+ * leader topics outside the queue group and the requests are ignored by
+ * the instances leaving them to another one, so that the leader handles
+ * all of them. While no leader is elected, a single instance answers
+ * them with "ErrNotLeader".
+ */
+func (subscriber *natsSubscriber) subscribeToTopic(connection *nats.EncodedConn, topic string, handler nats.Handler) (*nats.Subscription, error) {
+	if subscriber.options.LeaderElection && leaderTopics[pb.BaseTopic(topic)] {
+		return connection.Subscribe(topic, subscriber.leaderHandler(handler))
+	}
+	if subscriber.options.QueueGroup == "" {
+		return connection.Subscribe(topic, handler)
+	}
+	return connection.QueueSubscribe(topic, subscriber.options.QueueGroup, handler)
+}
+
+/*
+ * Returns the handler ignoring the requests that another instance answers.
+ * The other requests are handled, the registry failing with "ErrNotLeader"
+ * when the instance is not the leader. The given handler receives the
+ * messages themselves, as the handlers of the metrics do.
+ */
+func (subscriber *natsSubscriber) leaderHandler(handler nats.Handler) nats.Handler {
+	return func(message *nats.Msg) {
+		if !subscriber.IsLeader() && subscriber.elector.LeaderElsewhere() {
+			return
+		}
+		callHandler(handler, message, message)
 	}
+}
 
-	protobufConnection, protobufErr := nats.NewEncodedConn(connection, protobuf.PROTOBUF_ENCODER)
-	if protobufErr != nil {
-		log.Fatalf("Error while establishing a protobuf connection to the Nats server : %v",
-			protobufErr)
+/*
+ * Returns a boolean indicating whether or not the current instance is
+ * the leader among the running core instances.
+ */
+func (subscriber *natsSubscriber) IsLeader() bool {
+	return subscriber.elector.IsLeader()
+}
+
+/*
+ * Subscribes again to the topics whose subscription is not valid anymore.
+ *
+ * Returns false if at least one subscription failed.
+ */
+func (subscriber *natsSubscriber) resubscribe() bool {
+	subscriber.subscriptionsMutex.Lock()
+	defer subscriber.subscriptionsMutex.Unlock()
+
+	success := true
+	for _, item := range subscriber.subscriptions {
+		if item.subscription != nil && item.subscription.IsValid() {
+			continue
+		}
+		log.Printf("Subscribing again to %s", item.topic)
+		subscription, err := subscriber.subscribeToTopic(item.connection, item.topic, item.handler)
+		if err != nil {
+			log.Printf("Error while subscribing to %s : %v", item.topic, err)
+			success = false
+			continue
+		}
+		item.subscription = subscription
 	}
-	return protobufConnection
+	return success
+}
+
+/*
+ * Returns a boolean indicating whether or not the subscriber is
+ * connected to the NATS server and able to handle requests.
+ */
+func (subscriber *natsSubscriber) IsReady() bool {
+	return atomic.LoadInt32(&subscriber.ready) == 1 &&
+		subscriber.connection.Conn.IsConnected()
+}
+
+func (subscriber *natsSubscriber) onDisconnect(connection *nats.Conn) {
+	log.Printf("Disconnected from the Nats server %s", subscriber.natsServerURL)
+	atomic.StoreInt32(&subscriber.ready, 0)
+}
+
+func (subscriber *natsSubscriber) onReconnect(connection *nats.Conn) {
+	log.Printf("Reconnected to the Nats server %s", connection.ConnectedUrl())
+	if subscriber.resubscribe() {
+		atomic.StoreInt32(&subscriber.ready, 1)
+	}
+}
+
+func (subscriber *natsSubscriber) onClose(connection *nats.Conn) {
+	log.Printf("Connection to the Nats server %s closed", subscriber.natsServerURL)
+	atomic.StoreInt32(&subscriber.ready, 0)
+}
+
+/*
+ * Marks the beginning of a request.
+ *
+ * The returned function must be called when the request is handled.
+ *
+ * Requests received once the shutdown has begun are given a cancelled
+ * context, so that they fail immediately.
+ */
+func (subscriber *natsSubscriber) beginRequest() (context.Context, func()) {
+	subscriber.inFlightMutex.RLock()
+	defer subscriber.inFlightMutex.RUnlock()
+
+	if subscriber.closing {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx, func() {}
+	}
+	subscriber.inFlight.Add(1)
+	return subscriber.context, subscriber.inFlight.Done
 }
 
 /*
  * Subscribes to the "listAvailablePlugins" topic.
  */
-func (subscriber natsSubscriber) subscribeToListAvailablePlugins() {
-	subscriber.connection.Subscribe(pb.ListAvailablePluginsTopic, func(m *nats.Msg) {
+func (subscriber *natsSubscriber) subscribeToListAvailablePlugins() {
+	subscriber.subscribe(pb.ListAvailablePluginsTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(m *nats.Msg) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
 
-		response, _ := subscriber.registry.ListAvailablePlugins()
-		subscriber.connection.Publish(m.Reply, response)
+			response, _ := subscriber.registry.ListAvailablePlugins(ctx)
+			connection.Publish(m.Reply, response)
+		}
 	})
 }
 
 /*
  * Subscribes to the "listInstalledPlugins" topic.
  */
-func (subscriber natsSubscriber) subscribeToListInstalledPlugins() {
-	subscriber.connection.Subscribe(pb.ListInstalledPluginsTopic, func(m *nats.Msg) {
+func (subscriber *natsSubscriber) subscribeToListInstalledPlugins() {
+	subscriber.subscribe(pb.ListInstalledPluginsTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(m *nats.Msg) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
 
-		response, _ := subscriber.registry.ListInstalledPlugins()
-		subscriber.connection.Publish(m.Reply, response)
+			response, _ := subscriber.registry.ListInstalledPlugins(ctx)
+			connection.Publish(m.Reply, response)
+		}
 	})
 }
 
 /*
  * Subscribes to the "installPlugin" topic.
  */
-func (subscriber natsSubscriber) subscribeToInstallPlugin() {
-	subscriber.connection.Subscribe(pb.InstallPluginTopic, func(_ string, reply string, installRequest *pb.InstallPluginRequest) {
+func (subscriber *natsSubscriber) subscribeToInstallPlugin() {
+	subscriber.subscribe(pb.InstallPluginTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, installRequest *pb.InstallPluginRequest) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
+
+			response, err := subscriber.registry.InstallPlugin(ctx, *installRequest)
+			if err != nil {
+				log.Println("Error while installing the plugin", err)
+				connection.Publish(reply, &pb.NetResponse{Response: pb.Responses_ERROR, Details: err.Error()})
+			} else {
+				connection.Publish(reply, response)
+			}
 
-		response, err := subscriber.registry.InstallPlugin(*installRequest)
-		if err != nil {
-			log.Println("Error while installing the plugin", err)
-			subscriber.connection.Publish(reply, &pb.NetResponse{Response: pb.Responses_ERROR, Details: err.Error()})
-		} else {
-			subscriber.connection.Publish(reply, response)
 		}
+	})
+	subscriber.subscribeVersion(pb.APIVersion2, pb.InstallPluginTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, request *pb.InstallRequest) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
 
+			response, err := subscriber.registry.InstallPlugin(ctx, *request.ToV1())
+			if err != nil {
+				log.Println("Error while installing the plugin", err)
+			}
+			connection.Publish(reply, operationResponse(request.Name, response, err))
+		}
 	})
 }
 
 /*
  * Subscribes to the "uninstallPlugin" topic.
  */
-func (subscriber natsSubscriber) subscribeToUninstallPlugin() {
-	subscriber.connection.Subscribe(pb.UninstallPluginTopic, func(_ string, reply string, plugin *pb.Plugin) {
+func (subscriber *natsSubscriber) subscribeToUninstallPlugin() {
+	subscriber.subscribe(pb.UninstallPluginTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, plugin *pb.Plugin) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
 
-		response, err := subscriber.registry.UninstallPlugin(*plugin)
-		if err != nil {
-			log.Println("Error while uninstalling the plugin", err)
-			subscriber.connection.Publish(reply, &pb.NetResponse{Response: pb.Responses_ERROR, Details: err.Error()})
-		} else {
-			log.Printf("Plugin %s was uninstalled.", plugin.Name)
-			subscriber.connection.Publish(reply, response)
+			response, err := subscriber.registry.UninstallPlugin(ctx, *plugin)
+			if err != nil {
+				log.Println("Error while uninstalling the plugin", err)
+				connection.Publish(reply, &pb.NetResponse{Response: pb.Responses_ERROR, Details: err.Error()})
+			} else {
+				log.Printf("Plugin %s was uninstalled.", plugin.Name)
+				connection.Publish(reply, response)
+			}
+
+		}
+	})
+	subscriber.subscribeVersion(pb.APIVersion2, pb.UninstallPluginTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, request *pb.UninstallRequest) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
+
+			response, err := subscriber.registry.UninstallPlugin(ctx, *request.ToV1())
+			if err != nil {
+				log.Println("Error while uninstalling the plugin", err)
+			} else {
+				log.Printf("Plugin %s was uninstalled.", request.Name)
+			}
+			connection.Publish(reply, operationResponse(request.Name, response, err))
+		}
+	})
+}
+
+/*
+ * Subscribes to the "core.plugin.config" topic.
+ *
+ * Replies with the configuration of the requested installed plugin.
+ */
+func (subscriber *natsSubscriber) subscribeToGetPluginConfiguration() {
+	subscriber.subscribe(pb.GetPluginConfigurationTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, request *pb.NameRequest) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
+
+			configuration, err := subscriber.registry.GetPluginConfiguration(ctx, request.Name)
+			if err != nil {
+				log.Println("Error while reading the plugin configuration", err)
+				connection.Publish(reply, &pb.PluginConfigurationResponse{Error: err.Error()})
+				return
+			}
+			connection.Publish(reply, &pb.PluginConfigurationResponse{Configuration: configuration})
+		}
+	})
+}
+
+/*
+ * Subscribes to the "core.plugin.delete" topic.
+ */
+func (subscriber *natsSubscriber) subscribeToDeletePlugin() {
+	subscriber.subscribe(pb.DeletePluginTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, request *pb.DeletePluginRequest) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
+
+			response, err := subscriber.registry.DeletePlugin(ctx, *request)
+			if err != nil {
+				log.Println("Error while deleting the plugin", err)
+				connection.Publish(reply, &pb.DeletePluginResponse{Error: err.Error()})
+				return
+			}
+			log.Printf("Plugin %s was deleted.", request.Name)
+			connection.Publish(reply, response)
 		}
+	})
+}
+
+/*
+ * Subscribes to the "core.gc" topic.
+ *
+ * Collects the garbage according to the configured policy and replies
+ * with the report.
+ */
+func (subscriber *natsSubscriber) subscribeToGarbageCollection() {
+	subscriber.subscribe(pb.GarbageCollectionTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, request *pb.GarbageCollectionRequest) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
 
+			report, err := subscriber.registry.CollectGarbage(ctx, subscriber.options.GCPolicy, request.DryRun)
+			if err != nil {
+				connection.Publish(reply, &pb.GarbageCollectionReport{DryRun: request.DryRun, Error: err.Error()})
+				return
+			}
+			connection.Publish(reply, report)
+		}
 	})
 }
 
+/*
+ * Collects the garbage periodically until shutdown.
+ *
+ * When several core instances are running, only the leader collects
+ * the garbage.
+ */
+func (subscriber *natsSubscriber) scheduleGarbageCollection(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-subscriber.stopGC:
+			return
+		case <-ticker.C:
+		}
+		if !subscriber.IsLeader() {
+			continue
+		}
+
+		ctx, done := subscriber.beginRequest()
+		subscriber.registry.CollectGarbage(ctx, subscriber.options.GCPolicy, false)
+		done()
+	}
+}
+
 /*
  * Subscribes to the "core.plugin.create" topic.
  *
  * When a message is received on this topic, then a new plugin should be created.
+ *
+ * The build output is streamed on the build's topic while the plugin
+ * is created, and saved as the plugin's last build log.
+ */
+func (subscriber *natsSubscriber) subscribeToCreatePlugin() {
+	subscriber.subscribe(pb.CreatePlugin, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, request *pb.NewPluginRequest) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
+			connection.Publish(reply, subscriber.CreatePlugin(ctx, request))
+		}
+	})
+}
+
+/*
+ * Creates a plugin, streaming the build output on the build's topic and
+ * saving it as the plugin's last build log.
+ *
+ * The errors are reported in the response.
  */
-func (subscriber natsSubscriber) subscribeToCreatePlugin() {
-	subscriber.connection.Subscribe(pb.CreatePlugin, func(_ string, reply string, request *pb.NewPluginRequest) {
-		log.Println("Creating the plugin", request.Name)
+func (subscriber *natsSubscriber) CreatePlugin(ctx context.Context, request *pb.NewPluginRequest) *pb.NewPluginResponse {
+	log.Println("Creating the plugin", request.Name)
+
+	if errs := ValidateNewPluginRequest(request, subscriber.options.BuildRoot, subscriber.options.RemoteContextHosts); len(errs) > 0 {
+		log.Println("Invalid plugin creation request.", errs)
+		return &pb.NewPluginResponse{
+			Error:            errs.Error(),
+			ValidationErrors: errs.Proto(),
+		}
+	}
+
+	buildID := request.BuildId
+	if buildID == "" {
+		buildID = NewBuildID()
+	}
+
+	topic := pb.BuildLogTopic(buildID)
+	buildLog := NewBuildLogWriter(buildID, func(line *pb.BuildLogLine) {
+		subscriber.publish(topic, line)
+	})
 
-		err := subscriber.pluginFactory.CreatePlugin(request)
-		status := err == nil
+	output := io.MultiWriter(os.Stdout, buildLog)
+	err := subscriber.pluginFactory.CreatePlugin(ctx, request, output)
+
+	response := &pb.NewPluginResponse{BuildId: buildID}
+	if err != nil {
+		response.FailedStep = buildLog.LastStep()
+	} else if request.Push {
+		response.Image, response.Digest, err = subscriber.pushTags(ctx, request, output)
 		if err != nil {
-			log.Println("Error while creating the plugin.", err)
+			response.FailedStep = "Push"
 		}
+	}
+	buildLog.Close()
+	response.Status = err == nil
+	if err != nil {
+		log.Println("Error while creating the plugin.", err)
+		response.Error = err.Error()
+		buildLog.WriteLine("Error: " + err.Error())
+	} else {
 		log.Printf("Image %s created.\n", request.Name)
+		subscriber.events.Publish(newPluginEvent(pb.PluginEventType_CREATED, PLUGIN_IMAGE_PREFIX+request.Name))
+	}
+
+	lines := buildLog.Lines()
+	response.LogLines = int64(len(lines))
 
-		response := &pb.NewPluginResponse{Status: status}
-		subscriber.connection.Publish(reply, response)
+	saveErr := subscriber.buildLogs.Save(&pb.BuildLog{
+		Name:       request.Name,
+		BuildId:    buildID,
+		Status:     response.Status,
+		FailedStep: response.FailedStep,
+		Error:      response.Error,
+		Lines:      lines,
+		FinishedAt: time.Now().Unix(),
 	})
+	if saveErr != nil {
+		log.Println("Error while saving the build log.", saveErr)
+	}
+	return response
 }
 
-func (subscriber natsSubscriber) Shutdown() {
-	subscriber.connection.Close()
+/*
+ * Pushes the tag and the extra tags of a newly created plugin.
+ *
+ * Returns the reference and the digest of the image pushed with
+ * the main tag.
+ */
+func (subscriber *natsSubscriber) pushTags(ctx context.Context, request *pb.NewPluginRequest, output io.Writer) (string, string, error) {
+	image, digest, err := subscriber.pluginFactory.PushPlugin(ctx, request.Name, request.Tag, output)
+	if err != nil {
+		return "", "", err
+	}
+	for _, tag := range request.ExtraTags {
+		if _, _, err := subscriber.pluginFactory.PushPlugin(ctx, request.Name, tag, output); err != nil {
+			return "", "", err
+		}
+	}
+	return image, digest, nil
 }
 
 /*
- * Registering a shutdown hook to stop and remove all plugins
- * containers when the current container stops.
- *
- * This hook is only for dev comfort.
+ * Subscribes to the "core.plugin.push" topic.
  *
- * FIXME Removes the hook when plugins lifecycle will be properly managed
+ * The push progress is streamed on the push's topic while the image
+ * is pushed.
  */
-func (subscriber natsSubscriber) InitShutdownHook() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGTERM)
-	log.Println("Initializing shutdown hook")
-	go func() {
-		for _ = range c {
-			log.Println("Intercepting \"Interrupt\" signal")
-			subscriber.stopPlugins()
+func (subscriber *natsSubscriber) subscribeToPushPlugin() {
+	subscriber.subscribe(pb.PushPluginTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, request *pb.PushPluginRequest) {
+			ctx, done := subscriber.beginRequest()
+			defer done()
+			log.Println("Pushing the plugin", request.Name)
+
+			pushID := request.PushId
+			if pushID == "" {
+				pushID = NewBuildID()
+			} else if err := ValidateBuildID(pushID); err != nil {
+				connection.Publish(reply, &pb.PushPluginResponse{Error: err.Error()})
+				return
+			}
 
-			process, err := os.FindProcess(os.Getpid())
+			topic := pb.PushProgressTopic(pushID)
+			progress := NewBuildLogWriter(pushID, func(line *pb.BuildLogLine) {
+				subscriber.publish(topic, line)
+			})
+
+			image, digest, err := subscriber.pluginFactory.PushPlugin(ctx, request.Name, request.Tag, progress)
+			progress.Close()
+
+			response := &pb.PushPluginResponse{Status: err == nil, PushId: pushID, Image: image, Digest: digest}
 			if err != nil {
-				log.Printf("Error while finding process : %v", err)
-				os.Exit(1)
+				log.Println("Error while pushing the plugin.", err)
+				response.Error = err.Error()
 			} else {
-				/*
-				 * Sending a SIGINT signal.
-				 *
-				 * Sending a SIGTERM signal does not work.
-				 */
-				close(c)
-				signal.Stop(c)
-				process.Signal(os.Interrupt)
+				log.Printf("Image %s pushed (%s).", image, digest)
+			}
+			connection.Publish(reply, response)
+		}
+	})
+}
+
+/*
+ * Subscribes to the "core.plugin.buildlog" topic.
+ *
+ * Replies with the last build log of the requested plugin, or with
+ * an empty log if the plugin has never been built.
+ */
+func (subscriber *natsSubscriber) subscribeToGetBuildLog() {
+	subscriber.subscribe(pb.GetBuildLogTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, request *pb.NameRequest) {
+			_, done := subscriber.beginRequest()
+			defer done()
+
+			buildLog, err := subscriber.buildLogs.Get(request.Name)
+			if err != nil {
+				log.Println("Error while reading the build log.", err)
 			}
+			if buildLog == nil {
+				buildLog = &pb.BuildLog{Name: request.Name}
+			}
+			connection.Publish(reply, buildLog)
 		}
+	})
+}
+
+/*
+ * Subscribes to the "core.plugin.upload" topic.
+ *
+ * Each message is a chunk of an archive from which a plugin can then
+ * be created.
+ */
+func (subscriber *natsSubscriber) subscribeToUploadArchive() {
+	subscriber.subscribe(pb.UploadArchiveTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(_ string, reply string, chunk *pb.ArchiveChunk) {
+			_, done := subscriber.beginRequest()
+			defer done()
+
+			err := subscriber.pluginFactory.AppendArchiveChunk(chunk)
+			if err != nil {
+				log.Println("Error while storing the archive chunk", err)
+				connection.Publish(reply, &pb.NetResponse{Response: pb.Responses_ERROR, Details: err.Error()})
+				return
+			}
+			connection.Publish(reply, &pb.NetResponse{Response: pb.Responses_ACK})
+		}
+	})
+}
+
+/*
+ * Subscribes to the "core.plugin.templates" topic.
+ */
+func (subscriber *natsSubscriber) subscribeToListPluginTemplates() {
+	subscriber.subscribe(pb.ListPluginTemplatesTopic, func(connection *nats.EncodedConn) nats.Handler {
+		return func(m *nats.Msg) {
+			_, done := subscriber.beginRequest()
+			defer done()
+
+			response, err := subscriber.pluginFactory.ListTemplates()
+			if err != nil {
+				response = &pb.PluginTemplates{}
+			}
+			connection.Publish(m.Reply, response)
+		}
+	})
+}
+
+/*
+ * Stops the subscriber :
+ * - No more requests are received.
+ * - The requests in progress are given until the context's deadline
+ *   to complete. After that, they are cancelled.
+ * - The plugins are stopped if the exit policy says so and the requests
+ *   were drained in time.
+ * - The cache of the Docker images and containers is stopped.
+ * - The connection to the NATS server is closed.
+ *
+ * A non-nil error is returned if the deadline was exceeded. The
+ * subscriber can be shut down more than once.
+ */
+func (subscriber *natsSubscriber) Shutdown(ctx context.Context) error {
+	log.Println("Shutting down the NATS subscriber")
+	defer subscriber.connection.Close()
+	defer subscriber.storageClient.Close()
+	defer subscriber.stopEvents()
+	if subscriber.audit != nil {
+		defer subscriber.audit.Close()
+	}
+	if elector, ok := subscriber.elector.(*NatsLeaderElector); ok {
+		defer elector.Stop()
+	}
+
+	atomic.StoreInt32(&subscriber.ready, 0)
+	subscriber.stopGCOnce.Do(func() {
+		close(subscriber.stopGC)
+	})
+
+	subscriber.subscriptionsMutex.Lock()
+	for _, item := range subscriber.subscriptions {
+		if item.subscription == nil {
+			continue
+		}
+		if err := item.subscription.Unsubscribe(); err != nil {
+			log.Printf("Error while unsubscribing from %s : %v", item.topic, err)
+		}
+	}
+	subscriber.subscriptions = nil
+	subscriber.subscriptionsMutex.Unlock()
+
+	/*
+	 * Draining the requests in progress.
+	 */
+	subscriber.inFlightMutex.Lock()
+	subscriber.closing = true
+	subscriber.inFlightMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		subscriber.inFlight.Wait()
+		close(drained)
 	}()
+
+	select {
+	case <-drained:
+		log.Println("All the requests in progress are complete")
+	case <-ctx.Done():
+		log.Println("Shutdown deadline exceeded, cancelling the requests in progress")
+		subscriber.cancel()
+		return ctx.Err()
+	}
+	subscriber.cancel()
+
+	/*
+	 * The plugins are shared by all the core instances : only the
+	 * leader applies the exit policy.
+	 */
+	if subscriber.options.ExitPolicy == StopPluginsOnExit && subscriber.IsLeader() {
+		subscriber.stopPlugins(ctx)
+	}
+	return ctx.Err()
 }
 
 /*
- * Stops all the manually started plugins.
+ * Stops all the plugins but the preserved ones.
  */
-func (subscriber natsSubscriber) stopPlugins() {
-	plugins, _ := subscriber.registry.ListInstalledPlugins()
+func (subscriber *natsSubscriber) stopPlugins(ctx context.Context) {
+	plugins, err := subscriber.registry.ListInstalledPlugins(ctx)
+	if err != nil {
+		log.Printf("[Shutdown] Error while listing the installed plugins : %v", err)
+		return
+	}
 
 	for _, plugin := range plugins.Plugins {
-		log.Print("[Shutdown] stopping plugin ", plugin.Name)
-		if plugin.Name == "backoffice" {
+		if subscriber.isPreserved(plugin.Name) {
 			continue
 		}
-		subscriber.registry.UninstallPlugin(*plugin)
+		log.Print("[Shutdown] stopping plugin ", plugin.Name)
+		subscriber.registry.UninstallPlugin(ctx, *plugin)
+	}
+}
+
+func (subscriber *natsSubscriber) isPreserved(pluginName string) bool {
+	for _, name := range subscriber.options.PreservedPlugins {
+		if name == pluginName {
+			return true
+		}
 	}
+	return false
 }
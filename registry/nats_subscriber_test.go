@@ -1,11 +1,21 @@
 package registry_test
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/eogile/agilestack-core/client"
 	pb "github.com/eogile/agilestack-core/proto"
 	"github.com/eogile/agilestack-core/registry"
+	"github.com/golang-jwt/jwt"
+	"github.com/nats-io/nats"
 )
 
 func TestListAvailablePluginsNats(t *testing.T) {
@@ -14,8 +24,11 @@ func TestListAvailablePluginsNats(t *testing.T) {
 	/*
 	 * Initializing the subscriber
 	 */
-	subscriber := registry.NewNatsSubscriber(localhostNatsServerURL)
-	defer subscriber.Shutdown()
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
 
 	/*
 	 * Establishing a connection to publish messages
@@ -23,7 +36,7 @@ func TestListAvailablePluginsNats(t *testing.T) {
 	connection := registry.EstablishConnection(localhostNatsServerURL)
 
 	var plugins = pb.Plugins{}
-	err := connection.Request(pb.ListAvailablePluginsTopic,
+	err = connection.Request(pb.ListAvailablePluginsTopic,
 		&pb.Empty{}, &plugins, 5000*time.Millisecond)
 	if err != nil {
 		t.Errorf("Error should be nil : %v", err)
@@ -39,8 +52,11 @@ func TestListInstalledPluginsNats(t *testing.T) {
 	/*
 	 * Initializing the subscriber
 	 */
-	subscriber := registry.NewNatsSubscriber(localhostNatsServerURL)
-	defer subscriber.Shutdown()
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
 
 	/*
 	 * Establishing a connection to publish messages
@@ -48,7 +64,7 @@ func TestListInstalledPluginsNats(t *testing.T) {
 	connection := registry.EstablishConnection(localhostNatsServerURL)
 
 	var plugins = pb.Plugins{}
-	err := connection.Request(pb.ListInstalledPluginsTopic,
+	err = connection.Request(pb.ListInstalledPluginsTopic,
 		&pb.Empty{}, &plugins, 5000*time.Millisecond)
 	if err != nil {
 		t.Errorf("Error should be nil : %v", err)
@@ -64,8 +80,11 @@ func TestInstallPluginNats(t *testing.T) {
 	/*
 	 * Initializing the subscriber
 	 */
-	subscriber := registry.NewNatsSubscriber(localhostNatsServerURL)
-	defer subscriber.Shutdown()
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
 
 	/*
 	 * Establishing a connection to publish messages
@@ -76,7 +95,7 @@ func TestInstallPluginNats(t *testing.T) {
 	request := pb.InstallPluginRequest{Plugin: plugin}
 
 	var result = pb.NetResponse{}
-	err := connection.Request(pb.InstallPluginTopic,
+	err = connection.Request(pb.InstallPluginTopic,
 		&request, &result, 10000*time.Millisecond)
 	if err != nil {
 		t.Errorf("Error should be nil : %v", err)
@@ -120,8 +139,11 @@ func TestUninstallPluginNats(t *testing.T) {
 	/*
 	 * Initializing the subscriber
 	 */
-	subscriber := registry.NewNatsSubscriber(localhostNatsServerURL)
-	defer subscriber.Shutdown()
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
 
 	/*
 	 * Establishing a connection to publish messages
@@ -147,7 +169,7 @@ func TestUninstallPluginNats(t *testing.T) {
 	/*
 	 * Uninstalling the plugin
 	 */
-	err := connection.Request(pb.UninstallPluginTopic,
+	err = connection.Request(pb.UninstallPluginTopic,
 		plugin, &result, 10000*time.Millisecond)
 	if err != nil {
 		t.Errorf("Error should be nil : %v", err)
@@ -167,3 +189,296 @@ func TestUninstallPluginNats(t *testing.T) {
 		t.Errorf("There should be no installed plugins. Got %v", plugins.Plugins)
 	}
 }
+
+/*
+ * Tests that the v2 installation topic replies with an
+ * "OperationResponse", and the v1 topic with a "NetResponse".
+ */
+func TestInstallPluginNatsV2(t *testing.T) {
+	setUp()
+
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
+	connection := registry.EstablishConnection(localhostNatsServerURL)
+
+	var response = pb.OperationResponse{}
+	err = connection.Request(pb.VersionedTopic(pb.APIVersion2, pb.InstallPluginTopic),
+		&pb.InstallRequest{Name: testPluginName}, &response, 10000*time.Millisecond)
+	if err != nil {
+		t.Errorf("Error should be nil : %v", err)
+	}
+	if !response.Status || response.Code != pb.ErrorCode_NONE || response.Name != testPluginName {
+		t.Errorf("Invalid response : %v", response)
+	}
+
+	var result = pb.NetResponse{}
+	err = connection.Request(pb.VersionedTopic(pb.APIVersion1, pb.UninstallPluginTopic),
+		&pb.Plugin{Name: testPluginName}, &result, 10000*time.Millisecond)
+	if err != nil {
+		t.Errorf("Error should be nil : %v", err)
+	}
+	if result.Response != pb.Responses_ACK {
+		t.Errorf("Invalid response status : %v", result.Response)
+	}
+}
+
+func TestAPIVersionsNats(t *testing.T) {
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
+	connection := registry.EstablishConnection(localhostNatsServerURL)
+
+	var versions = pb.APIVersions{}
+	err = connection.Request(pb.APIVersionsTopic, &pb.Empty{}, &versions, 5000*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error should be nil : %v", err)
+	}
+	if versions.Current != pb.APIVersion2 || len(versions.Versions) != 2 {
+		t.Fatalf("Invalid versions : %v", versions)
+	}
+	if !versions.Versions[0].Deprecated || versions.Versions[1].Deprecated {
+		t.Errorf("Only the v1 should be deprecated : %v", versions)
+	}
+	for _, version := range versions.Versions {
+		if !containsTopic(version.Topics, pb.VersionedTopic(version.Version, pb.InstallPluginTopic)) {
+			t.Errorf("The installation topic is missing in %s : %v", version.Version, version.Topics)
+		}
+	}
+}
+
+func containsTopic(topics []string, topic string) bool {
+	for _, candidate := range topics {
+		if candidate == topic {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Tests that the JSON topics behave as the protobuf ones : the same
+ * operations have the same effects and replies.
+ */
+func TestJSONTopicsNats(t *testing.T) {
+	setUp()
+
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
+	connection := registry.EstablishConnection(localhostNatsServerURL)
+	jsonConnection, err := nats.NewEncodedConn(connection.Conn, registry.PROTO_JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var protobufPlugins, jsonPlugins = pb.Plugins{}, pb.Plugins{}
+	connection.Request(pb.ListAvailablePluginsTopic, &pb.Empty{}, &protobufPlugins, 5000*time.Millisecond)
+	err = jsonConnection.Request(pb.JSONTopic(pb.ListAvailablePluginsTopic), &pb.Empty{}, &jsonPlugins, 5000*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error should be nil : %v", err)
+	}
+	if len(jsonPlugins.Plugins) == 0 || !reflect.DeepEqual(protobufPlugins, jsonPlugins) {
+		t.Errorf("The available plugins differ : %v, %v", protobufPlugins.Plugins, jsonPlugins.Plugins)
+	}
+
+	/*
+	 * Installing a plugin with a plain JSON request, as a shell tool would.
+	 */
+	message, err := connection.Conn.Request(pb.JSONTopic(pb.InstallPluginTopic),
+		[]byte(`{"plugin": {"name": "`+testPluginName+`"}}`), 10000*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error should be nil : %v", err)
+	}
+	if string(message.Data) != `{"response":"ACK","details":""}` {
+		t.Errorf("Invalid response : %s", message.Data)
+	}
+
+	connection.Request(pb.ListInstalledPluginsTopic, &pb.Empty{}, &protobufPlugins, 5000*time.Millisecond)
+	jsonConnection.Request(pb.JSONTopic(pb.ListInstalledPluginsTopic), &pb.Empty{}, &jsonPlugins, 5000*time.Millisecond)
+	if len(protobufPlugins.Plugins) != 1 || !reflect.DeepEqual(protobufPlugins, jsonPlugins) {
+		t.Errorf("The installed plugins differ : %v, %v", protobufPlugins.Plugins, jsonPlugins.Plugins)
+	}
+
+	/*
+	 * Both encodings report the same error for an unknown plugin.
+	 */
+	var protobufResult, jsonResult = pb.NetResponse{}, pb.NetResponse{}
+	unknownPlugin := &pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: "agilestack-unknown"}}
+	connection.Request(pb.InstallPluginTopic, unknownPlugin, &protobufResult, 10000*time.Millisecond)
+	jsonConnection.Request(pb.JSONTopic(pb.InstallPluginTopic), unknownPlugin, &jsonResult, 10000*time.Millisecond)
+	if protobufResult.Response != pb.Responses_ERROR || !reflect.DeepEqual(protobufResult, jsonResult) {
+		t.Errorf("The responses differ : %v, %v", protobufResult, jsonResult)
+	}
+
+	err = jsonConnection.Request(pb.JSONTopic(pb.VersionedTopic(pb.APIVersion2, pb.UninstallPluginTopic)),
+		&pb.UninstallRequest{Name: testPluginName}, &pb.OperationResponse{}, 10000*time.Millisecond)
+	if err != nil {
+		t.Errorf("Error should be nil : %v", err)
+	}
+}
+
+/*
+ * Tests that the requests are rejected without a token, or when the
+ * requester's role does not allow them.
+ */
+func TestAuthenticationNats(t *testing.T) {
+	setUp()
+
+	options := registry.DefaultSubscriberOptions()
+	options.Auth.HMACSecret = testSecret
+	subscriber, err := registry.NewNatsSubscriberWithOptions(localhostNatsServerURL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
+	connection := registry.EstablishConnection(localhostNatsServerURL)
+
+	var envelope = pb.ResponseEnvelope{}
+	err = connection.Request(pb.ListInstalledPluginsTopic, &pb.Empty{}, &envelope, 5000*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error should be nil : %v", err)
+	}
+	if envelope.Error == nil || envelope.Error.Code != pb.ErrorCode_UNAUTHENTICATED {
+		t.Errorf("A request without token should be rejected : %v", envelope)
+	}
+
+	clientOptions := client.DefaultOptions()
+	clientOptions.Token = newToken(t, jwt.SigningMethodHS256, testSecret, "alice", "viewer")
+	viewer, err := client.Connect(localhostNatsServerURL, clientOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer viewer.Close()
+
+	if _, err := viewer.ListInstalled(context.Background()); err != nil {
+		t.Errorf("A viewer should list the plugins : %v", err)
+	}
+	err = viewer.Install(context.Background(), testPluginName, "")
+	if authErr, ok := err.(*pb.AuthError); !ok || authErr.Code != pb.ErrorCode_PERMISSION_DENIED {
+		t.Errorf("A viewer should not install a plugin : %v", err)
+	}
+}
+
+/*
+ * Tests that the installations are recorded in the audit log, and that
+ * the log can be queried.
+ */
+func TestAuditNats(t *testing.T) {
+	setUp()
+
+	directory, err := ioutil.TempDir("", "agilestack-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	options := registry.DefaultSubscriberOptions()
+	if options.Audit.Path != "" {
+		t.Errorf("The audit log should be disabled by default : %s", options.Audit.Path)
+	}
+
+	/*
+	 * The audit log cannot be created under a file.
+	 */
+	file := filepath.Join(directory, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	options.Audit.Path = filepath.Join(file, "audit.log")
+	if _, err := registry.NewNatsSubscriberWithOptions(localhostNatsServerURL, options); err == nil {
+		t.Error("The creation of the subscriber should fail when the audit log cannot be opened")
+	}
+
+	options.Audit.Path = filepath.Join(directory, "audit.log")
+	subscriber, err := registry.NewNatsSubscriberWithOptions(localhostNatsServerURL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
+	connection := registry.EstablishConnection(localhostNatsServerURL)
+
+	var result = pb.NetResponse{}
+	request := &pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: testPluginName}}
+	if err := connection.Request(pb.InstallPluginTopic, request, &result, 10000*time.Millisecond); err != nil {
+		t.Fatalf("Error should be nil : %v", err)
+	}
+	var plugins = pb.Plugins{}
+	connection.Request(pb.ListInstalledPluginsTopic, &pb.Empty{}, &plugins, 5000*time.Millisecond)
+
+	var entries = pb.AuditEntries{}
+	err = connection.Request(pb.AuditQueryTopic, &pb.AuditQuery{Plugin: testPluginName}, &entries, 5000*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error should be nil : %v", err)
+	}
+	if entries.Error != "" || len(entries.Entries) != 1 {
+		t.Fatalf("Only the installation should be recorded : %v", entries)
+	}
+	entry := entries.Entries[0]
+	if entry.Operation != pb.InstallPluginTopic || entry.Status != (result.Response == pb.Responses_ACK) || entry.Request == "" {
+		t.Errorf("Invalid entry : %v", entry)
+	}
+}
+
+/*
+ * Tests that the requests are counted in the metrics, by topic.
+ */
+func TestMetricsNats(t *testing.T) {
+	setUp()
+
+	subscriber, err := registry.NewNatsSubscriber(localhostNatsServerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Shutdown(context.Background())
+	connection := registry.EstablishConnection(localhostNatsServerURL)
+
+	var plugins = pb.Plugins{}
+	if err := connection.Request(pb.ListInstalledPluginsTopic, &pb.Empty{}, &plugins, 5000*time.Millisecond); err != nil {
+		t.Fatalf("Error should be nil : %v", err)
+	}
+
+	/*
+	 * A request that cannot be decoded is replied to with an error.
+	 */
+	message, err := connection.Conn.Request(pb.InstallPluginTopic, []byte{0xff, 0xff}, 5000*time.Millisecond)
+	if err != nil {
+		t.Fatalf("An invalid request should be replied to : %v", err)
+	}
+	var result = pb.NetResponse{}
+	if err := connection.Enc.Decode(message.Subject, message.Data, &result); err != nil || result.Response != pb.Responses_ERROR {
+		t.Errorf("Invalid response : %v, %v", result, err)
+	}
+
+	recorder := httptest.NewRecorder()
+	registry.NewMetricsHandler(subscriber.StorageClient()).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`agilestack_core_nats_requests_total{topic="` + pb.ListInstalledPluginsTopic + `"}`,
+		`agilestack_core_nats_request_errors_total{topic="` + pb.InstallPluginTopic + `"}`,
+	} {
+		if !strings.Contains(recorder.Body.String(), line) {
+			t.Errorf("The request should be counted in %s :\n%s", line, recorder.Body.String())
+		}
+	}
+}
+
+func TestExitPolicy(t *testing.T) {
+	if policy := registry.DefaultSubscriberOptions().ExitPolicy; policy != registry.KeepPluginsOnExit {
+		t.Errorf("The plugins should be kept by default, got %v", policy)
+	}
+	for _, policy := range []registry.ExitPolicy{registry.KeepPluginsOnExit, registry.StopPluginsOnExit} {
+		parsed, err := registry.ParseExitPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Errorf("Invalid parsed policy for %v : %v, %v", policy, parsed, err)
+		}
+	}
+	if _, err := registry.ParseExitPolicy("remove"); err == nil {
+		t.Error("An unknown policy should be rejected")
+	}
+}
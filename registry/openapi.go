@@ -0,0 +1,209 @@
+package registry
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+ * Names of the values of the proto enums, used to describe them in
+ * the OpenAPI document.
+ */
+var enumNames = map[reflect.Type]map[int32]string{
+	reflect.TypeOf(pb.PluginStatus(0)):    pb.PluginStatus_name,
+	reflect.TypeOf(pb.Responses(0)):       pb.Responses_name,
+	reflect.TypeOf(pb.PluginEventType(0)): pb.PluginEventType_name,
+	reflect.TypeOf(pb.ErrorCode(0)):       pb.ErrorCode_name,
+}
+
+/*
+ * Returns the OpenAPI 3 document describing the routes of the gateway.
+ *
+ * The schemas of the bodies are generated from the types of the proto
+ * messages, so that the document follows the changes of the messages.
+ * They describe the JSON mapping of proto3 used by the gateway.
+ */
+func (gateway *RestGateway) OpenAPI() map[string]interface{} {
+	schemas := make(map[string]interface{})
+	errorResponse := map[string]interface{}{
+		"description": "The request failed",
+		"content":     jsonContent(schemaOf(reflect.TypeOf(ErrorResponse{}), schemas)),
+	}
+
+	paths := make(map[string]interface{})
+	for _, route := range gateway.routes {
+		operation := map[string]interface{}{
+			"operationId": route.operationID,
+			"summary":     route.summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(route.status): map[string]interface{}{
+					"description": http.StatusText(route.status),
+					"content":     jsonContent(schemaOf(reflect.TypeOf(route.response), schemas)),
+				},
+				"default": errorResponse,
+			},
+		}
+		if parameters := pathParameters(route.path); len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if route.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": !route.optionalBody,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(route.request), schemas)),
+			}
+		}
+
+		item, ok := paths[route.path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[route.path] = item
+		}
+		item[strings.ToLower(route.method)] = operation
+	}
+
+	document := map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "AgileStack core",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+	if gateway.verifier != nil {
+		document["components"].(map[string]interface{})["securitySchemes"] = map[string]interface{}{
+			"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		}
+		document["security"] = []interface{}{map[string]interface{}{"bearer": []string{}}}
+	}
+	return document
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+/*
+ * Returns the parameters of the given route's path.
+ */
+func pathParameters(path string) []interface{} {
+	var parameters []interface{}
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return parameters
+}
+
+/*
+ * Returns the schema of the JSON encoding of the given type : the JSON
+ * mapping of proto3 for the proto messages, where the enums are given by
+ * their name and the 64-bit integers are strings.
+ *
+ * The structures are added to the given schemas, and referenced.
+ */
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if names, ok := enumNames[t]; ok {
+		return enumSchema(names)
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int32, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "string", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		reference := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return reference
+		}
+
+		properties := make(map[string]interface{})
+		schemas[t.Name()] = map[string]interface{}{"type": "object", "properties": properties}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := fieldName(field)
+			if field.PkgPath != "" || name == "-" {
+				continue
+			}
+			properties[name] = schemaOf(field.Type, schemas)
+		}
+		return reference
+	}
+	return map[string]interface{}{}
+}
+
+/*
+ * Returns the name of the given field in the JSON encoding : the name
+ * given by jsonpb for the fields of the proto messages, the name of the
+ * "json" tag otherwise.
+ */
+func fieldName(field reflect.StructField) string {
+	if tag := field.Tag.Get("protobuf"); tag != "" {
+		var properties proto.Properties
+		properties.Parse(tag)
+		if properties.JSONName != "" {
+			return properties.JSONName
+		}
+		return properties.OrigName
+	}
+
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+/*
+ * Returns the schema of a proto enum, encoded by the name of its value.
+ */
+func enumSchema(names map[int32]string) map[string]interface{} {
+	values := make([]int, 0, len(names))
+	for value := range names {
+		values = append(values, int(value))
+	}
+	sort.Ints(values)
+
+	enum := make([]string, len(values))
+	for i, value := range values {
+		enum[i] = names[int32(value)]
+	}
+	return map[string]interface{}{
+		"type": "string",
+		"enum": enum,
+	}
+}
@@ -1,78 +1,477 @@
 package registry
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	pb "github.com/eogile/agilestack-core/proto"
-	"encoding/json"
+	"github.com/eogile/agilestack-core/registry/config"
+	"github.com/eogile/agilestack-core/registry/source"
+	"github.com/eogile/agilestack-core/registry/templates"
 	"github.com/fsouza/go-dockerclient"
-	"log"
 )
 
 const (
 	PLUGIN_TEMPLATE_DIR = "/plugin-template"
+
+	/*
+	 * Directory under which the directories given in the requests
+	 * must be.
+	 */
+	PLUGIN_BUILD_ROOT = "/files"
+
+	/*
+	 * Directory where the workspaces of the plugins being created
+	 * are rendered.
+	 */
+	PLUGIN_WORKSPACE_DIR = "/plugin-workspaces"
+
+	/*
+	 * Directory where the uploaded archives are stored, and their
+	 * maximum size.
+	 */
+	PLUGIN_ARCHIVE_DIR      = "/plugin-archives"
+	PLUGIN_ARCHIVE_MAX_SIZE = 512 * 1024 * 1024
+
+	/*
+	 * Duration after which an upload without new chunk is abandoned,
+	 * its partial archive being removed.
+	 */
+	PLUGIN_ARCHIVE_UPLOAD_TIMEOUT = time.Hour
+
+	/*
+	 * Templates shipped in the template directory, from the
+	 * "plugin-template" directory of the sources.
+	 *
+	 * Other templates can be added : every sub-directory of the
+	 * template directory containing a "template.json" descriptor
+	 * is a template.
+	 */
+	GO_SERVICE_TEMPLATE  = "go-service"
+	JS_FRONTEND_TEMPLATE = "js-frontend"
+	STATIC_SITE_TEMPLATE = "static-site"
+
+	DEFAULT_PLUGIN_TEMPLATE = JS_FRONTEND_TEMPLATE
 )
 
 type (
 	pluginFactory interface {
-		CreatePlugin(request *pb.NewPluginRequest) error
+		/*
+		 * Creates the plugin's image, writing the build output to
+		 * the given writer.
+		 */
+		CreatePlugin(ctx context.Context, request *pb.NewPluginRequest, output io.Writer) error
+
+		/*
+		 * Returns the templates from which plugins can be created.
+		 */
+		ListTemplates() (*pb.PluginTemplates, error)
+
+		/*
+		 * Stores a chunk of an archive from which plugins can be created.
+		 */
+		AppendArchiveChunk(chunk *pb.ArchiveChunk) error
+
+		/*
+		 * Pushes the given tag of the plugin's image to the registry,
+		 * writing the push progress to the given writer.
+		 *
+		 * Returns the reference of the image in the registry and
+		 * its digest.
+		 */
+		PushPlugin(ctx context.Context, name string, tag string, output io.Writer) (string, string, error)
 	}
 
 	dockerPluginFactory struct {
 		/*
 		 * The client to access the location where the plugins are installed.
+		 * It is the registry's client, so that the images built and pushed
+		 * are refreshed in the cache the registry lists the plugins from.
 		 */
 		dockerClient *DockerStorageClient
-	}
 
-	configuration struct {
-		Url  string `json:"url"`
-		Name string `json:"name"`
+		/*
+		 * Templates available in the template directory.
+		 */
+		templates *templates.Registry
+
+		/*
+		 * Directory where the workspaces are created.
+		 */
+		workspaceDir string
+
+		/*
+		 * Directory under which the requests' directories must be.
+		 */
+		buildRoot string
+
+		/*
+		 * Hosts from which the remote build contexts may be downloaded.
+		 */
+		remoteContextHosts []string
+
+		/*
+		 * Registry where the images are pushed.
+		 */
+		registry RegistryOptions
+
+		/*
+		 * Uploaded archives.
+		 */
+		archives *source.ArchiveStore
 	}
 )
 
-func NewPluginFactory() pluginFactory {
+/*
+ * Creates a factory building the plugins with the given storage client,
+ * which must be the one of the registry : a second client would watch
+ * the Docker events and keep a cache of its own.
+ */
+func NewPluginFactory(dockerClient *DockerStorageClient, buildRoot string, remoteContextHosts []string,
+	registry RegistryOptions) pluginFactory {
 	return &dockerPluginFactory{
-		dockerClient: NewDockerStorageClient(),
+		dockerClient:       dockerClient,
+		templates:          templates.NewRegistry(PLUGIN_TEMPLATE_DIR),
+		workspaceDir:       PLUGIN_WORKSPACE_DIR,
+		buildRoot:          buildRoot,
+		remoteContextHosts: remoteContextHosts,
+		registry:           registry,
+		archives:           source.NewArchiveStore(PLUGIN_ARCHIVE_DIR, PLUGIN_ARCHIVE_MAX_SIZE, PLUGIN_ARCHIVE_UPLOAD_TIMEOUT),
 	}
 }
 
 /*
- * 1 - Go to the template directory
- * 2 - Compile the JavaScript code
- * 3 - Create the Docker image
+ * 1 - Prepare the sources of the plugin in a workspace
+ * 2 - Write the configuration file into the workspace
+ * 3 - Create the Docker image from the workspace, the configuration
+ *     being also saved as a label of the image
+ * 4 - Give the extra tags to the image
+ *
+ * Every image is labelled with the OCI annotations describing its origin.
+ *
+ * The sources come from, by order of precedence :
+ * - The request's directory, which must be inside the build root.
+ * - A clone of the request's git repository.
+ * - The selected template, rendered into a new workspace.
+ *
+ * When the request gives an uploaded archive or a remote build context,
+ * the image is built directly from it : the configuration file must be
+ * part of the sources.
  */
-func (factory dockerPluginFactory) CreatePlugin(request *pb.NewPluginRequest) error {
+func (factory dockerPluginFactory) CreatePlugin(ctx context.Context, request *pb.NewPluginRequest, output io.Writer) error {
+	if errs := ValidateNewPluginRequest(request, factory.buildRoot, factory.remoteContextHosts); len(errs) > 0 {
+		return errs
+	}
+	imageName := PLUGIN_IMAGE_PREFIX + request.Name
+
+	configuration := config.New(request.Name, request.Url, request.Configuration)
+	label, err := json.Marshal(configuration)
+	if err != nil {
+		return err
+	}
+
+	origin := ImageOrigin{
+		Name:    request.Name,
+		Tag:     request.Tag,
+		Created: time.Now(),
+	}
+	if origin.Tag == "" {
+		origin.Tag = DEFAULT_IMAGE_TAG
+	}
+
+	options := docker.BuildImageOptions{
+		Name:         imageName + ":" + origin.Tag,
+		OutputStream: output,
+		Context:      ctx,
+		NoCache:      request.NoCache,
+		Pull:         request.Pull,
+		Target:       request.Target,
+	}
+	for name, value := range request.BuildArgs {
+		options.BuildArgs = append(options.BuildArgs, docker.BuildArg{Name: name, Value: value})
+	}
+
+	switch {
+	case request.ArchiveId != "":
+		archive, err := factory.archives.Open(request.ArchiveId)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		defer factory.archives.Remove(request.ArchiveId)
+		options.InputStream = archive
+
+	case request.RemoteContext != "":
+		options.Remote = request.RemoteContext
+		origin.Source = request.RemoteContext
+
+	default:
+		workspace, temporary, err := factory.prepareWorkspace(ctx, request, imageName)
+		if err != nil {
+			return err
+		}
+		if temporary {
+			defer os.RemoveAll(workspace)
+		}
+
+		switch {
+		case request.GitUrl != "":
+			origin.Source = request.GitUrl
+			origin.Revision, _ = source.GitRevision(ctx, workspace)
+		case request.Directory != "":
+			origin.Source = "file://" + workspace
+			origin.Revision, _ = source.GitRevision(ctx, workspace)
+		}
+
+		/*
+		 * Creating the configuration file.
+		 */
+		err = factory.createConfigurationFile(workspace, configuration)
+		if err != nil {
+			return err
+		}
+		options.ContextDir = workspace
+	}
+
+	options.Labels = origin.Labels()
+	options.Labels[config.Label] = string(label)
+
 	/*
-	 * Creating the configuration file.
+	 * Building the Docker image.
 	 */
-	err := factory.createConfigurationFile(request)
+	start := time.Now()
+	err = factory.dockerClient.docker.BuildImage(options)
+	observeImageBuild(start, err)
 	if err != nil {
 		return err
 	}
 
+	for _, tag := range request.ExtraTags {
+		start := time.Now()
+		err := factory.dockerClient.docker.TagImage(options.Name, docker.TagImageOptions{
+			Repo:    imageName,
+			Tag:     tag,
+			Force:   true,
+			Context: ctx,
+		})
+		observeDockerCall("TagImage", start, err)
+		if err != nil {
+			log.Printf("Error while tagging the image %s with %s : %v", options.Name, tag, err)
+			return err
+		}
+	}
+
 	/*
-	 * Building the Docker image.
+	 * Making the new image available without waiting for the Docker events.
 	 */
-	options := docker.BuildImageOptions{
-		Name:         "agilestack-" + request.Name,
-		ContextDir:   request.Directory,
-		OutputStream: os.Stdout,
+	return factory.dockerClient.helper.Cache().RefreshImages()
+}
+
+/*
+ * Checks that the request gives at most one source for the plugin.
+ */
+func checkSingleSource(request *pb.NewPluginRequest) error {
+	count := 0
+	for _, value := range []string{request.Directory, request.GitUrl, request.ArchiveId, request.RemoteContext} {
+		if value != "" {
+			count++
+		}
+	}
+	if count > 1 {
+		return errors.New("Only one of directory, git repository, archive and remote context can be given")
 	}
-	return factory.dockerClient.docker.BuildImage(options)
+	return nil
 }
 
-func (factory dockerPluginFactory) createConfigurationFile(request *pb.NewPluginRequest) error {
-	file, err := os.Create(request.Directory + "/config.json")
+/*
+ * Returns the directory containing the sources of the plugin, and whether
+ * or not it is a temporary workspace to remove after the build.
+ */
+func (factory dockerPluginFactory) prepareWorkspace(ctx context.Context, request *pb.NewPluginRequest, imageName string) (string, bool, error) {
+	if request.Directory != "" {
+		directory, err := ConfineDirectory(factory.buildRoot, request.Directory)
+		return directory, false, err
+	}
+	if request.GitUrl == "" {
+		workspace, err := factory.scaffold(request, imageName)
+		return workspace, true, err
+	}
+
+	workspace, err := factory.newWorkspace(imageName)
 	if err != nil {
-		log.Println("Error while creating configuration file", err)
-		return err
+		return "", false, err
+	}
+	log.Printf("Cloning %s (%s) into %s", request.GitUrl, request.GitRef, workspace)
+
+	if err := source.FetchGit(ctx, request.GitUrl, request.GitRef, workspace); err != nil {
+		log.Println("Error while cloning the repository", err)
+		os.RemoveAll(workspace)
+		return "", false, err
+	}
+	return workspace, true, nil
+}
+
+/*
+ * Creates a new empty workspace.
+ */
+func (factory dockerPluginFactory) newWorkspace(imageName string) (string, error) {
+	if err := os.MkdirAll(factory.workspaceDir, 0755); err != nil {
+		log.Println("Error while creating the workspaces directory", err)
+		return "", err
+	}
+	workspace, err := ioutil.TempDir(factory.workspaceDir, imageName+"-")
+	if err != nil {
+		log.Println("Error while creating the workspace", err)
+		return "", err
+	}
+	return workspace, nil
+}
+
+/*
+ * 1 - Tag the local image with the name of its repository in the registry
+ * 2 - Push the image with the registry's credentials
+ */
+func (factory dockerPluginFactory) PushPlugin(ctx context.Context, name string, tag string, output io.Writer) (string, string, error) {
+	if !factory.registry.Enabled() {
+		return "", "", errors.New("No registry is configured")
+	}
+	if tag == "" {
+		tag = DEFAULT_IMAGE_TAG
+	}
+	if err := ValidatePluginName(name); err != nil {
+		return "", "", err
+	}
+	if err := ValidateImageTag(tag); err != nil {
+		return "", "", err
+	}
+
+	imageName := PLUGIN_IMAGE_PREFIX + name
+	repository := factory.registry.Repository(imageName)
+	reference := repository + ":" + tag
+
+	start := time.Now()
+	err := factory.dockerClient.docker.TagImage(imageName+":"+tag, docker.TagImageOptions{
+		Repo:    repository,
+		Tag:     tag,
+		Force:   true,
+		Context: ctx,
+	})
+	observeDockerCall("TagImage", start, err)
+	if err != nil {
+		log.Printf("Error while tagging the image %s:%s : %v", imageName, tag, err)
+		return "", "", err
+	}
+
+	log.Printf("Pushing %s", reference)
+	progress := NewPushProgressWriter(output)
+	start = time.Now()
+	err = factory.dockerClient.docker.PushImage(docker.PushImageOptions{
+		Name:          repository,
+		Tag:           tag,
+		Registry:      factory.registry.Address,
+		OutputStream:  progress,
+		RawJSONStream: true,
+		Context:       ctx,
+	}, docker.AuthConfiguration{
+		Username:      factory.registry.Username,
+		Password:      factory.registry.Password,
+		Email:         factory.registry.Email,
+		ServerAddress: factory.registry.Address,
+	})
+	progress.Close()
+	if err == nil {
+		err = progress.Err()
+	}
+	observeDockerCall("PushImage", start, err)
+	if err != nil {
+		log.Printf("Error while pushing %s : %v", reference, err)
+		return "", "", err
+	}
+	return reference, progress.Digest(), nil
+}
+
+func (factory dockerPluginFactory) AppendArchiveChunk(chunk *pb.ArchiveChunk) error {
+	return factory.archives.Append(chunk)
+}
+
+/*
+ * Renders the template selected by the request into a new workspace.
+ *
+ * Returns the path of the workspace.
+ */
+func (factory dockerPluginFactory) scaffold(request *pb.NewPluginRequest, imageName string) (string, error) {
+	templateName := request.Template
+	if templateName == "" {
+		templateName = DEFAULT_PLUGIN_TEMPLATE
+	}
+	template, err := factory.templates.Get(templateName)
+	if err != nil {
+		log.Println("Error while loading the template", err)
+		return "", err
+	}
+
+	data := templates.NewData(request.Name, imageName, request.Url)
+	data.Parameters, err = template.ResolveParameters(request.Parameters)
+	if err != nil {
+		log.Println("Invalid template parameters", err)
+		return "", err
+	}
+
+	workspace, err := factory.newWorkspace(imageName)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Rendering template %s into %s", template.Name, workspace)
+	if err := template.Render(workspace, data); err != nil {
+		log.Println("Error while rendering the template", err)
+		os.RemoveAll(workspace)
+		return "", err
+	}
+	return workspace, nil
+}
+
+func (factory dockerPluginFactory) ListTemplates() (*pb.PluginTemplates, error) {
+	list, err := factory.templates.List()
+	if err != nil {
+		log.Println("Error while listing the templates", err)
+		return nil, err
 	}
-	defer file.Close()
 
-	config := &configuration{
-		Name: request.Name,
-		Url:  request.Url,
+	response := &pb.PluginTemplates{Templates: make([]*pb.PluginTemplate, 0, len(list))}
+	for _, template := range list {
+		item := &pb.PluginTemplate{
+			Name:        template.Name,
+			Description: template.Description,
+		}
+		for _, parameter := range template.Parameters {
+			item.Parameters = append(item.Parameters, &pb.TemplateParameter{
+				Name:         parameter.Name,
+				Description:  parameter.Description,
+				DefaultValue: parameter.Default,
+				Required:     parameter.Required,
+			})
+		}
+		response.Templates = append(response.Templates, item)
+	}
+	return response, nil
+}
+
+func (factory dockerPluginFactory) createConfigurationFile(directory string, configuration *pb.PluginConfiguration) error {
+	content, err := config.Marshal(configuration)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(directory, config.FileName), content, 0644)
+	if err != nil {
+		log.Println("Error while creating configuration file", err)
 	}
-	return json.NewEncoder(file).Encode(config)
+	return err
 }
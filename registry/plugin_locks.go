@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+ * Behaviour of the registry when an operation targets a plugin on which
+ * another operation is in progress.
+ */
+type ConcurrencyPolicy int
+
+const (
+	/*
+	 * The operation waits for the operations in progress to complete.
+	 */
+	QueueConcurrentOperations ConcurrencyPolicy = iota
+
+	/*
+	 * The operation fails immediately with "ErrPluginBusy".
+	 */
+	RejectConcurrentOperations
+)
+
+/*
+ * Error returned when an operation is rejected because another
+ * operation is in progress on the same plugin.
+ */
+var ErrPluginBusy = errors.New("Another operation is in progress on this plugin")
+
+/*
+ * Set of locks serializing the operations per plugin.
+ *
+ * A lock only exists while at least one operation holds it or
+ * waits for it.
+ *
+ * The locks are held in the memory of the core instance : when several
+ * instances are running, the registry only changes the plugins on the
+ * leader (see "ErrNotLeader").
+ */
+type pluginLocks struct {
+	policy ConcurrencyPolicy
+
+	mutex sync.Mutex
+	locks map[string]*pluginLock
+}
+
+type pluginLock struct {
+	/*
+	 * Buffered channel of size 1 : the lock is held when it contains
+	 * a value.
+	 */
+	token chan struct{}
+
+	/*
+	 * Number of operations holding or waiting for the lock.
+	 */
+	references int
+}
+
+func newPluginLocks(policy ConcurrencyPolicy) *pluginLocks {
+	return &pluginLocks{
+		policy: policy,
+		locks:  make(map[string]*pluginLock),
+	}
+}
+
+/*
+ * Acquires the lock of the given plugin.
+ *
+ * The returned function releases the lock and must be called exactly once
+ * when the error is "nil".
+ *
+ * When the operations are queued, the wait is interrupted by the
+ * cancellation of the given context.
+ */
+func (locks *pluginLocks) acquire(ctx context.Context, pluginName string) (func(), error) {
+	lock := locks.reference(pluginName)
+
+	if locks.policy == RejectConcurrentOperations {
+		select {
+		case lock.token <- struct{}{}:
+		default:
+			locks.dereference(pluginName)
+			return nil, ErrPluginBusy
+		}
+	} else {
+		select {
+		case lock.token <- struct{}{}:
+		case <-ctx.Done():
+			locks.dereference(pluginName)
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		<-lock.token
+		locks.dereference(pluginName)
+	}, nil
+}
+
+func (locks *pluginLocks) reference(pluginName string) *pluginLock {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+
+	lock, ok := locks.locks[pluginName]
+	if !ok {
+		lock = &pluginLock{token: make(chan struct{}, 1)}
+		locks.locks[pluginName] = lock
+	}
+	lock.references++
+	return lock
+}
+
+func (locks *pluginLocks) dereference(pluginName string) {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+
+	lock := locks.locks[pluginName]
+	lock.references--
+	if lock.references == 0 {
+		delete(locks.locks, pluginName)
+	}
+}
@@ -0,0 +1,344 @@
+package registry_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+	"github.com/eogile/agilestack-core/registry/storage"
+)
+
+/*
+ * Storage client keeping the plugins in memory and recording the
+ * maximum number of operations executed at the same time per plugin.
+ *
+ * If "blocker" is not nil, the installations wait until it is closed.
+ */
+type fakeStorageClient struct {
+	mutex         sync.Mutex
+	installed     map[string]bool
+	running       map[string]int
+	maxRunning    map[string]int
+	installations int
+	deleted       []string
+
+	/*
+	 * Names of the images listed as installable.
+	 */
+	images []string
+
+	blocker chan struct{}
+}
+
+func newFakeStorageClient() *fakeStorageClient {
+	return &fakeStorageClient{
+		installed:  make(map[string]bool),
+		running:    make(map[string]int),
+		maxRunning: make(map[string]int),
+	}
+}
+
+func (client *fakeStorageClient) ListInstallablePlugins(ctx context.Context) (*pb.Plugins, error) {
+	plugins := &pb.Plugins{}
+	for _, name := range client.images {
+		plugins.Plugins = append(plugins.Plugins, &pb.Plugin{Name: name})
+	}
+	return plugins, nil
+}
+
+func (client *fakeStorageClient) ListInstalledPlugins(ctx context.Context) (*pb.Plugins, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	plugins := &pb.Plugins{}
+	for name := range client.installed {
+		plugins.Plugins = append(plugins.Plugins, &pb.Plugin{Name: name, PluginStatus: pb.PluginStatus_OK})
+	}
+	return plugins, nil
+}
+
+func (client *fakeStorageClient) InstallPlugin(ctx context.Context, name string, cmd string) error {
+	client.begin(name)
+	defer client.end(name)
+
+	if client.blocker != nil {
+		<-client.blocker
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.installed[name] {
+		panic("The plugin " + name + " is installed twice")
+	}
+	client.installed[name] = true
+	client.installations++
+	return nil
+}
+
+func (client *fakeStorageClient) UninstallPlugin(ctx context.Context, name string) error {
+	client.begin(name)
+	defer client.end(name)
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	delete(client.installed, name)
+	return nil
+}
+
+func (client *fakeStorageClient) IsPluginInstalled(ctx context.Context, name string) (bool, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.installed[name], nil
+}
+
+func (client *fakeStorageClient) GetPluginConfiguration(ctx context.Context, name string) (*pb.PluginConfiguration, error) {
+	return &pb.PluginConfiguration{Name: name}, nil
+}
+
+func (client *fakeStorageClient) DeletePluginImages(ctx context.Context, name string, oldVersionsOnly bool) (*pb.DeletePluginResponse, error) {
+	client.begin(name)
+	defer client.end(name)
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.installed[name] && !oldVersionsOnly {
+		panic("The images of the installed plugin " + name + " are deleted")
+	}
+	client.deleted = append(client.deleted, name)
+	return &pb.DeletePluginResponse{RemovedImages: []string{name + ":latest"}}, nil
+}
+
+func (client *fakeStorageClient) CollectGarbage(ctx context.Context, policy storage.GCPolicy, dryRun bool) (*pb.GarbageCollectionReport, error) {
+	return &pb.GarbageCollectionReport{DryRun: dryRun}, nil
+}
+
+func (client *fakeStorageClient) begin(name string) {
+	client.mutex.Lock()
+	client.running[name]++
+	if client.running[name] > client.maxRunning[name] {
+		client.maxRunning[name] = client.running[name]
+	}
+	client.mutex.Unlock()
+
+	/*
+	 * Giving a chance to the other operations to overlap.
+	 */
+	time.Sleep(time.Millisecond)
+}
+
+func (client *fakeStorageClient) end(name string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.running[name]--
+}
+
+/*
+ * Tests that concurrent installations and un-installations of the same
+ * plugin are executed one after the other.
+ */
+func TestConcurrentOperationsAreSerialized(t *testing.T) {
+	client := newFakeStorageClient()
+	lockedRegistry := registry.NewInMemoryRegistry(client)
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		waitGroup.Add(2)
+		go func() {
+			defer waitGroup.Done()
+			request := pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: testPluginName}}
+			if _, err := lockedRegistry.InstallPlugin(context.Background(), request); err != nil {
+				t.Errorf("Error during plugin installation : %v", err)
+			}
+		}()
+		go func() {
+			defer waitGroup.Done()
+			if _, err := lockedRegistry.UninstallPlugin(context.Background(), pb.Plugin{Name: testPluginName}); err != nil {
+				t.Errorf("Error during plugin un-installation : %v", err)
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	if client.maxRunning[testPluginName] != 1 {
+		t.Errorf("Operations were executed concurrently : %d", client.maxRunning[testPluginName])
+	}
+	if client.installations != 20 {
+		t.Errorf("Invalid number of installations : %d", client.installations)
+	}
+}
+
+/*
+ * Tests that operations on different plugins are not serialized.
+ */
+func TestOperationsOnDifferentPluginsAreConcurrent(t *testing.T) {
+	client := newFakeStorageClient()
+	client.blocker = make(chan struct{})
+	lockedRegistry := registry.NewInMemoryRegistry(client)
+
+	done := make(chan error, 2)
+	for _, name := range []string{"agilestack-first", "agilestack-second"} {
+		request := pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: name}}
+		go func() {
+			_, err := lockedRegistry.InstallPlugin(context.Background(), request)
+			done <- err
+		}()
+	}
+
+	/*
+	 * Both installations must be in progress at the same time.
+	 */
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.mutex.Lock()
+		running := client.running["agilestack-first"] + client.running["agilestack-second"]
+		client.mutex.Unlock()
+		if running == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The installations of different plugins should run concurrently")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(client.blocker)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Error during plugin installation : %v", err)
+		}
+	}
+}
+
+/*
+ * Tests that, with the "reject" policy, an operation fails while another
+ * one is in progress on the same plugin.
+ */
+func TestConcurrentOperationsAreRejected(t *testing.T) {
+	client := newFakeStorageClient()
+	client.blocker = make(chan struct{})
+	lockedRegistry := registry.NewInMemoryRegistryWithPolicy(client, registry.RejectConcurrentOperations)
+
+	request := pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: testPluginName}}
+	done := make(chan error, 1)
+	go func() {
+		_, err := lockedRegistry.InstallPlugin(context.Background(), request)
+		done <- err
+	}()
+
+	/*
+	 * Waiting for the first installation to hold the lock.
+	 */
+	waitForOperation(t, client, testPluginName)
+
+	if _, err := lockedRegistry.UninstallPlugin(context.Background(), pb.Plugin{Name: testPluginName}); err != registry.ErrPluginBusy {
+		t.Errorf("Expected ErrPluginBusy, got %v", err)
+	}
+
+	close(client.blocker)
+	if err := <-done; err != nil {
+		t.Errorf("Error during plugin installation : %v", err)
+	}
+
+	/*
+	 * The lock is released once the installation is over.
+	 */
+	if _, err := lockedRegistry.UninstallPlugin(context.Background(), pb.Plugin{Name: testPluginName}); err != nil {
+		t.Errorf("Error during plugin un-installation : %v", err)
+	}
+}
+
+/*
+ * Tests that an operation waiting for the plugin's lock is aborted when
+ * its context is cancelled.
+ */
+func TestQueuedOperationIsCancelled(t *testing.T) {
+	client := newFakeStorageClient()
+	client.blocker = make(chan struct{})
+	defer close(client.blocker)
+	lockedRegistry := registry.NewInMemoryRegistry(client)
+
+	request := pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: testPluginName}}
+	go lockedRegistry.InstallPlugin(context.Background(), request)
+	waitForOperation(t, client, testPluginName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := lockedRegistry.UninstallPlugin(ctx, pb.Plugin{Name: testPluginName})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+/*
+ * Election whose result is set by the test.
+ */
+type fakeElector struct {
+	leader bool
+}
+
+func (elector *fakeElector) IsLeader() bool {
+	return elector.leader
+}
+
+func (elector *fakeElector) LeaderElsewhere() bool {
+	return false
+}
+
+/*
+ * Tests that the plugins are only changed on the leader, the locks being
+ * held in the memory of the instance.
+ */
+func TestOperationsRequireLeadership(t *testing.T) {
+	client := newFakeStorageClient()
+	elector := &fakeElector{}
+	lockedRegistry := registry.NewInMemoryRegistry(client)
+	lockedRegistry.SetLeaderElector(elector)
+
+	request := pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: testPluginName}}
+	if _, err := lockedRegistry.InstallPlugin(context.Background(), request); err != registry.ErrNotLeader {
+		t.Errorf("Expected ErrNotLeader, got %v", err)
+	}
+	if _, err := lockedRegistry.UninstallPlugin(context.Background(), pb.Plugin{Name: testPluginName}); err != registry.ErrNotLeader {
+		t.Errorf("Expected ErrNotLeader, got %v", err)
+	}
+	if _, err := lockedRegistry.DeletePlugin(context.Background(), pb.DeletePluginRequest{Name: testPluginName}); err != registry.ErrNotLeader {
+		t.Errorf("Expected ErrNotLeader, got %v", err)
+	}
+	if _, err := lockedRegistry.CollectGarbage(context.Background(), storage.DefaultGCPolicy(), false); err != registry.ErrNotLeader {
+		t.Errorf("Expected ErrNotLeader, got %v", err)
+	}
+	if _, err := lockedRegistry.CollectGarbage(context.Background(), storage.DefaultGCPolicy(), true); err != nil {
+		t.Errorf("The dry runs should be allowed on a follower : %v", err)
+	}
+	if client.installations != 0 || len(client.deleted) != 0 {
+		t.Error("No plugin should be changed on a follower")
+	}
+
+	elector.leader = true
+	if _, err := lockedRegistry.InstallPlugin(context.Background(), request); err != nil {
+		t.Errorf("Error during plugin installation : %v", err)
+	}
+}
+
+/*
+ * Waits until an operation on the given plugin is running, and thus
+ * holds the plugin's lock.
+ */
+func waitForOperation(t *testing.T, client *fakeStorageClient, name string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.mutex.Lock()
+		running := client.running[name]
+		client.mutex.Unlock()
+		if running == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The operation did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
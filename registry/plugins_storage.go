@@ -1,25 +1,28 @@
 package registry
 
 import (
+	"context"
 	"log"
 	"strings"
+	"time"
 
 	"errors"
 
 	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry/config"
 	"github.com/eogile/agilestack-core/registry/storage"
 	"github.com/eogile/agilestack-utils/dockerclient"
 	"github.com/fsouza/go-dockerclient"
 )
 
 type PluginStorageClient interface {
-	ListInstallablePlugins() (*pb.Plugins, error)
+	ListInstallablePlugins(ctx context.Context) (*pb.Plugins, error)
 
-	ListInstalledPlugins() (*pb.Plugins, error)
+	ListInstalledPlugins(ctx context.Context) (*pb.Plugins, error)
 
-	InstallPlugin(imageName string, cmd string) error
+	InstallPlugin(ctx context.Context, imageName string, cmd string) error
 
-	UninstallPlugin(imageName string) error
+	UninstallPlugin(ctx context.Context, imageName string) error
 
 	/*
 	 * Returns a boolean indicating whether or not the given
@@ -31,7 +34,27 @@ type PluginStorageClient interface {
 	 * If the returned error is not "nil", then the boolean
 	 * value is irrelevant.
 	 */
-	IsPluginInstalled(name string) (bool, error)
+	IsPluginInstalled(ctx context.Context, name string) (bool, error)
+
+	/*
+	 * Returns the configuration of the given installed plugin.
+	 */
+	GetPluginConfiguration(ctx context.Context, name string) (*pb.PluginConfiguration, error)
+
+	/*
+	 * Removes the images of the given plugin, or only the ones of its
+	 * previous versions, then the dangling layers.
+	 *
+	 * The response lists the removed images.
+	 */
+	DeletePluginImages(ctx context.Context, name string, oldVersionsOnly bool) (*pb.DeletePluginResponse, error)
+
+	/*
+	 * Removes the stale containers and images according to the given
+	 * policy. If "dryRun" is true, nothing is removed : the report lists
+	 * what would be removed.
+	 */
+	CollectGarbage(ctx context.Context, policy storage.GCPolicy, dryRun bool) (*pb.GarbageCollectionReport, error)
 }
 
 type DockerStorageClient struct {
@@ -48,11 +71,19 @@ func NewDockerStorageClient() *DockerStorageClient {
 	}
 }
 
-func (dockerWrapper *DockerStorageClient) ListInstallablePlugins() (*pb.Plugins, error) {
+/*
+ * Stops the cache of the Docker images and containers, which no longer
+ * follows the Docker events. Can be called more than once.
+ */
+func (dockerWrapper *DockerStorageClient) Close() {
+	dockerWrapper.helper.Cache().Stop()
+}
+
+func (dockerWrapper *DockerStorageClient) ListInstallablePlugins(ctx context.Context) (*pb.Plugins, error) {
 	/*
 	 * Listing containers
 	 */
-	containers, err := dockerWrapper.listRunningContainers()
+	containers, err := dockerWrapper.listRunningContainers(ctx)
 	if err != nil {
 		log.Printf("Error when listing running Docker containers : %v", err)
 		return nil, err
@@ -61,7 +92,7 @@ func (dockerWrapper *DockerStorageClient) ListInstallablePlugins() (*pb.Plugins,
 	/*
 	 * Listing images
 	 */
-	images, imageErr := dockerWrapper.helper.ListImages()
+	images, imageErr := dockerWrapper.helper.ListImages(ctx)
 	if imageErr != nil {
 		log.Printf("Error when listing Docker images : %v", imageErr)
 		return nil, imageErr
@@ -86,11 +117,11 @@ func (dockerWrapper *DockerStorageClient) ListInstallablePlugins() (*pb.Plugins,
 	return plugins, nil
 }
 
-func (dockerWrapper *DockerStorageClient) ListInstalledPlugins() (*pb.Plugins, error) {
+func (dockerWrapper *DockerStorageClient) ListInstalledPlugins(ctx context.Context) (*pb.Plugins, error) {
 	/*
 	 * Listing containers
 	 */
-	containers, err := dockerWrapper.listRunningContainers()
+	containers, err := dockerWrapper.listRunningContainers(ctx)
 	if err != nil {
 		log.Printf("Error when listing running Docker containers : %v", err)
 		return nil, err
@@ -98,13 +129,13 @@ func (dockerWrapper *DockerStorageClient) ListInstalledPlugins() (*pb.Plugins, e
 	return storage.TransformContainers(containers), nil
 }
 
-func (dockerWrapper *DockerStorageClient) InstallPlugin(pluginName string, cmd string) error {
+func (dockerWrapper *DockerStorageClient) InstallPlugin(ctx context.Context, pluginName string, cmd string) error {
 	log.Printf("Creating container for plugin %s", pluginName)
 
 	/*
 	 * Finding the Docker image matching the plugin's name
 	 */
-	image := dockerWrapper.helper.ImageFromPlugin(pluginName)
+	image := dockerWrapper.helper.ImageFromPlugin(ctx, pluginName)
 	if image == nil {
 		msg := "Unknown plugin : " + pluginName
 		log.Printf(msg)
@@ -135,37 +166,59 @@ func (dockerWrapper *DockerStorageClient) InstallPlugin(pluginName string, cmd s
 		Name:       pluginName,
 		Config:     &containerConfig,
 		HostConfig: &hostConfig,
+		Context:    ctx,
 	}
 
+	/*
+	 * The cache is refreshed right away so that the new container is
+	 * listed without waiting for the Docker events.
+	 */
+	defer dockerWrapper.helper.Cache().RefreshContainers()
+
+	start := time.Now()
 	container, err := dockerWrapper.docker.CreateContainer(containerOptions)
+	observeDockerCall("CreateContainer", start, err)
 	if err != nil {
 		log.Printf("Error on createContainer : %v", err)
 		return err
 	}
 	log.Printf("container created with ID: %s ", container.ID)
 
-	err = dockerWrapper.docker.StartContainer(container.ID, nil)
+	start = time.Now()
+	err = dockerWrapper.docker.StartContainerWithContext(container.ID, nil, ctx)
+	observeDockerCall("StartContainer", start, err)
 	if err != nil {
 		log.Printf("Error on startContainer : %v", err)
 		return err
 	}
+
+	/*
+	 * Without stream, the attachment returns as soon as the container
+	 * is attached : it does not need to be cancelled.
+	 */
 	attachContainerOptions := docker.AttachToContainerOptions{
-		Container: image.RepoTags[0],
+		Container: container.ID,
 	}
-	dockerWrapper.docker.AttachToContainer(attachContainerOptions)
-	return nil
+	start = time.Now()
+	err = dockerWrapper.docker.AttachToContainer(attachContainerOptions)
+	observeDockerCall("AttachToContainer", start, err)
+	if err != nil {
+		log.Printf("Error on attachToContainer : %v", err)
+	}
+	return err
 }
 
-func (dockerWrapper *DockerStorageClient) UninstallPlugin(pluginName string) error {
+func (dockerWrapper *DockerStorageClient) UninstallPlugin(ctx context.Context, pluginName string) error {
 	/*
 	 * Listing all the containers, even the stopped ones.
 	 */
-	listOps := docker.ListContainersOptions{All: true}
-	containers, err := dockerWrapper.docker.ListContainers(listOps)
+	containers, err := dockerWrapper.helper.ListContainers(ctx, true)
 	if err != nil {
 		log.Printf("Error when listing running Docker containers : %v", err)
 		return err
 	}
+	defer dockerWrapper.helper.Cache().RefreshContainers()
+
 	for _, container := range containers {
 		for _, containerName := range container.Names {
 
@@ -175,12 +228,18 @@ func (dockerWrapper *DockerStorageClient) UninstallPlugin(pluginName string) err
 					/*
 					 * Stopping the container with timeout
 					 */
-					dockerWrapper.docker.StopContainer(container.ID, 10)
+					start := time.Now()
+					err := dockerWrapper.docker.StopContainerWithContext(container.ID, 10, ctx)
+					observeDockerCall("StopContainer", start, err)
 				}
 
 				removeOpts := docker.RemoveContainerOptions{
-					ID: container.ID}
-				dockerWrapper.docker.RemoveContainer(removeOpts)
+					ID:      container.ID,
+					Context: ctx,
+				}
+				start := time.Now()
+				err := dockerWrapper.docker.RemoveContainer(removeOpts)
+				observeDockerCall("RemoveContainer", start, err)
 				log.Printf("container %s removed", container.ID)
 
 			}
@@ -189,12 +248,11 @@ func (dockerWrapper *DockerStorageClient) UninstallPlugin(pluginName string) err
 	return nil
 }
 
-func (dockerWrapper *DockerStorageClient) IsPluginInstalled(name string) (bool, error) {
+func (dockerWrapper *DockerStorageClient) IsPluginInstalled(ctx context.Context, name string) (bool, error) {
 	/*
 	 * Listing all the containers, even the stopped ones.
 	 */
-	listOps := docker.ListContainersOptions{All: true}
-	containers, err := dockerWrapper.docker.ListContainers(listOps)
+	containers, err := dockerWrapper.helper.ListContainers(ctx, true)
 
 	if err != nil {
 		return false, err
@@ -204,9 +262,163 @@ func (dockerWrapper *DockerStorageClient) IsPluginInstalled(name string) (bool,
 	return pluginsArrayContains(plugins.Plugins, name), nil
 }
 
-func (dockerWrapper *DockerStorageClient) listRunningContainers() ([]docker.APIContainers, error) {
-	listOps := docker.ListContainersOptions{All: false}
-	return dockerWrapper.docker.ListContainers(listOps)
+/*
+ * Reads the configuration from the labels of the plugin's container,
+ * inherited from the plugin's image.
+ */
+func (dockerWrapper *DockerStorageClient) GetPluginConfiguration(ctx context.Context, name string) (*pb.PluginConfiguration, error) {
+	containers, err := dockerWrapper.helper.ListContainers(ctx, true)
+	if err != nil {
+		log.Printf("Error when listing Docker containers : %v", err)
+		return nil, err
+	}
+
+	for _, container := range containers {
+		for _, containerName := range container.Names {
+			if containerName != name && containerName != "/"+name {
+				continue
+			}
+			label, ok := container.Labels[config.Label]
+			if !ok {
+				return nil, errors.New("The plugin " + name + " has no configuration")
+			}
+			return config.Parse([]byte(label))
+		}
+	}
+	return nil, errors.New("Plugin not installed : " + name)
+}
+
+func (dockerWrapper *DockerStorageClient) DeletePluginImages(ctx context.Context, name string, oldVersionsOnly bool) (*pb.DeletePluginResponse, error) {
+	images, err := dockerWrapper.helper.ListImages(ctx)
+	if err != nil {
+		log.Printf("Error when listing Docker images : %v", err)
+		return nil, err
+	}
+	defer dockerWrapper.helper.Cache().RefreshImages()
+
+	var containers []docker.APIContainers
+	if oldVersionsOnly {
+		containers, err = dockerWrapper.helper.ListContainers(ctx, true)
+		if err != nil {
+			log.Printf("Error when listing Docker containers : %v", err)
+			return nil, err
+		}
+	}
+
+	/*
+	 * Removing the references one by one rather than the images, so that
+	 * an image also referenced by another repository is kept.
+	 */
+	response := &pb.DeletePluginResponse{}
+	for _, reference := range storage.PluginImageReferences(images, containers, name, oldVersionsOnly) {
+		start := time.Now()
+		err := dockerWrapper.docker.RemoveImageExtended(reference, docker.RemoveImageOptions{Context: ctx})
+		observeDockerCall("RemoveImage", start, err)
+		if err != nil {
+			log.Printf("Error while removing the image %s : %v", reference, err)
+			return response, err
+		}
+		log.Printf("image %s removed", reference)
+		response.RemovedImages = append(response.RemovedImages, reference)
+	}
+
+	/*
+	 * Removing the layers no longer referenced by any image.
+	 */
+	start := time.Now()
+	pruned, err := dockerWrapper.docker.PruneImages(docker.PruneImagesOptions{
+		Filters: map[string][]string{"dangling": {"true"}},
+		Context: ctx,
+	})
+	observeDockerCall("PruneImages", start, err)
+	if err != nil {
+		log.Printf("Error while removing the dangling images : %v", err)
+		return response, err
+	}
+	response.ReclaimedSpace = pruned.SpaceReclaimed
+	return response, nil
+}
+
+func (dockerWrapper *DockerStorageClient) CollectGarbage(ctx context.Context, policy storage.GCPolicy, dryRun bool) (*pb.GarbageCollectionReport, error) {
+	containers, err := dockerWrapper.helper.ListContainers(ctx, true)
+	if err != nil {
+		log.Printf("Error when listing Docker containers : %v", err)
+		return nil, err
+	}
+	images, err := dockerWrapper.helper.ListImages(ctx)
+	if err != nil {
+		log.Printf("Error when listing Docker images : %v", err)
+		return nil, err
+	}
+	if !dryRun {
+		defer dockerWrapper.helper.Cache().Resync()
+	}
+
+	report := &pb.GarbageCollectionReport{DryRun: dryRun}
+	plan := storage.PlanGarbageCollection(images, containers, policy, time.Now())
+
+	/*
+	 * The containers are removed first, so that their images can be removed.
+	 */
+	for _, container := range plan.Containers {
+		name := strings.TrimPrefix(container.Names[0], "/")
+		if !dryRun {
+			start := time.Now()
+			err := dockerWrapper.docker.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Context: ctx})
+			observeDockerCall("RemoveContainer", start, err)
+			if err != nil {
+				report.Errors = append(report.Errors, "container "+name+" : "+err.Error())
+				continue
+			}
+		}
+		report.RemovedContainers = append(report.RemovedContainers, name)
+	}
+
+	for _, reference := range plan.ImageReferences {
+		if !dryRun {
+			start := time.Now()
+			err := dockerWrapper.docker.RemoveImageExtended(reference, docker.RemoveImageOptions{Context: ctx})
+			observeDockerCall("RemoveImage", start, err)
+			if err != nil {
+				report.Errors = append(report.Errors, "image "+reference+" : "+err.Error())
+				continue
+			}
+		}
+		report.RemovedImages = append(report.RemovedImages, reference)
+	}
+
+	if policy.RemoveDanglingImages {
+		start := time.Now()
+		dangling, err := dockerWrapper.docker.ListImages(docker.ListImagesOptions{
+			Filters: map[string][]string{"dangling": {"true"}},
+			Context: ctx,
+		})
+		observeDockerCall("ListImages", start, err)
+		if err != nil {
+			log.Printf("Error when listing the dangling images : %v", err)
+			return report, err
+		}
+		for _, image := range dangling {
+			if plan.IsImageUsed(image.ID) {
+				continue
+			}
+			if !dryRun {
+				start := time.Now()
+				err := dockerWrapper.docker.RemoveImageExtended(image.ID, docker.RemoveImageOptions{Context: ctx})
+				observeDockerCall("RemoveImage", start, err)
+				if err != nil {
+					report.Errors = append(report.Errors, "image "+image.ID+" : "+err.Error())
+					continue
+				}
+			}
+			report.RemovedDanglingImages = append(report.RemovedDanglingImages, image.ID)
+		}
+	}
+	return report, nil
+}
+
+func (dockerWrapper *DockerStorageClient) listRunningContainers(ctx context.Context) ([]docker.APIContainers, error) {
+	return dockerWrapper.helper.ListContainers(ctx, false)
 }
 
 func pluginsArrayContains(plugins []*pb.Plugin, pluginName string) bool {
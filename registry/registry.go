@@ -1,22 +1,31 @@
 package registry
 
 import (
+	"context"
+	"fmt"
 	"log"
 
 	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry/storage"
 )
 
+/*
+ * Operations on the plugins.
+ *
+ * Every operation takes a context whose cancellation aborts the
+ * operation as soon as possible.
+ */
 type Registry interface {
 
 	/*
 	 * Returns the list of downloaded plugins that are not registered.
 	 */
-	ListAvailablePlugins() (*pb.Plugins, error)
+	ListAvailablePlugins(ctx context.Context) (*pb.Plugins, error)
 
 	/*
 	 * Returns the list of the currently running plugins
 	 */
-	ListInstalledPlugins() (*pb.Plugins, error)
+	ListInstalledPlugins(ctx context.Context) (*pb.Plugins, error)
 
 	/*
 	 * Installs the given plugin.
@@ -25,7 +34,7 @@ type Registry interface {
 	 *
 	 * If the plugin is already installed, then it's re-installed.
 	 */
-	InstallPlugin(installRequest pb.InstallPluginRequest) (*pb.NetResponse, error)
+	InstallPlugin(ctx context.Context, installRequest pb.InstallPluginRequest) (*pb.NetResponse, error)
 
 	/*
 	 * Uninstalls the given plugin.
@@ -33,7 +42,27 @@ type Registry interface {
 	 * Please notice that installing a plugin causes the removal
 	 * of the plugin from the list of registered plugins.
 	 */
-	UninstallPlugin(plugin pb.Plugin) (*pb.NetResponse, error)
+	UninstallPlugin(ctx context.Context, plugin pb.Plugin) (*pb.NetResponse, error)
+
+	/*
+	 * Returns the configuration of the given installed plugin.
+	 */
+	GetPluginConfiguration(ctx context.Context, name string) (*pb.PluginConfiguration, error)
+
+	/*
+	 * Removes the images of the given plugin, so that it is no longer
+	 * available.
+	 *
+	 * An installed plugin is only deleted if the request is forced : the
+	 * plugin is then uninstalled first.
+	 */
+	DeletePlugin(ctx context.Context, request pb.DeletePluginRequest) (*pb.DeletePluginResponse, error)
+
+	/*
+	 * Removes the stale plugin containers and images according to the
+	 * given policy, or only reports them if "dryRun" is true.
+	 */
+	CollectGarbage(ctx context.Context, policy storage.GCPolicy, dryRun bool) (*pb.GarbageCollectionReport, error)
 }
 
 /*
@@ -45,60 +74,226 @@ type InMemoryRegistry struct {
 	 * The client to access the location where the plugins are installed.
 	 */
 	pluginStorageClient PluginStorageClient
+
+	/*
+	 * Locks serializing the operations modifying a given plugin.
+	 */
+	locks *pluginLocks
+
+	/*
+	 * Tells whether the instance is the leader, the only one changing
+	 * the plugins.
+	 */
+	elector LeaderElector
+
+	/*
+	 * Events of the operations that succeeded.
+	 */
+	events *EventBus
 }
 
+/*
+ * Creates a registry where the concurrent operations on a plugin
+ * are queued.
+ */
 func NewInMemoryRegistry(pluginStorageClient PluginStorageClient) *InMemoryRegistry {
+	return NewInMemoryRegistryWithPolicy(pluginStorageClient, QueueConcurrentOperations)
+}
+
+/*
+ * Creates a registry handling the concurrent operations on a plugin
+ * according to the given policy.
+ */
+func NewInMemoryRegistryWithPolicy(pluginStorageClient PluginStorageClient, policy ConcurrencyPolicy) *InMemoryRegistry {
 	return &InMemoryRegistry{
 		pluginStorageClient: pluginStorageClient,
+		locks:               newPluginLocks(policy),
+		elector:             singleInstanceElector{},
+		events:              NewEventBus(),
+	}
+}
+
+/*
+ * Sets the election telling whether the instance is the leader. The
+ * installations, uninstallations and deletions fail with "ErrNotLeader"
+ * on the other instances, since the locks serializing them only exist
+ * in the memory of the instance.
+ */
+func (registry *InMemoryRegistry) SetLeaderElector(elector LeaderElector) {
+	registry.elector = elector
+}
+
+/*
+ * Acquires the lock of the given plugin, provided that the instance is
+ * the leader.
+ */
+func (registry *InMemoryRegistry) lock(ctx context.Context, name string) (func(), error) {
+	if !registry.elector.IsLeader() {
+		return nil, ErrNotLeader
 	}
+	return registry.locks.acquire(ctx, name)
 }
 
-func (registry *InMemoryRegistry) ListAvailablePlugins() (*pb.Plugins, error) {
+/*
+ * Returns the bus where the events of the plugins are published.
+ */
+func (registry *InMemoryRegistry) Events() *EventBus {
+	return registry.events
+}
+
+func (registry *InMemoryRegistry) ListAvailablePlugins(ctx context.Context) (*pb.Plugins, error) {
 	log.Println("Listing available plugins")
-	return registry.pluginStorageClient.ListInstallablePlugins()
+	return registry.pluginStorageClient.ListInstallablePlugins(ctx)
 }
 
-func (registry *InMemoryRegistry) ListInstalledPlugins() (*pb.Plugins, error) {
+func (registry *InMemoryRegistry) ListInstalledPlugins(ctx context.Context) (*pb.Plugins, error) {
 	log.Println("Listing installed plugins")
-	return registry.pluginStorageClient.ListInstalledPlugins()
+	return registry.pluginStorageClient.ListInstalledPlugins(ctx)
 }
 
-func (registry *InMemoryRegistry) InstallPlugin(installRequest pb.InstallPluginRequest) (*pb.NetResponse, error) {
+func (registry *InMemoryRegistry) InstallPlugin(ctx context.Context, installRequest pb.InstallPluginRequest) (*pb.NetResponse, error) {
+	if errs := ValidateInstallRequest(&installRequest); errs != nil {
+		log.Printf("Invalid installation request : %v", errs)
+		return nil, errs
+	}
 	name := installRequest.Plugin.Name
 	log.Printf("Installing plugin \"%s\"\n", name)
 
+	release, err := registry.lock(ctx, name)
+	if err != nil {
+		log.Printf("Cannot install plugin \"%s\" : %v", name, err)
+		return nil, err
+	}
+	defer release()
+
 	/*
 	 * First, uninstalling the plugin if required.
 	 */
-	if isInstalled, _ := registry.pluginStorageClient.IsPluginInstalled(name); isInstalled {
+	if isInstalled, _ := registry.pluginStorageClient.IsPluginInstalled(ctx, name); isInstalled {
 		log.Printf("Plugin \"%s\" is already installed. ", name)
 		log.Println("It will be unistalled before installation")
-		_, err := registry.UninstallPlugin(pb.Plugin{Name: name})
+		err := registry.uninstallPlugin(ctx, name)
 
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err := registry.pluginStorageClient.InstallPlugin(
-		name, installRequest.Cmd)
+	err = registry.pluginStorageClient.InstallPlugin(
+		ctx, name, installRequest.Cmd)
 	if err != nil {
 		log.Printf("Error while installing the plugin : %v", err)
 		return nil, err
 	}
+	registry.events.Publish(newPluginEvent(pb.PluginEventType_INSTALLED, name))
 	return &pb.NetResponse{Response: pb.Responses_ACK}, nil
 }
 
-func (registry *InMemoryRegistry) UninstallPlugin(plugin pb.Plugin) (*pb.NetResponse, error) {
+func (registry *InMemoryRegistry) UninstallPlugin(ctx context.Context, plugin pb.Plugin) (*pb.NetResponse, error) {
+	if errs := ValidateUninstallRequest(&plugin); errs != nil {
+		log.Printf("Invalid uninstallation request : %v", errs)
+		return nil, errs
+	}
 	log.Printf("Uninstalling plugin \"%s\"\n", plugin.Name)
 
+	release, err := registry.lock(ctx, plugin.Name)
+	if err != nil {
+		log.Printf("Cannot uninstall plugin \"%s\" : %v", plugin.Name, err)
+		return nil, err
+	}
+	defer release()
+
+	err = registry.uninstallPlugin(ctx, plugin.Name)
+	if err != nil {
+		return nil, err
+	}
+	registry.events.Publish(newPluginEvent(pb.PluginEventType_UNINSTALLED, plugin.Name))
+	return &pb.NetResponse{Response: pb.Responses_ACK}, nil
+}
+
+func (registry *InMemoryRegistry) GetPluginConfiguration(ctx context.Context, name string) (*pb.PluginConfiguration, error) {
+	log.Printf("Reading the configuration of plugin \"%s\"\n", name)
+	return registry.pluginStorageClient.GetPluginConfiguration(ctx, name)
+}
+
+func (registry *InMemoryRegistry) DeletePlugin(ctx context.Context, request pb.DeletePluginRequest) (*pb.DeletePluginResponse, error) {
+	if errs := ValidateDeleteRequest(&request); errs != nil {
+		log.Printf("Invalid deletion request : %v", errs)
+		return nil, errs
+	}
+	name := request.Name
+	log.Printf("Deleting plugin \"%s\"\n", name)
+
+	release, err := registry.lock(ctx, name)
+	if err != nil {
+		log.Printf("Cannot delete plugin \"%s\" : %v", name, err)
+		return nil, err
+	}
+	defer release()
+
 	/*
-	 * Uninstalling the plugin.
+	 * The storage keeps the images of the previous versions that are
+	 * still used by a container, such as the installed plugin's one
+	 * after a rebuild.
 	 */
-	err := registry.pluginStorageClient.UninstallPlugin(plugin.Name)
+	if !request.OldVersionsOnly {
+		isInstalled, err := registry.pluginStorageClient.IsPluginInstalled(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if isInstalled && !request.Force {
+			return nil, fmt.Errorf("The plugin %s is installed", name)
+		}
+		if isInstalled {
+			log.Printf("Plugin \"%s\" is installed. It will be uninstalled before deletion", name)
+			if err := registry.uninstallPlugin(ctx, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	response, err := registry.pluginStorageClient.DeletePluginImages(ctx, name, request.OldVersionsOnly)
 	if err != nil {
-		log.Printf("Error while uninstalling the plugin : %v", err)
+		log.Printf("Error while deleting the plugin : %v", err)
 		return nil, err
 	}
-	return &pb.NetResponse{Response: pb.Responses_ACK}, nil
+	response.Status = true
+	registry.events.Publish(newPluginEvent(pb.PluginEventType_DELETED, name))
+	return response, nil
+}
+
+/*
+ * The plugins' locks are not taken : the policy only removes containers
+ * that are not running and images that are not used, so that the
+ * operations in progress are not affected. Yet only the leader removes
+ * them, since the images used by the operations in progress on the
+ * leader are unknown to the other instances.
+ */
+func (registry *InMemoryRegistry) CollectGarbage(ctx context.Context, policy storage.GCPolicy, dryRun bool) (*pb.GarbageCollectionReport, error) {
+	if !dryRun && !registry.elector.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	log.Printf("Collecting garbage (dry run : %t)", dryRun)
+	report, err := registry.pluginStorageClient.CollectGarbage(ctx, policy, dryRun)
+	if err != nil {
+		log.Printf("Error while collecting garbage : %v", err)
+		return nil, err
+	}
+	log.Printf("Garbage collected : %d containers, %d images, %d dangling images, %d errors",
+		len(report.RemovedContainers), len(report.RemovedImages),
+		len(report.RemovedDanglingImages), len(report.Errors))
+	return report, nil
+}
+
+/*
+ * Uninstalls the given plugin.
+ *
+ * The caller must hold the plugin's lock.
+ */
+func (registry *InMemoryRegistry) uninstallPlugin(ctx context.Context, name string) error {
+	err := registry.pluginStorageClient.UninstallPlugin(ctx, name)
+	if err != nil {
+		log.Printf("Error while uninstalling the plugin : %v", err)
+	}
+	return err
 }
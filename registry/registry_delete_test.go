@@ -0,0 +1,87 @@
+package registry_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+)
+
+/*
+ * Tests that an installed plugin is not deleted unless the request
+ * is forced.
+ */
+func TestDeleteInstalledPlugin(t *testing.T) {
+	client := newFakeStorageClient()
+	testedRegistry := registry.NewInMemoryRegistry(client)
+	ctx := context.Background()
+
+	install(t, testedRegistry, "agilestack-todo")
+	_, err := testedRegistry.DeletePlugin(ctx, pb.DeletePluginRequest{Name: "agilestack-todo"})
+	if err == nil {
+		t.Error("Deleting an installed plugin should fail")
+	}
+	if len(client.deleted) != 0 {
+		t.Errorf("No image should be deleted : %v", client.deleted)
+	}
+
+	response, err := testedRegistry.DeletePlugin(ctx, pb.DeletePluginRequest{Name: "agilestack-todo", Force: true})
+	if err != nil {
+		t.Fatalf("Error while deleting the plugin : %v", err)
+	}
+	if !response.Status || !reflect.DeepEqual(response.RemovedImages, []string{"agilestack-todo:latest"}) {
+		t.Errorf("Invalid response : %v", response)
+	}
+	if client.installed["agilestack-todo"] {
+		t.Error("The plugin should be uninstalled")
+	}
+}
+
+/*
+ * Tests that the previous versions of an installed plugin can be deleted
+ * without uninstalling it.
+ */
+func TestDeleteOldVersionsOfInstalledPlugin(t *testing.T) {
+	client := newFakeStorageClient()
+	testedRegistry := registry.NewInMemoryRegistry(client)
+
+	install(t, testedRegistry, "agilestack-todo")
+	request := pb.DeletePluginRequest{Name: "agilestack-todo", OldVersionsOnly: true}
+	if _, err := testedRegistry.DeletePlugin(context.Background(), request); err != nil {
+		t.Fatalf("Error while deleting the old versions : %v", err)
+	}
+	if !client.installed["agilestack-todo"] {
+		t.Error("The plugin should still be installed")
+	}
+	if !reflect.DeepEqual(client.deleted, []string{"agilestack-todo"}) {
+		t.Errorf("Invalid deletions : %v", client.deleted)
+	}
+}
+
+/*
+ * Tests that the deletions without a valid plugin name are rejected
+ * before taking any lock.
+ */
+func TestDeleteInvalidPlugin(t *testing.T) {
+	client := newFakeStorageClient()
+	testedRegistry := registry.NewInMemoryRegistry(client)
+
+	for _, name := range []string{"", "agilestack-", "agilestack-Todo", "agilestack-core"} {
+		_, err := testedRegistry.DeletePlugin(context.Background(), pb.DeletePluginRequest{Name: name})
+		if _, ok := err.(registry.ValidationErrors); !ok {
+			t.Errorf("The deletion of \"%s\" should be invalid : %v", name, err)
+		}
+	}
+	if len(client.deleted) != 0 {
+		t.Errorf("No image should be deleted : %v", client.deleted)
+	}
+}
+
+func install(t *testing.T, testedRegistry registry.Registry, name string) {
+	request := pb.InstallPluginRequest{Plugin: &pb.Plugin{Name: name}}
+	if _, err := testedRegistry.InstallPlugin(context.Background(), request); err != nil {
+		t.Fatalf("Error while installing the plugin : %v", err)
+	}
+}
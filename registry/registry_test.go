@@ -1,6 +1,7 @@
 package registry_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -23,7 +24,7 @@ var dockerWrapper *registry.DockerStorageClient
 func TestListAvailablePlugins(t *testing.T) {
 	setUp()
 
-	plugins, err := testRegistry.ListAvailablePlugins()
+	plugins, err := testRegistry.ListAvailablePlugins(context.Background())
 	if err != nil {
 		t.Errorf("Error should not be nil : %v", err)
 	}
@@ -49,13 +50,13 @@ func TestListAvailablePluginsWithInstalledPlugins(t *testing.T) {
 			Name: testPluginName,
 		},
 	}
-	_, installErr := testRegistry.InstallPlugin(request)
+	_, installErr := testRegistry.InstallPlugin(context.Background(), request)
 	if installErr != nil {
 		t.Errorf("Error during plugin installation : %v", installErr)
 		return
 	}
 
-	plugins, err := testRegistry.ListAvailablePlugins()
+	plugins, err := testRegistry.ListAvailablePlugins(context.Background())
 	if err != nil {
 		t.Errorf("Error should not be nil : %v", err)
 		return
@@ -75,7 +76,7 @@ func TestListAvailablePluginsWithInstalledPlugins(t *testing.T) {
 func TestListInstalledPluginsEmptyList(t *testing.T) {
 	setUp()
 
-	plugins, err := testRegistry.ListInstalledPlugins()
+	plugins, err := testRegistry.ListInstalledPlugins(context.Background())
 	if err != nil {
 		t.Errorf("Error should not be nil : %v", err)
 		return
@@ -100,13 +101,13 @@ func TestListInstalledPlugins(t *testing.T) {
 			Name: testPluginName,
 		},
 	}
-	_, installErr := testRegistry.InstallPlugin(request)
+	_, installErr := testRegistry.InstallPlugin(context.Background(), request)
 	if installErr != nil {
 		t.Errorf("Error during plugin installation : %v", installErr)
 		return
 	}
 
-	plugins, err := testRegistry.ListInstalledPlugins()
+	plugins, err := testRegistry.ListInstalledPlugins(context.Background())
 	if err != nil {
 		t.Errorf("Error should not be nil : %v", err)
 		return
@@ -120,9 +121,9 @@ func TestListInstalledPlugins(t *testing.T) {
 	/*
 	 * Uninstalling the plugin
 	 */
-	testRegistry.UninstallPlugin(pb.Plugin{Name: testPluginName})
+	testRegistry.UninstallPlugin(context.Background(), pb.Plugin{Name: testPluginName})
 
-	finalPlugins, finalErr := testRegistry.ListInstalledPlugins()
+	finalPlugins, finalErr := testRegistry.ListInstalledPlugins(context.Background())
 	if finalErr != nil {
 		t.Errorf("Error should not be nil : %v", finalErr)
 		return
@@ -143,7 +144,7 @@ func TestInstallPlugin(t *testing.T) {
 	plugin := &pb.Plugin{Name: testPluginName}
 	request := pb.InstallPluginRequest{Plugin: plugin}
 
-	response, err := testRegistry.InstallPlugin(request)
+	response, err := testRegistry.InstallPlugin(context.Background(), request)
 	if err != nil {
 		t.Errorf("Error during plugin installation : %v", err)
 		return
@@ -216,7 +217,7 @@ func TestUninstallPlugin(t *testing.T) {
 	 */
 	plugin := &pb.Plugin{Name: testPluginName}
 	request := pb.InstallPluginRequest{Plugin: plugin}
-	testRegistry.InstallPlugin(request)
+	testRegistry.InstallPlugin(context.Background(), request)
 
 	/*
 	 * Sleeping to wait the Docker container to be started so it
@@ -224,7 +225,7 @@ func TestUninstallPlugin(t *testing.T) {
 	 */
 	time.Sleep(500 * time.Millisecond)
 
-	response, err := testRegistry.UninstallPlugin(*plugin)
+	response, err := testRegistry.UninstallPlugin(context.Background(), *plugin)
 	if err != nil {
 		t.Errorf("Error during plugin un-installation : %v", err)
 		return
@@ -0,0 +1,399 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+ * Maximum size of the JSON body of a request.
+ */
+const maxRequestBodySize = 1 << 20
+
+/*
+ * HTTP handler exposing the operations of the registry as a REST API.
+ *
+ * The bodies are the proto messages in the JSON mapping of proto3, as on
+ * the "core.json.*" topics (see "ProtoJSONEncoder"). The errors are
+ * described by an "ErrorResponse".
+ *
+ * The OpenAPI document of the API is served on "/openapi.json".
+ */
+type RestGateway struct {
+	registry Registry
+	creator  PluginCreator
+	routes   []restRoute
+
+	/*
+	 * Checks the tokens of the requests when the authentication is
+	 * enabled, nil otherwise.
+	 */
+	verifier *TokenVerifier
+
+	/*
+	 * Records the administration requests when the audit log is enabled,
+	 * nil otherwise.
+	 */
+	auditor *Auditor
+}
+
+/*
+ * Operation of the REST API.
+ *
+ * The path segments between braces are parameters. The request and
+ * the response are values of the types of the bodies, used to describe
+ * them in the OpenAPI document.
+ */
+type restRoute struct {
+	method      string
+	path        string
+	operationID string
+	summary     string
+	status      int
+	request     interface{}
+	response    interface{}
+
+	/*
+	 * Whether or not the request body can be omitted.
+	 */
+	optionalBody bool
+
+	/*
+	 * NATS topic of the operation, whose role is required.
+	 */
+	topic string
+
+	handle func(request *http.Request, params map[string]string) (interface{}, int, error)
+}
+
+/*
+ * Body of the responses of the failed requests.
+ */
+type ErrorResponse struct {
+	Error string `json:"error"`
+
+	/*
+	 * Invalid fields of the request, if any.
+	 */
+	ValidationErrors []*pb.ValidationError `json:"validationErrors,omitempty"`
+}
+
+/*
+ * Encodes the validation errors in the JSON mapping of proto3, as the
+ * other bodies.
+ */
+func (response ErrorResponse) MarshalJSON() ([]byte, error) {
+	var validationErrors []json.RawMessage
+	for _, validationError := range response.ValidationErrors {
+		encoded, err := protoJSONMarshaler.MarshalToString(validationError)
+		if err != nil {
+			return nil, err
+		}
+		validationErrors = append(validationErrors, json.RawMessage(encoded))
+	}
+	return json.Marshal(struct {
+		Error            string            `json:"error"`
+		ValidationErrors []json.RawMessage `json:"validationErrors,omitempty"`
+	}{response.Error, validationErrors})
+}
+
+func NewRestGateway(registry Registry, creator PluginCreator) *RestGateway {
+	gateway := &RestGateway{registry: registry, creator: creator}
+	gateway.routes = []restRoute{
+		{
+			method:      "GET",
+			path:        "/plugins/available",
+			operationID: "listAvailablePlugins",
+			summary:     "Lists the plugins that can be installed",
+			topic:       pb.ListAvailablePluginsTopic,
+			status:      http.StatusOK,
+			response:    pb.Plugins{},
+			handle:      gateway.listAvailablePlugins,
+		},
+		{
+			method:      "GET",
+			path:        "/plugins/installed",
+			operationID: "listInstalledPlugins",
+			summary:     "Lists the installed plugins",
+			topic:       pb.ListInstalledPluginsTopic,
+			status:      http.StatusOK,
+			response:    pb.Plugins{},
+			handle:      gateway.listInstalledPlugins,
+		},
+		{
+			method:       "POST",
+			path:         "/plugins/{name}/install",
+			operationID:  "installPlugin",
+			summary:      "Installs a plugin. The plugin is the one of the path",
+			topic:        pb.InstallPluginTopic,
+			status:       http.StatusOK,
+			request:      pb.InstallPluginRequest{},
+			response:     pb.NetResponse{},
+			optionalBody: true,
+			handle:       gateway.installPlugin,
+		},
+		{
+			method:      "DELETE",
+			path:        "/plugins/{name}",
+			operationID: "uninstallPlugin",
+			summary:     "Uninstalls a plugin",
+			topic:       pb.UninstallPluginTopic,
+			status:      http.StatusOK,
+			response:    pb.NetResponse{},
+			handle:      gateway.uninstallPlugin,
+		},
+		{
+			method:      "POST",
+			path:        "/plugins",
+			operationID: "createPlugin",
+			summary:     "Creates a plugin. The build output is streamed on the NATS build topic",
+			topic:       pb.CreatePlugin,
+			status:      http.StatusCreated,
+			request:     pb.NewPluginRequest{},
+			response:    pb.NewPluginResponse{},
+			handle:      gateway.createPlugin,
+		},
+	}
+	return gateway
+}
+
+/*
+ * Checks the token and the role of the requests, as the NATS subscriber
+ * does for the requests on the topics. The token is given in the
+ * "Authorization" header, as "Bearer <token>".
+ *
+ * The requests without a valid token are rejected with the status 401,
+ * the ones not allowed by the requester's role with the status 403.
+ */
+func (gateway *RestGateway) RequireTokens(options AuthOptions) {
+	gateway.verifier = NewTokenVerifier(options)
+}
+
+/*
+ * Records the installations, uninstallations and creations in the audit
+ * log, as the NATS subscriber does for the requests on the topics.
+ */
+func (gateway *RestGateway) SetAuditor(auditor *Auditor) {
+	gateway.auditor = auditor
+}
+
+func (gateway *RestGateway) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path == "/openapi.json" && request.Method == "GET" {
+		writeJSON(writer, http.StatusOK, gateway.OpenAPI())
+		return
+	}
+
+	var allowed []string
+	for _, route := range gateway.routes {
+		params, ok := matchPath(route.path, request.URL.Path)
+		if !ok {
+			continue
+		}
+		if route.method != request.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+
+		if gateway.verifier != nil {
+			requester, ok := gateway.authorize(writer, request, route)
+			if !ok {
+				return
+			}
+			request = request.WithContext(withRequester(request.Context(), requester))
+		}
+
+		response, status, err := route.handle(request, params)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writeJSON(writer, status, response)
+		return
+	}
+
+	if len(allowed) > 0 {
+		writer.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSON(writer, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	writeJSON(writer, http.StatusNotFound, ErrorResponse{Error: "Not found"})
+}
+
+/*
+ * Checks the token and the role of a request of the given route. The
+ * rejected requests are replied to.
+ */
+func (gateway *RestGateway) authorize(writer http.ResponseWriter, request *http.Request, route restRoute) (*Requester, bool) {
+	requester, err := gateway.verifier.VerifyBearer(request.Header.Get("Authorization"))
+	if err != nil {
+		log.Printf("Request %s %s rejected : %v", request.Method, request.URL.Path, err)
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(writer, http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+		return nil, false
+	}
+	if authErr := Authorize(requester, route.topic); authErr != nil {
+		log.Printf("Request %s %s rejected : %s", request.Method, request.URL.Path, authErr.Message)
+		writeJSON(writer, http.StatusForbidden, ErrorResponse{Error: authErr.Message})
+		return nil, false
+	}
+	return requester, true
+}
+
+func (gateway *RestGateway) listAvailablePlugins(request *http.Request, _ map[string]string) (interface{}, int, error) {
+	plugins, err := gateway.registry.ListAvailablePlugins(request.Context())
+	return plugins, http.StatusOK, err
+}
+
+func (gateway *RestGateway) listInstalledPlugins(request *http.Request, _ map[string]string) (interface{}, int, error) {
+	plugins, err := gateway.registry.ListInstalledPlugins(request.Context())
+	return plugins, http.StatusOK, err
+}
+
+func (gateway *RestGateway) installPlugin(request *http.Request, params map[string]string) (interface{}, int, error) {
+	installRequest := &pb.InstallPluginRequest{}
+	if err := decodeBody(request, installRequest); err != nil {
+		return nil, 0, err
+	}
+	installRequest.Plugin = &pb.Plugin{Name: params["name"]}
+
+	call := gateway.auditor.audit(request.Context(), pb.InstallPluginTopic, installRequest)
+	response, err := gateway.registry.InstallPlugin(request.Context(), *installRequest)
+	call.end(response, err)
+	return response, http.StatusOK, err
+}
+
+func (gateway *RestGateway) uninstallPlugin(request *http.Request, params map[string]string) (interface{}, int, error) {
+	plugin := &pb.Plugin{Name: params["name"]}
+	call := gateway.auditor.audit(request.Context(), pb.UninstallPluginTopic, plugin)
+	response, err := gateway.registry.UninstallPlugin(request.Context(), *plugin)
+	call.end(response, err)
+	return response, http.StatusOK, err
+}
+
+/*
+ * Creates a plugin. The response is given with the status 400 if the
+ * request is invalid, and with the status 500 if the creation failed.
+ */
+func (gateway *RestGateway) createPlugin(request *http.Request, _ map[string]string) (interface{}, int, error) {
+	newPluginRequest := &pb.NewPluginRequest{}
+	if err := decodeBody(request, newPluginRequest); err != nil {
+		return nil, 0, err
+	}
+
+	call := gateway.auditor.audit(request.Context(), pb.CreatePlugin, newPluginRequest)
+	response := gateway.creator.CreatePlugin(request.Context(), newPluginRequest)
+	call.end(response, nil)
+	switch {
+	case len(response.ValidationErrors) > 0:
+		return response, http.StatusBadRequest, nil
+	case !response.Status:
+		return response, http.StatusInternalServerError, nil
+	}
+	return response, http.StatusCreated, nil
+}
+
+/*
+ * Error returned when the body of a request cannot be decoded.
+ */
+type bodyError struct {
+	err error
+}
+
+func (err bodyError) Error() string {
+	return "Invalid request body : " + err.err.Error()
+}
+
+/*
+ * Decodes the JSON body of the request into the given message. An empty
+ * body leaves the message unchanged. The unknown fields are rejected, as
+ * on the "core.json.*" topics.
+ */
+func decodeBody(request *http.Request, message proto.Message) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, request.Body, maxRequestBodySize))
+	if err := jsonpb.UnmarshalNext(decoder, message); err != nil && err != io.EOF {
+		return bodyError{err}
+	}
+	return nil
+}
+
+/*
+ * Returns the status of the response to a request that failed with
+ * the given error.
+ */
+func httpStatus(err error) int {
+	switch err.(type) {
+	case ValidationErrors, bodyError:
+		return http.StatusBadRequest
+	}
+	switch err {
+	case ErrPluginBusy:
+		return http.StatusConflict
+	case ErrNotLeader:
+		return http.StatusServiceUnavailable
+	case context.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case context.Canceled:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(writer http.ResponseWriter, err error) {
+	response := ErrorResponse{Error: err.Error()}
+	if errs, ok := err.(ValidationErrors); ok {
+		response.ValidationErrors = errs.Proto()
+	}
+	writeJSON(writer, httpStatus(err), response)
+}
+
+/*
+ * Writes the given body, encoded in the JSON mapping of proto3 if it is
+ * a proto message.
+ */
+func writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	var err error
+	if message, ok := body.(proto.Message); ok {
+		err = protoJSONMarshaler.Marshal(writer, message)
+	} else {
+		err = json.NewEncoder(writer).Encode(body)
+	}
+	if err != nil {
+		log.Printf("Error while writing the response : %v", err)
+	}
+}
+
+/*
+ * Matches the given path against the path of a route. Returns the values
+ * of the parameters, and whether or not the path matches.
+ */
+func matchPath(pattern string, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			params[strings.Trim(segment, "{}")] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
@@ -0,0 +1,214 @@
+package registry_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+	"github.com/golang-jwt/jwt"
+	"github.com/golang/protobuf/jsonpb"
+)
+
+func serve(handler http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return recorder
+}
+
+func TestRestInstallAndUninstall(t *testing.T) {
+	client := newFakeStorageClient()
+	gateway := registry.NewRestGateway(registry.NewInMemoryRegistry(client), fakeCreator{})
+
+	recorder := serve(gateway, "POST", "/plugins/agilestack-todo/install", "")
+	if recorder.Code != http.StatusOK || !client.installed["agilestack-todo"] {
+		t.Fatalf("Invalid installation : %d %s", recorder.Code, recorder.Body)
+	}
+	if !strings.Contains(recorder.Body.String(), `"response":"ACK"`) {
+		t.Errorf("The enums should be encoded by their name : %s", recorder.Body)
+	}
+
+	recorder = serve(gateway, "GET", "/plugins/installed", "")
+	plugins := &pb.Plugins{}
+	if err := jsonpb.Unmarshal(recorder.Body, plugins); err != nil {
+		t.Fatalf("Invalid response : %v", err)
+	}
+	if len(plugins.Plugins) != 1 || plugins.Plugins[0].Name != "agilestack-todo" || plugins.Plugins[0].PluginStatus != pb.PluginStatus_OK {
+		t.Errorf("Invalid installed plugins : %v", plugins)
+	}
+
+	recorder = serve(gateway, "DELETE", "/plugins/agilestack-todo", "")
+	if recorder.Code != http.StatusOK || client.installed["agilestack-todo"] {
+		t.Errorf("Invalid uninstallation : %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestRestCreatePlugin(t *testing.T) {
+	creator := fakeCreator{response: &pb.NewPluginResponse{Status: true, BuildId: "build", LogLines: 12}}
+	gateway := registry.NewRestGateway(registry.NewInMemoryRegistry(newFakeStorageClient()), creator)
+
+	recorder := serve(gateway, "POST", "/plugins", `{"name":"todo","template":"default"}`)
+	if recorder.Code != http.StatusCreated || !strings.Contains(recorder.Body.String(), `"buildId":"build"`) {
+		t.Errorf("Invalid creation : %d %s", recorder.Code, recorder.Body)
+	}
+	if !strings.Contains(recorder.Body.String(), `"logLines":"12"`) {
+		t.Errorf("The 64-bit integers should be encoded as strings : %s", recorder.Body)
+	}
+
+	recorder = serve(gateway, "POST", "/plugins", `{"name":`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("An invalid body should be rejected : %d", recorder.Code)
+	}
+}
+
+func TestRestInvalidCreation(t *testing.T) {
+	creator := fakeCreator{response: &pb.NewPluginResponse{
+		Error:            "name: Invalid plugin name",
+		ValidationErrors: []*pb.ValidationError{{Field: "name", Message: "Invalid plugin name"}},
+	}}
+	gateway := registry.NewRestGateway(registry.NewInMemoryRegistry(newFakeStorageClient()), creator)
+
+	recorder := serve(gateway, "POST", "/plugins", `{"name":"Todo"}`)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), `"field":"name"`) {
+		t.Errorf("Invalid response : %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestRestRouting(t *testing.T) {
+	gateway := registry.NewRestGateway(registry.NewInMemoryRegistry(newFakeStorageClient()), fakeCreator{})
+
+	if recorder := serve(gateway, "GET", "/plugins/unknown/path", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Invalid status for an unknown path : %d", recorder.Code)
+	}
+	recorder := serve(gateway, "PUT", "/plugins", "")
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != "POST" {
+		t.Errorf("Invalid status for an unknown method : %d %v", recorder.Code, recorder.Header())
+	}
+}
+
+/*
+ * Tests that the requests are rejected without a token, or when the
+ * requester's role does not allow them.
+ */
+func TestRestAuthentication(t *testing.T) {
+	client := newFakeStorageClient()
+	gateway := registry.NewRestGateway(registry.NewInMemoryRegistry(client), fakeCreator{})
+	gateway.RequireTokens(registry.AuthOptions{HMACSecret: testSecret})
+
+	recorder := serve(gateway, "GET", "/plugins/installed", "")
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("A request without token should be rejected : %d %v", recorder.Code, recorder.Header())
+	}
+
+	token := newToken(t, jwt.SigningMethodHS256, testSecret, "alice", "viewer")
+	authorized := func(method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, strings.NewReader(""))
+		request.Header.Set("Authorization", "Bearer "+token)
+		gateway.ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := authorized("GET", "/plugins/installed"); recorder.Code != http.StatusOK {
+		t.Errorf("A viewer should list the plugins : %d %s", recorder.Code, recorder.Body)
+	}
+	if recorder := authorized("POST", "/plugins/agilestack-todo/install"); recorder.Code != http.StatusForbidden || client.installed["agilestack-todo"] {
+		t.Errorf("A viewer should not install a plugin : %d %s", recorder.Code, recorder.Body)
+	}
+	if recorder := serve(gateway, "GET", "/openapi.json", ""); recorder.Code != http.StatusOK {
+		t.Errorf("The OpenAPI document should be public : %d", recorder.Code)
+	}
+}
+
+/*
+ * Tests that the OpenAPI document describes every route and the
+ * messages of their bodies.
+ */
+/*
+ * Tests that the requests are recorded in the audit log, with the build
+ * arguments redacted and the configuration changes told from the
+ * creations.
+ */
+func TestRestAudit(t *testing.T) {
+	auditLog, _, remove := openTestAuditLog(t, registry.DefaultAuditOptions())
+	defer remove()
+
+	client := newFakeStorageClient()
+	client.images = []string{"agilestack-todo"}
+	creator := fakeCreator{response: &pb.NewPluginResponse{Status: true}}
+	gateway := registry.NewRestGateway(registry.NewInMemoryRegistry(client), creator)
+	gateway.SetAuditor(registry.NewAuditor(auditLog, client))
+
+	serve(gateway, "POST", "/plugins", `{"name":"todo","configuration":{"name":"todo"},"buildArgs":{"TOKEN":"s3cr3t"}}`)
+	serve(gateway, "POST", "/plugins", `{"name":"agenda","configuration":{"name":"agenda"}}`)
+	serve(gateway, "DELETE", "/plugins/agilestack-todo", "")
+
+	entries, err := auditLog.Query(&pb.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Invalid entries : %v", entries)
+	}
+	if entries[0].Action != "configure" || entries[1].Action != "create" || entries[2].Action != "uninstall" {
+		t.Errorf("Invalid actions : %v", entries)
+	}
+	if strings.Contains(entries[0].Request, "s3cr3t") || !strings.Contains(entries[0].Request, `"TOKEN":"REDACTED"`) {
+		t.Errorf("The build arguments should be redacted : %s", entries[0].Request)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	gateway := registry.NewRestGateway(registry.NewInMemoryRegistry(newFakeStorageClient()), fakeCreator{})
+	recorder := serve(gateway, "GET", "/openapi.json", "")
+
+	var document struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Type string   `json:"type"`
+					Enum []string `json:"enum"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatalf("Invalid document : %v", err)
+	}
+
+	operations := map[string]string{
+		"/plugins/available":      "get",
+		"/plugins/installed":      "get",
+		"/plugins/{name}/install": "post",
+		"/plugins/{name}":         "delete",
+		"/plugins":                "post",
+	}
+	for path, method := range operations {
+		if _, ok := document.Paths[path][method]; !ok {
+			t.Errorf("Missing operation %s %s", method, path)
+		}
+	}
+
+	for _, name := range []string{"Plugins", "Plugin", "NewPluginRequest", "PluginConfiguration", "ErrorResponse"} {
+		if _, ok := document.Components.Schemas[name]; !ok {
+			t.Errorf("Missing schema %s", name)
+		}
+	}
+	if _, ok := document.Components.Schemas["NewPluginRequest"].Properties["buildArgs"]; !ok {
+		t.Error("The schema of the creation request should describe the build arguments")
+	}
+
+	/*
+	 * The schemas follow the JSON mapping of the bodies.
+	 */
+	status := document.Components.Schemas["Plugin"].Properties["pluginStatus"]
+	if status.Type != "string" || len(status.Enum) == 0 || status.Enum[0] != pb.PluginStatus_name[0] {
+		t.Errorf("Invalid schema of the plugin status : %v", status)
+	}
+	if logLines := document.Components.Schemas["NewPluginResponse"].Properties["logLines"]; logLines.Type != "string" {
+		t.Errorf("Invalid schema of the build log lines : %v", logLines)
+	}
+}
@@ -0,0 +1,196 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+var archiveIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+/*
+ * Suffix of the archives being uploaded.
+ */
+const partialSuffix = ".tar.part"
+
+/*
+ * Storage of the tar archives uploaded in chunks.
+ *
+ * The chunks of an upload must be sent in order, starting from the
+ * sequence number 1. The archive becomes available once its last
+ * chunk is received.
+ */
+type ArchiveStore struct {
+	directory string
+
+	/*
+	 * Maximum size of an archive in bytes. Zero means no limit.
+	 */
+	maxSize int64
+
+	/*
+	 * Duration after which an upload without new chunk is abandoned :
+	 * its partial archive is removed when a new upload starts. Zero means
+	 * the uploads are never abandoned.
+	 */
+	uploadTimeout time.Duration
+
+	mutex   sync.Mutex
+	uploads map[string]*upload
+}
+
+/*
+ * Upload in progress.
+ */
+type upload struct {
+	nextSequence int64
+	size         int64
+}
+
+func NewArchiveStore(directory string, maxSize int64, uploadTimeout time.Duration) *ArchiveStore {
+	return &ArchiveStore{
+		directory:     directory,
+		maxSize:       maxSize,
+		uploadTimeout: uploadTimeout,
+		uploads:       make(map[string]*upload),
+	}
+}
+
+/*
+ * Appends the given chunk to its archive.
+ *
+ * When an error is returned, the upload is aborted and must be
+ * started again.
+ */
+func (store *ArchiveStore) Append(chunk *pb.ArchiveChunk) error {
+	if !archiveIDRegexp.MatchString(chunk.UploadId) {
+		return errors.New("Invalid upload identifier : " + chunk.UploadId)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, ok := store.uploads[chunk.UploadId]
+	if !ok {
+		if chunk.Sequence != 1 {
+			return fmt.Errorf("Unknown upload %s", chunk.UploadId)
+		}
+		if err := os.MkdirAll(store.directory, 0755); err != nil {
+			return err
+		}
+		store.removeAbandonedUploads()
+		current = &upload{nextSequence: 1}
+		store.uploads[chunk.UploadId] = current
+		os.Remove(store.partialPath(chunk.UploadId))
+	}
+
+	err := store.append(current, chunk)
+	if err != nil || chunk.Last {
+		delete(store.uploads, chunk.UploadId)
+	}
+	if err != nil {
+		os.Remove(store.partialPath(chunk.UploadId))
+		return err
+	}
+
+	if chunk.Last {
+		return os.Rename(store.partialPath(chunk.UploadId), store.Path(chunk.UploadId))
+	}
+	return nil
+}
+
+func (store *ArchiveStore) append(current *upload, chunk *pb.ArchiveChunk) error {
+	if chunk.Sequence != current.nextSequence {
+		return fmt.Errorf("Invalid chunk sequence for upload %s : expected %d, got %d",
+			chunk.UploadId, current.nextSequence, chunk.Sequence)
+	}
+	if store.maxSize > 0 && current.size+int64(len(chunk.Data)) > store.maxSize {
+		return fmt.Errorf("The archive %s exceeds the maximum size of %d bytes",
+			chunk.UploadId, store.maxSize)
+	}
+
+	file, err := os.OpenFile(store.partialPath(chunk.UploadId), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(chunk.Data); err != nil {
+		return err
+	}
+	current.nextSequence++
+	current.size += int64(len(chunk.Data))
+	return nil
+}
+
+/*
+ * Removes the partial archives not appended to for longer than the upload
+ * timeout, including the ones left by a previous run. Must be called with
+ * the mutex locked.
+ */
+func (store *ArchiveStore) removeAbandonedUploads() {
+	if store.uploadTimeout <= 0 {
+		return
+	}
+	files, err := ioutil.ReadDir(store.directory)
+	if err != nil {
+		log.Printf("Error while listing the uploads of %s : %v", store.directory, err)
+		return
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), partialSuffix) || time.Since(file.ModTime()) < store.uploadTimeout {
+			continue
+		}
+		uploadID := strings.TrimSuffix(file.Name(), partialSuffix)
+		log.Printf("Removing the abandoned upload %s", uploadID)
+		delete(store.uploads, uploadID)
+		if err := os.Remove(filepath.Join(store.directory, file.Name())); err != nil {
+			log.Printf("Error while removing the abandoned upload %s : %v", uploadID, err)
+		}
+	}
+}
+
+/*
+ * Opens the given complete archive.
+ */
+func (store *ArchiveStore) Open(archiveID string) (*os.File, error) {
+	if !archiveIDRegexp.MatchString(archiveID) {
+		return nil, errors.New("Invalid archive identifier : " + archiveID)
+	}
+	file, err := os.Open(store.Path(archiveID))
+	if os.IsNotExist(err) {
+		return nil, errors.New("Unknown archive : " + archiveID)
+	}
+	return file, err
+}
+
+/*
+ * Removes the given archive.
+ */
+func (store *ArchiveStore) Remove(archiveID string) error {
+	if !archiveIDRegexp.MatchString(archiveID) {
+		return errors.New("Invalid archive identifier : " + archiveID)
+	}
+	return os.Remove(store.Path(archiveID))
+}
+
+/*
+ * Returns the path of the given complete archive.
+ */
+func (store *ArchiveStore) Path(archiveID string) string {
+	return filepath.Join(store.directory, archiveID+".tar")
+}
+
+func (store *ArchiveStore) partialPath(archiveID string) string {
+	return filepath.Join(store.directory, archiveID+partialSuffix)
+}
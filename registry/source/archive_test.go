@@ -0,0 +1,134 @@
+package source_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry/source"
+)
+
+func TestArchiveUpload(t *testing.T) {
+	directory := tempDir(t)
+	defer os.RemoveAll(directory)
+	store := source.NewArchiveStore(directory, 0, 0)
+
+	chunks := []*pb.ArchiveChunk{
+		{UploadId: "upload-1", Sequence: 1, Data: []byte("first ")},
+		{UploadId: "upload-1", Sequence: 2, Data: []byte("second ")},
+		{UploadId: "upload-1", Sequence: 3, Data: []byte("last"), Last: true},
+	}
+	for _, chunk := range chunks {
+		if _, err := store.Open("upload-1"); err == nil {
+			t.Error("The archive should not be available before its last chunk")
+		}
+		if err := store.Append(chunk); err != nil {
+			t.Fatalf("Error while appending the chunk %d : %v", chunk.Sequence, err)
+		}
+	}
+
+	archive, err := store.Open("upload-1")
+	if err != nil {
+		t.Fatalf("Error while opening the archive : %v", err)
+	}
+	content, _ := ioutil.ReadAll(archive)
+	archive.Close()
+	if string(content) != "first second last" {
+		t.Errorf("Invalid archive content : %q", content)
+	}
+
+	if err := store.Remove("upload-1"); err != nil {
+		t.Errorf("Error while removing the archive : %v", err)
+	}
+	if _, err := store.Open("upload-1"); err == nil {
+		t.Error("The archive should be removed")
+	}
+}
+
+func TestArchiveUploadOutOfOrder(t *testing.T) {
+	directory := tempDir(t)
+	defer os.RemoveAll(directory)
+	store := source.NewArchiveStore(directory, 0, 0)
+
+	if err := store.Append(&pb.ArchiveChunk{UploadId: "upload-1", Sequence: 2}); err == nil {
+		t.Error("An upload should start with the chunk 1")
+	}
+
+	store.Append(&pb.ArchiveChunk{UploadId: "upload-1", Sequence: 1, Data: []byte("first")})
+	if err := store.Append(&pb.ArchiveChunk{UploadId: "upload-1", Sequence: 3}); err == nil {
+		t.Error("A missing chunk should be detected")
+	}
+
+	/*
+	 * The upload was aborted.
+	 */
+	if err := store.Append(&pb.ArchiveChunk{UploadId: "upload-1", Sequence: 2}); err == nil {
+		t.Error("The upload should be aborted")
+	}
+}
+
+func TestArchiveUploadMaxSize(t *testing.T) {
+	directory := tempDir(t)
+	defer os.RemoveAll(directory)
+	store := source.NewArchiveStore(directory, 8, 0)
+
+	store.Append(&pb.ArchiveChunk{UploadId: "upload-1", Sequence: 1, Data: []byte("12345")})
+	if err := store.Append(&pb.ArchiveChunk{UploadId: "upload-1", Sequence: 2, Data: []byte("6789"), Last: true}); err == nil {
+		t.Error("The archive exceeds the maximum size")
+	}
+}
+
+/*
+ * Tests that the partial archives not appended to for longer than the
+ * upload timeout are removed when a new upload starts, including the ones
+ * left by a previous run.
+ */
+func TestArchiveAbandonedUploads(t *testing.T) {
+	directory := tempDir(t)
+	defer os.RemoveAll(directory)
+	store := source.NewArchiveStore(directory, 0, time.Hour)
+
+	store.Append(&pb.ArchiveChunk{UploadId: "abandoned", Sequence: 1, Data: []byte("first")})
+	store.Append(&pb.ArchiveChunk{UploadId: "recent", Sequence: 1, Data: []byte("first")})
+	orphan := filepath.Join(directory, "orphan.tar.part")
+	if err := ioutil.WriteFile(orphan, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{filepath.Join(directory, "abandoned.tar.part"), orphan} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Append(&pb.ArchiveChunk{UploadId: "new", Sequence: 1, Data: []byte("first")}); err != nil {
+		t.Fatalf("Error while starting the upload : %v", err)
+	}
+	for _, name := range []string{"abandoned.tar.part", "orphan.tar.part"} {
+		if _, err := os.Stat(filepath.Join(directory, name)); !os.IsNotExist(err) {
+			t.Errorf("The abandoned upload %s should be removed : %v", name, err)
+		}
+	}
+	if err := store.Append(&pb.ArchiveChunk{UploadId: "abandoned", Sequence: 2, Last: true}); err == nil {
+		t.Error("The abandoned upload should not be continued")
+	}
+	if err := store.Append(&pb.ArchiveChunk{UploadId: "recent", Sequence: 2, Data: []byte(" last"), Last: true}); err != nil {
+		t.Errorf("The recent upload should be continued : %v", err)
+	}
+}
+
+func TestArchiveInvalidIdentifier(t *testing.T) {
+	directory := tempDir(t)
+	defer os.RemoveAll(directory)
+	store := source.NewArchiveStore(directory, 0, 0)
+
+	if err := store.Append(&pb.ArchiveChunk{UploadId: "../upload", Sequence: 1}); err == nil {
+		t.Error("The identifier should be rejected")
+	}
+	if _, err := store.Open("../upload"); err == nil {
+		t.Error("The identifier should be rejected")
+	}
+}
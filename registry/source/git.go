@@ -0,0 +1,98 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+/*
+ * Schemes of the git repositories the plugins can be cloned from.
+ */
+var GitURLSchemes = []string{"https", "ssh", "git"}
+
+/*
+ * SCP-like syntax of the SSH repositories : "user@host:path".
+ */
+var scpLikeURLRegexp = regexp.MustCompile(`^[A-Za-z0-9._][A-Za-z0-9._-]*@[A-Za-z0-9][A-Za-z0-9.-]*:[^:]`)
+
+/*
+ * Checks that the given URL is the one of a remote repository, having one
+ * of the allowed schemes or the SCP-like syntax of SSH.
+ *
+ * The local repositories, given by a path or with the "file" scheme, are
+ * not allowed, as well as the other transports of git such as "ext".
+ */
+func ValidateGitURL(rawURL string) error {
+	if scpLikeURLRegexp.MatchString(rawURL) {
+		return nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" || strings.HasPrefix(parsed.Host, "-") {
+		return errors.New("Invalid git repository URL : " + rawURL)
+	}
+	for _, scheme := range GitURLSchemes {
+		if parsed.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("The git repository must be given with one of the schemes %s, or as user@host:path",
+		strings.Join(GitURLSchemes, ", "))
+}
+
+/*
+ * Clones the given git repository into the destination directory and
+ * checks out the given reference (branch, tag or commit).
+ *
+ * If the reference is empty, the default branch of the repository is
+ * checked out.
+ */
+func FetchGit(ctx context.Context, url string, ref string, destination string) error {
+	if url == "" || strings.HasPrefix(url, "-") {
+		return errors.New("Invalid git repository URL : " + url)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return errors.New("Invalid git reference : " + ref)
+	}
+
+	if err := runGit(ctx, "", "clone", "--quiet", "--", url, destination); err != nil {
+		return err
+	}
+	if ref == "" {
+		return nil
+	}
+	return runGit(ctx, destination, "checkout", "--quiet", ref, "--")
+}
+
+/*
+ * Returns the identifier of the commit checked out in the given
+ * directory, which must be a git working copy.
+ */
+func GitRevision(ctx context.Context, directory string) (string, error) {
+	output, err := gitOutput(ctx, directory, "rev-parse", "HEAD")
+	return strings.TrimSpace(output), err
+}
+
+func runGit(ctx context.Context, directory string, args ...string) error {
+	_, err := gitOutput(ctx, directory, args...)
+	return err
+}
+
+func gitOutput(ctx context.Context, directory string, args ...string) (string, error) {
+	command := exec.CommandContext(ctx, "git", args...)
+	command.Dir = directory
+
+	var output, errorOutput bytes.Buffer
+	command.Stdout = &output
+	command.Stderr = &errorOutput
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed : %v : %s", args[0], err,
+			strings.TrimSpace(errorOutput.String()+output.String()))
+	}
+	return output.String(), nil
+}
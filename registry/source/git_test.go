@@ -0,0 +1,147 @@
+package source_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eogile/agilestack-core/registry/source"
+)
+
+func TestFetchGitDefaultBranch(t *testing.T) {
+	repository := createBareRepository(t)
+	defer os.RemoveAll(filepath.Dir(repository))
+
+	destination := tempDir(t)
+	defer os.RemoveAll(destination)
+
+	if err := source.FetchGit(context.Background(), repository, "", destination); err != nil {
+		t.Fatalf("Error while fetching the repository : %v", err)
+	}
+	assertFileContent(t, destination, "Dockerfile", "FROM scratch\nLABEL version=2\n")
+}
+
+func TestFetchGitTag(t *testing.T) {
+	repository := createBareRepository(t)
+	defer os.RemoveAll(filepath.Dir(repository))
+
+	destination := tempDir(t)
+	defer os.RemoveAll(destination)
+
+	if err := source.FetchGit(context.Background(), repository, "v1", destination); err != nil {
+		t.Fatalf("Error while fetching the repository : %v", err)
+	}
+	assertFileContent(t, destination, "Dockerfile", "FROM scratch\nLABEL version=1\n")
+}
+
+func TestGitRevision(t *testing.T) {
+	repository := createBareRepository(t)
+	defer os.RemoveAll(filepath.Dir(repository))
+
+	destination := tempDir(t)
+	defer os.RemoveAll(destination)
+
+	source.FetchGit(context.Background(), repository, "v1", destination)
+	revision, err := source.GitRevision(context.Background(), destination)
+	if err != nil {
+		t.Fatalf("Error while reading the revision : %v", err)
+	}
+
+	expected, _ := exec.Command("git", "--git-dir", repository, "rev-parse", "v1^{commit}").Output()
+	if revision != strings.TrimSpace(string(expected)) {
+		t.Errorf("Invalid revision : %s", revision)
+	}
+
+	if _, err := source.GitRevision(context.Background(), filepath.Dir(repository)); err == nil {
+		t.Error("A directory which is not a working copy has no revision")
+	}
+}
+
+func TestFetchGitUnknownRef(t *testing.T) {
+	repository := createBareRepository(t)
+	defer os.RemoveAll(filepath.Dir(repository))
+
+	destination := tempDir(t)
+	defer os.RemoveAll(destination)
+
+	if err := source.FetchGit(context.Background(), repository, "unknown", destination); err == nil {
+		t.Error("Fetching an unknown reference should fail")
+	}
+}
+
+func TestFetchGitInvalidArguments(t *testing.T) {
+	destination := tempDir(t)
+	defer os.RemoveAll(destination)
+
+	if err := source.FetchGit(context.Background(), "--upload-pack=touch /tmp/x", "", destination); err == nil {
+		t.Error("An URL looking like an option should be rejected")
+	}
+	if err := source.FetchGit(context.Background(), "/repository.git", "--orphan", destination); err == nil {
+		t.Error("A reference looking like an option should be rejected")
+	}
+}
+
+/*
+ * Creates a bare repository with two commits. The first one is tagged "v1".
+ */
+func createBareRepository(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := tempDir(t)
+	work := filepath.Join(root, "work")
+	bare := filepath.Join(root, "plugin.git")
+
+	git := func(directory string, args ...string) {
+		command := exec.Command("git", args...)
+		command.Dir = directory
+		command.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=agilestack", "GIT_AUTHOR_EMAIL=agilestack@eogile.com",
+			"GIT_COMMITTER_NAME=agilestack", "GIT_COMMITTER_EMAIL=agilestack@eogile.com")
+		if output, err := command.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed : %v : %s", args, err, output)
+		}
+	}
+
+	os.MkdirAll(work, 0755)
+	git(work, "init", "--quiet")
+	writeFile(t, work, "Dockerfile", "FROM scratch\nLABEL version=1\n")
+	git(work, "add", "Dockerfile")
+	git(work, "commit", "--quiet", "-m", "First version")
+	git(work, "tag", "v1")
+	writeFile(t, work, "Dockerfile", "FROM scratch\nLABEL version=2\n")
+	git(work, "commit", "--quiet", "-am", "Second version")
+	git(root, "clone", "--quiet", "--bare", work, bare)
+
+	return bare
+}
+
+func tempDir(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "agilestack-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return directory
+}
+
+func writeFile(t *testing.T, directory string, name string, content string) {
+	if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertFileContent(t *testing.T, directory string, name string, expectedContent string) {
+	content, err := ioutil.ReadFile(filepath.Join(directory, name))
+	if err != nil {
+		t.Errorf("Error while reading %s : %v", name, err)
+		return
+	}
+	if string(content) != expectedContent {
+		t.Errorf("Invalid content for %s : %q", name, content)
+	}
+}
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	/*
+	 * Delay between two attempts to re-subscribe to the Docker events
+	 * API after the events stream was lost.
+	 */
+	eventsReconnectDelay = 2 * time.Second
+)
+
+/*
+ * Called after each call made by the caches to the Docker API, with the
+ * time the call began, so that the calls can be measured.
+ */
+var ObserveDockerCall = func(operation string, start time.Time, err error) {}
+
+/*
+ * In-memory view of the Docker images and containers.
+ *
+ * The cache is fully synchronized when it starts and every time the
+ * connection to the Docker events API is re-established. In between, the
+ * Docker events are used to know when the images or the containers must
+ * be refreshed.
+ *
+ * As long as the cache has never been synchronized, the calls are
+ * forwarded to the Docker daemon.
+ */
+type DockerCache struct {
+	docker *docker.Client
+
+	mutex      sync.RWMutex
+	images     []docker.APIImages
+	containers []docker.APIContainers
+	synced     bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewDockerCache(docker *docker.Client) *DockerCache {
+	return &DockerCache{
+		docker: docker,
+		stop:   make(chan struct{}),
+	}
+}
+
+/*
+ * Synchronizes the cache and starts watching the Docker events.
+ *
+ * The returned error is the one of the initial synchronization. The
+ * events are watched even if this synchronization failed.
+ */
+func (cache *DockerCache) Start() error {
+	err := cache.Resync()
+	go cache.watch()
+	return err
+}
+
+/*
+ * Stops watching the Docker events. Can be called more than once.
+ */
+func (cache *DockerCache) Stop() {
+	cache.stopOnce.Do(func() {
+		close(cache.stop)
+	})
+}
+
+/*
+ * Reloads both the images and the containers from the Docker daemon.
+ */
+func (cache *DockerCache) Resync() error {
+	images, err := cache.listImages()
+	if err != nil {
+		log.Printf("Error when listing Docker images : %v", err)
+		return err
+	}
+	containers, err := cache.listContainers()
+	if err != nil {
+		log.Printf("Error when listing Docker containers : %v", err)
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.images = images
+	cache.containers = containers
+	cache.synced = true
+	return nil
+}
+
+/*
+ * Reloads the images from the Docker daemon.
+ */
+func (cache *DockerCache) RefreshImages() error {
+	images, err := cache.listImages()
+	if err != nil {
+		log.Printf("Error when listing Docker images : %v", err)
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.images = images
+	return nil
+}
+
+/*
+ * Reloads the containers from the Docker daemon.
+ */
+func (cache *DockerCache) RefreshContainers() error {
+	containers, err := cache.listContainers()
+	if err != nil {
+		log.Printf("Error when listing Docker containers : %v", err)
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.containers = containers
+	return nil
+}
+
+/*
+ * Returns the list of top-level Docker images.
+ */
+func (cache *DockerCache) Images(ctx context.Context) ([]docker.APIImages, error) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	if !cache.synced {
+		start := time.Now()
+		images, err := cache.docker.ListImages(docker.ListImagesOptions{All: false, Context: ctx})
+		ObserveDockerCall("ListImages", start, err)
+		return images, err
+	}
+	images := make([]docker.APIImages, len(cache.images))
+	copy(images, cache.images)
+	return images, nil
+}
+
+/*
+ * Returns the list of Docker containers.
+ *
+ * If "all" is false, only the running containers are returned.
+ */
+func (cache *DockerCache) Containers(ctx context.Context, all bool) ([]docker.APIContainers, error) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	if !cache.synced {
+		start := time.Now()
+		containers, err := cache.docker.ListContainers(docker.ListContainersOptions{All: all, Context: ctx})
+		ObserveDockerCall("ListContainers", start, err)
+		return containers, err
+	}
+	containers := make([]docker.APIContainers, 0, len(cache.containers))
+	for _, container := range cache.containers {
+		if all || IsContainerRunning(container) {
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
+
+/*
+ * Watches the Docker events until the cache is stopped.
+ *
+ * When the events stream is lost, a new listener is registered and
+ * the whole cache is re-synchronized, as events may have been missed
+ * in the meantime.
+ */
+func (cache *DockerCache) watch() {
+	for {
+		listener := make(chan *docker.APIEvents, 16)
+		if err := cache.docker.AddEventListener(listener); err != nil {
+			log.Printf("Error when listening to Docker events : %v", err)
+			if !cache.sleep(eventsReconnectDelay) {
+				return
+			}
+			continue
+		}
+
+		if !cache.consume(listener) {
+			cache.docker.RemoveEventListener(listener)
+			return
+		}
+		cache.docker.RemoveEventListener(listener)
+		log.Println("Docker events stream lost, re-synchronizing the cache")
+
+		if !cache.sleep(eventsReconnectDelay) {
+			return
+		}
+		cache.Resync()
+	}
+}
+
+/*
+ * Applies the events received on the given listener.
+ *
+ * Returns false if the cache was stopped, true if the events stream
+ * was lost.
+ */
+func (cache *DockerCache) consume(listener chan *docker.APIEvents) bool {
+	for {
+		select {
+		case <-cache.stop:
+			return false
+		case event, ok := <-listener:
+			if !ok || event == docker.EOFEvent {
+				return true
+			}
+			refreshImages, refreshContainers := ClassifyEvent(event)
+			if refreshImages {
+				cache.RefreshImages()
+			}
+			if refreshContainers {
+				cache.RefreshContainers()
+			}
+		}
+	}
+}
+
+/*
+ * Waits for the given duration.
+ *
+ * Returns false if the cache was stopped in the meantime.
+ */
+func (cache *DockerCache) sleep(duration time.Duration) bool {
+	select {
+	case <-cache.stop:
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
+func (cache *DockerCache) listImages() ([]docker.APIImages, error) {
+	start := time.Now()
+	images, err := cache.docker.ListImages(docker.ListImagesOptions{All: false})
+	ObserveDockerCall("ListImages", start, err)
+	return images, err
+}
+
+func (cache *DockerCache) listContainers() ([]docker.APIContainers, error) {
+	start := time.Now()
+	containers, err := cache.docker.ListContainers(docker.ListContainersOptions{All: true})
+	ObserveDockerCall("ListContainers", start, err)
+	return containers, err
+}
+
+/*
+ * Indicates which parts of the cache are affected by the given Docker event.
+ *
+ * Recent Docker daemons give the type of the event's object. Older ones
+ * only give its status, so the status is used as a fallback.
+ */
+func ClassifyEvent(event *docker.APIEvents) (refreshImages bool, refreshContainers bool) {
+	switch event.Type {
+	case "image":
+		return true, false
+	case "container":
+		/*
+		 * Committing a container creates a new image.
+		 */
+		return event.Action == "commit", true
+	case "":
+		break
+	default:
+		return false, false
+	}
+
+	switch event.Status {
+	case "delete", "import", "load", "pull", "push", "save", "tag", "untag":
+		return true, false
+	case "commit":
+		return true, true
+	case "attach", "create", "destroy", "die", "kill", "oom", "pause",
+		"rename", "restart", "start", "stop", "unpause", "update":
+		return false, true
+	}
+	return false, false
+}
+
+/*
+ * Returns a boolean indicating whether or not the given container is running.
+ */
+func IsContainerRunning(container docker.APIContainers) bool {
+	return strings.HasPrefix(container.Status, "Up")
+}
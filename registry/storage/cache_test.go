@@ -0,0 +1,268 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eogile/agilestack-core/registry/storage"
+	"github.com/fsouza/go-dockerclient"
+)
+
+/*
+ * Docker daemon serving the given images and no container, and sending
+ * the events given to the current events stream.
+ */
+type fakeDaemon struct {
+	mutex        sync.Mutex
+	images       []docker.APIImages
+	imageLists   int
+	eventStreams int
+	events       chan *docker.APIEvents
+}
+
+func newFakeDaemon(images ...docker.APIImages) *fakeDaemon {
+	return &fakeDaemon{images: images, events: make(chan *docker.APIEvents)}
+}
+
+func (daemon *fakeDaemon) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	daemon.mutex.Lock()
+	switch request.URL.Path {
+	case "/images/json":
+		daemon.imageLists++
+		images := daemon.images
+		daemon.mutex.Unlock()
+		json.NewEncoder(writer).Encode(images)
+	case "/containers/json":
+		daemon.mutex.Unlock()
+		writer.Write([]byte("[]"))
+	case "/events":
+		daemon.eventStreams++
+		events := daemon.events
+		daemon.mutex.Unlock()
+		daemon.streamEvents(writer, request, events)
+	default:
+		daemon.mutex.Unlock()
+		http.NotFound(writer, request)
+	}
+}
+
+/*
+ * Writes the events until the stream is lost or the client leaves.
+ */
+func (daemon *fakeDaemon) streamEvents(writer http.ResponseWriter, request *http.Request, events chan *docker.APIEvents) {
+	writer.WriteHeader(http.StatusOK)
+	writer.(http.Flusher).Flush()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			json.NewEncoder(writer).Encode(event)
+			writer.(http.Flusher).Flush()
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+/*
+ * Changes the images, without any event.
+ */
+func (daemon *fakeDaemon) setImages(images ...docker.APIImages) {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	daemon.images = images
+}
+
+/*
+ * Sends an event on the current events stream, waiting for a client to
+ * listen to it.
+ */
+func (daemon *fakeDaemon) sendEvent(event *docker.APIEvents) {
+	daemon.mutex.Lock()
+	events := daemon.events
+	daemon.mutex.Unlock()
+	events <- event
+}
+
+/*
+ * Ends the current events stream, as when the connection to the daemon
+ * is lost. The next streams get the next events.
+ */
+func (daemon *fakeDaemon) loseEvents() {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	close(daemon.events)
+	daemon.events = make(chan *docker.APIEvents)
+}
+
+func (daemon *fakeDaemon) counts() (imageLists int, eventStreams int) {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	return daemon.imageLists, daemon.eventStreams
+}
+
+/*
+ * Starts a cache of the given daemon. Returns the cache and the function
+ * stopping both.
+ */
+func startCache(t *testing.T, daemon *fakeDaemon) (*storage.DockerCache, func()) {
+	server := httptest.NewServer(daemon)
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	cache := storage.NewDockerCache(client)
+	if err := cache.Start(); err != nil {
+		server.Close()
+		t.Fatalf("Error while synchronizing the cache : %v", err)
+	}
+	return cache, func() {
+		cache.Stop()
+		server.CloseClientConnections()
+		server.Close()
+	}
+}
+
+/*
+ * Waits until the cache lists the given number of images.
+ */
+func waitForImages(t *testing.T, cache *storage.DockerCache, expected int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		images, err := cache.Images(context.Background())
+		if err == nil && len(images) == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The cache should list %d images : %v, %v", expected, images, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+/*
+ * Tests that the listings are served by the cache, which is refreshed
+ * by the Docker events.
+ */
+func TestCacheServesListings(t *testing.T) {
+	daemon := newFakeDaemon(docker.APIImages{ID: "todo"})
+	cache, stop := startCache(t, daemon)
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		if images, err := cache.Images(context.Background()); err != nil || len(images) != 1 {
+			t.Fatalf("Invalid images : %v, %v", images, err)
+		}
+	}
+	if imageLists, _ := daemon.counts(); imageLists != 1 {
+		t.Errorf("The images should be listed once from the daemon, not %d times", imageLists)
+	}
+
+	daemon.setImages(docker.APIImages{ID: "todo"}, docker.APIImages{ID: "agenda"})
+	daemon.sendEvent(&docker.APIEvents{Type: "image", Action: "tag", Time: time.Now().Unix()})
+	waitForImages(t, cache, 2)
+}
+
+/*
+ * Tests that the cache is re-synchronized once the events stream is
+ * re-established, as events may have been missed in the meantime.
+ */
+func TestCacheResyncsAfterReconnection(t *testing.T) {
+	daemon := newFakeDaemon(docker.APIImages{ID: "todo"})
+	cache, stop := startCache(t, daemon)
+	defer stop()
+
+	for _, eventStreams := daemon.counts(); eventStreams == 0; _, eventStreams = daemon.counts() {
+		time.Sleep(20 * time.Millisecond)
+	}
+	daemon.setImages(docker.APIImages{ID: "todo"}, docker.APIImages{ID: "agenda"})
+	daemon.loseEvents()
+	waitForImages(t, cache, 2)
+	if _, eventStreams := daemon.counts(); eventStreams < 2 {
+		t.Errorf("The events stream should have been re-established : %d streams", eventStreams)
+	}
+}
+
+/*
+ * Tests that a stopped cache no longer listens to the Docker events,
+ * and that it can be stopped more than once.
+ */
+func TestCacheStopsWatching(t *testing.T) {
+	daemon := newFakeDaemon(docker.APIImages{ID: "todo"})
+	cache, stop := startCache(t, daemon)
+	defer stop()
+
+	for _, eventStreams := daemon.counts(); eventStreams == 0; _, eventStreams = daemon.counts() {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cache.Stop()
+	cache.Stop()
+
+	daemon.loseEvents()
+	time.Sleep(3 * time.Second)
+	if imageLists, eventStreams := daemon.counts(); imageLists != 1 || eventStreams != 1 {
+		t.Errorf("The stopped cache should not re-synchronize : %d listings, %d streams", imageLists, eventStreams)
+	}
+}
+
+func TestClassifyImageEvents(t *testing.T) {
+	events := []*docker.APIEvents{
+		&docker.APIEvents{Type: "image", Action: "tag"},
+		&docker.APIEvents{Type: "image", Action: "delete"},
+		&docker.APIEvents{Status: "untag"},
+		&docker.APIEvents{Status: "pull"},
+	}
+	for _, event := range events {
+		doTestClassifyEvent(t, event, true, false)
+	}
+}
+
+func TestClassifyContainerEvents(t *testing.T) {
+	events := []*docker.APIEvents{
+		&docker.APIEvents{Type: "container", Action: "start"},
+		&docker.APIEvents{Type: "container", Action: "destroy"},
+		&docker.APIEvents{Status: "die"},
+		&docker.APIEvents{Status: "create"},
+	}
+	for _, event := range events {
+		doTestClassifyEvent(t, event, false, true)
+	}
+}
+
+func TestClassifyCommitEvents(t *testing.T) {
+	doTestClassifyEvent(t, &docker.APIEvents{Type: "container", Action: "commit"}, true, true)
+	doTestClassifyEvent(t, &docker.APIEvents{Status: "commit"}, true, true)
+}
+
+func TestClassifyIgnoredEvents(t *testing.T) {
+	doTestClassifyEvent(t, &docker.APIEvents{Type: "network", Action: "connect"}, false, false)
+	doTestClassifyEvent(t, &docker.APIEvents{Type: "volume", Action: "mount"}, false, false)
+	doTestClassifyEvent(t, &docker.APIEvents{Status: "exec_start"}, false, false)
+}
+
+func TestIsContainerRunning(t *testing.T) {
+	if !storage.IsContainerRunning(docker.APIContainers{Status: "Up 3 minutes"}) {
+		t.Error("The container should be considered as running")
+	}
+	if storage.IsContainerRunning(docker.APIContainers{Status: "Exited (0) 2 minutes ago"}) {
+		t.Error("The container should not be considered as running")
+	}
+}
+
+func doTestClassifyEvent(t *testing.T, event *docker.APIEvents, expectedImages bool, expectedContainers bool) {
+	refreshImages, refreshContainers := storage.ClassifyEvent(event)
+	if refreshImages != expectedImages {
+		t.Errorf("Invalid images refresh for event %v: %t", event, refreshImages)
+	}
+	if refreshContainers != expectedContainers {
+		t.Errorf("Invalid containers refresh for event %v: %t", event, refreshContainers)
+	}
+}
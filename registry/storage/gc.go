@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+/*
+ * What the garbage collection removes.
+ */
+type GCPolicy struct {
+	/*
+	 * Number of versions of each plugin's image to keep. The older
+	 * versions are removed, except the ones used by a container.
+	 * Zero disables the removal of the images.
+	 */
+	KeepVersions int
+
+	/*
+	 * Minimum age of the plugin containers that are not running
+	 * (exited, dead, or created by a failed installation) to remove.
+	 * Zero disables the removal of the containers.
+	 */
+	StoppedContainerAge time.Duration
+
+	/*
+	 * Whether or not the untagged images, left by the builds and the
+	 * removal of the previous versions, are removed.
+	 */
+	RemoveDanglingImages bool
+}
+
+func DefaultGCPolicy() GCPolicy {
+	return GCPolicy{
+		KeepVersions:         3,
+		StoppedContainerAge:  24 * time.Hour,
+		RemoveDanglingImages: true,
+	}
+}
+
+/*
+ * Containers and images to remove according to a policy.
+ */
+type GCPlan struct {
+	Containers      []docker.APIContainers
+	ImageReferences []string
+
+	/*
+	 * Identifiers and names of the images used by the containers
+	 * that are kept.
+	 */
+	usedImages map[string]bool
+}
+
+/*
+ * Returns a boolean indicating whether or not the given image is used by
+ * a container that is kept. Such an image must not be removed, even if it
+ * is dangling.
+ */
+func (plan GCPlan) IsImageUsed(imageID string) bool {
+	if plan.usedImages[imageID] {
+		return true
+	}
+	for reference := range plan.usedImages {
+		if IsImageIDReference(imageID, reference) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Minimum length of the shortened image identifiers.
+ */
+const shortImageIDLength = 12
+
+/*
+ * Returns a boolean indicating whether or not the given reference is the
+ * identifier, possibly shortened, of the image having the given
+ * identifier.
+ */
+func IsImageIDReference(imageID string, reference string) bool {
+	id := strings.TrimPrefix(imageID, "sha256:")
+	reference = strings.TrimPrefix(reference, "sha256:")
+	return len(reference) >= shortImageIDLength && strings.HasPrefix(id, reference)
+}
+
+/*
+ * Returns a boolean indicating whether or not the given image is the one
+ * a container gives as its image : the containers give the reference
+ * they were created with, or the image's identifier once the image lost
+ * its tags.
+ */
+func IsContainerImage(image docker.APIImages, reference string) bool {
+	if image.ID == reference || IsImageIDReference(image.ID, reference) {
+		return true
+	}
+	for _, repoTag := range image.RepoTags {
+		if repoTag == reference || repoTag == reference+":latest" {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Computes what the garbage collection removes from the given containers
+ * (including the stopped ones) and images.
+ *
+ * The dangling images are not part of the plan : they are listed when the
+ * plan is executed.
+ */
+func PlanGarbageCollection(images []docker.APIImages, containers []docker.APIContainers, policy GCPolicy, now time.Time) GCPlan {
+	plan := GCPlan{usedImages: make(map[string]bool)}
+	for _, container := range containers {
+		if isStaleContainer(container, policy, now) {
+			plan.Containers = append(plan.Containers, container)
+		} else {
+			plan.markUsed(container.Image, images)
+		}
+	}
+
+	if policy.KeepVersions <= 0 {
+		return plan
+	}
+
+	versions := make(map[string][]docker.APIImages)
+	for _, image := range images {
+		for _, pluginName := range pluginNames(image) {
+			versions[pluginName] = append(versions[pluginName], image)
+		}
+	}
+
+	names := make([]string, 0, len(versions))
+	for pluginName := range versions {
+		names = append(names, pluginName)
+	}
+	sort.Strings(names)
+
+	for _, pluginName := range names {
+		pluginImages := versions[pluginName]
+		sort.Sort(byCreationDate(pluginImages))
+		if len(pluginImages) <= policy.KeepVersions {
+			continue
+		}
+
+		for _, image := range pluginImages[policy.KeepVersions:] {
+			if isImageUsed(image, plan.usedImages) {
+				continue
+			}
+			for _, repoTag := range image.RepoTags {
+				if GetPluginName(repoTag) == pluginName {
+					plan.ImageReferences = append(plan.ImageReferences, repoTag)
+				}
+			}
+		}
+	}
+	return plan
+}
+
+/*
+ * Marks the image of a kept container as used, by the reference the
+ * container gives and by the identifier of the listed image it resolves
+ * to.
+ */
+func (plan GCPlan) markUsed(reference string, images []docker.APIImages) {
+	plan.usedImages[reference] = true
+	for _, image := range images {
+		if IsContainerImage(image, reference) {
+			plan.usedImages[image.ID] = true
+		}
+	}
+}
+
+/*
+ * Returns a boolean indicating whether or not the given container is a
+ * plugin container that is not running and is older than the policy's age.
+ *
+ * The restarting containers are kept : they belong to installed plugins.
+ */
+func isStaleContainer(container docker.APIContainers, policy GCPolicy, now time.Time) bool {
+	if policy.StoppedContainerAge <= 0 || !IsContainerAPlugin(container) {
+		return false
+	}
+	if IsContainerRunning(container) || strings.HasPrefix(container.Status, "Restarting") {
+		return false
+	}
+	return now.Sub(time.Unix(container.Created, 0)) >= policy.StoppedContainerAge
+}
+
+func isImageUsed(image docker.APIImages, usedImages map[string]bool) bool {
+	if usedImages[image.ID] {
+		return true
+	}
+	for _, repoTag := range image.RepoTags {
+		if usedImages[repoTag] || usedImages[strings.TrimSuffix(repoTag, ":latest")] {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Returns the names of the plugins whose image is the given image.
+ */
+func pluginNames(image docker.APIImages) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, repoTag := range image.RepoTags {
+		name := GetPluginName(repoTag)
+		if strings.HasPrefix(name, "agilestack-") && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	return names
+}
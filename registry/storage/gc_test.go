@@ -0,0 +1,110 @@
+package storage_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eogile/agilestack-core/registry/storage"
+	"github.com/fsouza/go-dockerclient"
+)
+
+var gcNow = time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestPlanGarbageCollectionContainers(t *testing.T) {
+	hoursAgo := func(hours int) int64 {
+		return gcNow.Add(-time.Duration(hours) * time.Hour).Unix()
+	}
+	containers := []docker.APIContainers{
+		{ID: "1", Names: []string{"/agilestack-running"}, Status: "Up 3 days", Created: hoursAgo(72)},
+		{ID: "2", Names: []string{"/agilestack-exited"}, Status: "Exited (1) 2 days ago", Created: hoursAgo(48)},
+		{ID: "3", Names: []string{"/agilestack-recent"}, Status: "Exited (0) 1 hour ago", Created: hoursAgo(2)},
+		{ID: "4", Names: []string{"/agilestack-failed"}, Status: "Created", Created: hoursAgo(30)},
+		{ID: "5", Names: []string{"/agilestack-restarting"}, Status: "Restarting (1) 5 seconds ago", Created: hoursAgo(30)},
+		{ID: "6", Names: []string{"/other"}, Status: "Exited (0) 3 days ago", Created: hoursAgo(72)},
+	}
+
+	plan := storage.PlanGarbageCollection(nil, containers, storage.DefaultGCPolicy(), gcNow)
+	if ids := containerIDs(plan.Containers); !reflect.DeepEqual(ids, []string{"2", "4"}) {
+		t.Errorf("Invalid containers to remove : %v", ids)
+	}
+
+	policy := storage.DefaultGCPolicy()
+	policy.StoppedContainerAge = 0
+	plan = storage.PlanGarbageCollection(nil, containers, policy, gcNow)
+	if len(plan.Containers) != 0 {
+		t.Errorf("No container should be removed : %v", containerIDs(plan.Containers))
+	}
+}
+
+func TestPlanGarbageCollectionImages(t *testing.T) {
+	images := []docker.APIImages{
+		{ID: "v1", Created: 100, RepoTags: []string{"agilestack-todo:1.0"}},
+		{ID: "v2", Created: 200, RepoTags: []string{"agilestack-todo:2.0"}},
+		{ID: "v3", Created: 300, RepoTags: []string{"agilestack-todo:3.0", "registry.eogile.com/agilestack-todo:3.0"}},
+		{ID: "v4", Created: 400, RepoTags: []string{"agilestack-todo:latest"}},
+		{ID: "p1", Created: 100, RepoTags: []string{"agilestack-proxy:latest"}},
+		{ID: "o1", Created: 50, RepoTags: []string{"postgres:9.5"}},
+		{ID: "o2", Created: 60, RepoTags: []string{"postgres:9.4"}},
+	}
+	containers := []docker.APIContainers{
+		{ID: "c1", Names: []string{"/agilestack-todo"}, Status: "Up 3 days", Image: "agilestack-todo:1.0"},
+		{ID: "c2", Names: []string{"/agilestack-old"}, Status: "Exited (0) 3 days ago", Image: "agilestack-todo:2.0", Created: gcNow.Add(-72 * time.Hour).Unix()},
+	}
+
+	policy := storage.DefaultGCPolicy()
+	policy.KeepVersions = 1
+	plan := storage.PlanGarbageCollection(images, containers, policy, gcNow)
+
+	/*
+	 * v1 is used by a running container. v2 is used by a container
+	 * that is removed.
+	 */
+	expected := []string{"agilestack-todo:3.0", "registry.eogile.com/agilestack-todo:3.0", "agilestack-todo:2.0"}
+	if !reflect.DeepEqual(plan.ImageReferences, expected) {
+		t.Errorf("Invalid images to remove : %v", plan.ImageReferences)
+	}
+	if !plan.IsImageUsed("v1") || plan.IsImageUsed("v2") {
+		t.Error("Only v1 should be used")
+	}
+
+	policy.KeepVersions = 0
+	plan = storage.PlanGarbageCollection(images, containers, policy, gcNow)
+	if len(plan.ImageReferences) != 0 {
+		t.Errorf("No image should be removed : %v", plan.ImageReferences)
+	}
+}
+
+/*
+ * Tests that the image of a container is kept once a rebuild moved its
+ * tag to a new image : the container then gives the image's identifier.
+ */
+func TestPlanGarbageCollectionUntaggedImages(t *testing.T) {
+	previous := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	images := []docker.APIImages{
+		{ID: "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210", Created: 200, RepoTags: []string{"agilestack-todo:latest"}},
+	}
+	containers := []docker.APIContainers{
+		{ID: "c1", Names: []string{"/agilestack-todo"}, Status: "Up 3 days", Image: previous},
+		{ID: "c2", Names: []string{"/agilestack-agenda"}, Status: "Up 3 days", Image: "fedcba987654"},
+	}
+
+	plan := storage.PlanGarbageCollection(images, containers, storage.DefaultGCPolicy(), gcNow)
+	if !plan.IsImageUsed(previous) {
+		t.Error("The untagged image of a running container should be used")
+	}
+	if !plan.IsImageUsed(images[0].ID) {
+		t.Error("The image given by a shortened identifier should be used")
+	}
+	if plan.IsImageUsed("sha256:0123") {
+		t.Error("A too short identifier should not match")
+	}
+}
+
+func containerIDs(containers []docker.APIContainers) []string {
+	var ids []string
+	for _, container := range containers {
+		ids = append(ids, container.ID)
+	}
+	return ids
+}
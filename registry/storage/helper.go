@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"log"
+	"sort"
 	"strings"
 
 	pb "github.com/eogile/agilestack-core/proto"
@@ -10,14 +12,34 @@ import (
 
 type DockerHelper struct {
 	docker *docker.Client
+	cache  *DockerCache
 }
 
+/*
+ * Creates a helper whose listings are served by a cache of the Docker
+ * images and containers.
+ *
+ * The cache is kept up to date from the Docker events API.
+ */
 func NewDockerHelper(docker *docker.Client) *DockerHelper {
+	cache := NewDockerCache(docker)
+	if err := cache.Start(); err != nil {
+		log.Printf("Error while synchronizing the Docker cache : %v", err)
+	}
+
 	return &DockerHelper{
 		docker: docker,
+		cache:  cache,
 	}
 }
 
+/*
+ * Returns the cache used to list the images and containers.
+ */
+func (h *DockerHelper) Cache() *DockerCache {
+	return h.cache
+}
+
 /*
  * Returns a boolean indicating whether or not the given image is a
  * AgileStack plugin or not.
@@ -54,12 +76,12 @@ func (h *DockerHelper) GetPluginName(image docker.APIImages) string {
  * The match between an image and a plugin is computed on the image's
  * name.
  */
-func (h *DockerHelper) ImageFromPlugin(pluginName string) *docker.APIImages {
+func (h *DockerHelper) ImageFromPlugin(ctx context.Context, pluginName string) *docker.APIImages {
 
 	/*
 	 * Listing the images
 	 */
-	images, imageErr := h.ListImages()
+	images, imageErr := h.ListImages(ctx)
 	if imageErr != nil {
 		log.Printf("Error when listing Docker images : %v", imageErr)
 		return nil
@@ -73,12 +95,82 @@ func (h *DockerHelper) ImageFromPlugin(pluginName string) *docker.APIImages {
 	return nil
 }
 
+/*
+ * Returns the references (repository and tag) of the images of the given
+ * plugin, whatever their registry.
+ *
+ * If "oldVersionsOnly" is true, the references of the most recent image
+ * and of the images used by the given containers are skipped, so that
+ * only the previous versions that are not used are returned : after a
+ * rebuild, the plugin's container still uses the previous image.
+ */
+func PluginImageReferences(images []docker.APIImages, containers []docker.APIContainers, pluginName string, oldVersionsOnly bool) []string {
+	var pluginImages []docker.APIImages
+	for _, image := range images {
+		for _, repoTag := range image.RepoTags {
+			if GetPluginName(repoTag) == pluginName {
+				pluginImages = append(pluginImages, image)
+				break
+			}
+		}
+	}
+
+	sort.Sort(byCreationDate(pluginImages))
+	if oldVersionsOnly && len(pluginImages) > 0 {
+		pluginImages = unusedImages(pluginImages[1:], containers)
+	}
+
+	var references []string
+	for _, image := range pluginImages {
+		for _, repoTag := range image.RepoTags {
+			if GetPluginName(repoTag) == pluginName {
+				references = append(references, repoTag)
+			}
+		}
+	}
+	return references
+}
+
+/*
+ * Returns the given images that none of the given containers uses.
+ */
+func unusedImages(images []docker.APIImages, containers []docker.APIContainers) []docker.APIImages {
+	var unused []docker.APIImages
+	for _, image := range images {
+		used := false
+		for _, container := range containers {
+			used = used || IsContainerImage(image, container.Image)
+		}
+		if !used {
+			unused = append(unused, image)
+		}
+	}
+	return unused
+}
+
+/*
+ * Sorts the images from the most recent to the oldest.
+ */
+type byCreationDate []docker.APIImages
+
+func (list byCreationDate) Len() int           { return len(list) }
+func (list byCreationDate) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+func (list byCreationDate) Less(i, j int) bool { return list[i].Created > list[j].Created }
+
 /*
  * Returns the list of top-level Docker images.
  */
-func (h *DockerHelper) ListImages() ([]docker.APIImages, error) {
-	listOps := docker.ListImagesOptions{All: false}
-	return h.docker.ListImages(listOps)
+func (h *DockerHelper) ListImages(ctx context.Context) ([]docker.APIImages, error) {
+	return h.cache.Images(ctx)
+}
+
+/*
+ * Returns the list of Docker containers.
+ *
+ * If "all" is false, only the running containers are returned.
+ */
+func (h *DockerHelper) ListContainers(ctx context.Context, all bool) ([]docker.APIContainers, error) {
+	return h.cache.Containers(ctx, all)
 }
 
 /*
@@ -1,6 +1,7 @@
 package storage_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/eogile/agilestack-core/registry/storage"
@@ -88,3 +89,46 @@ func doTestGetPluginName(t *testing.T, imageName string, expectedName string) {
 		t.Errorf("Invalid plugin name: %s\n", pluginName)
 	}
 }
+
+func TestPluginImageReferences(t *testing.T) {
+	images := []docker.APIImages{
+		{ID: "1", Created: 100, RepoTags: []string{"agilestack-todo:1.0"}},
+		{ID: "2", Created: 300, RepoTags: []string{"agilestack-todo:latest", "registry.eogile.com/agilestack-todo:2.0", "other:latest"}},
+		{ID: "3", Created: 200, RepoTags: []string{"agilestack-todo:1.5"}},
+		{ID: "4", Created: 400, RepoTags: []string{"agilestack-todo-extra:latest"}},
+		{ID: "5", Created: 500, RepoTags: []string{"<none>:<none>"}},
+	}
+
+	references := storage.PluginImageReferences(images, nil, "agilestack-todo", false)
+	expected := []string{"agilestack-todo:latest", "registry.eogile.com/agilestack-todo:2.0",
+		"agilestack-todo:1.5", "agilestack-todo:1.0"}
+	if !reflect.DeepEqual(references, expected) {
+		t.Errorf("Invalid references : %v", references)
+	}
+
+	references = storage.PluginImageReferences(images, nil, "agilestack-todo", true)
+	expected = []string{"agilestack-todo:1.5", "agilestack-todo:1.0"}
+	if !reflect.DeepEqual(references, expected) {
+		t.Errorf("Invalid references of the old versions : %v", references)
+	}
+
+	if references := storage.PluginImageReferences(images, nil, "agilestack-unknown", true); len(references) != 0 {
+		t.Errorf("No reference expected : %v", references)
+	}
+
+	/*
+	 * After a rebuild, the installed container still uses the previous
+	 * version, given by its identifier once untagged.
+	 */
+	containers := []docker.APIContainers{{ID: "c1", Names: []string{"/agilestack-todo"}, Image: "agilestack-todo:1.5"}}
+	references = storage.PluginImageReferences(images, containers, "agilestack-todo", true)
+	if !reflect.DeepEqual(references, []string{"agilestack-todo:1.0"}) {
+		t.Errorf("The image used by the container should be kept : %v", references)
+	}
+	images[0].ID = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	containers[0].Image = images[0].ID
+	references = storage.PluginImageReferences(images, containers, "agilestack-todo", true)
+	if !reflect.DeepEqual(references, []string{"agilestack-todo:1.5"}) {
+		t.Errorf("The image used by the container should be kept : %v", references)
+	}
+}
@@ -0,0 +1,224 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+/*
+ * Name of the file describing a template, at the root of the
+ * template's directory.
+ *
+ * A directory without this file is not a template.
+ */
+const DescriptorFile = "template.json"
+
+/*
+ * Content of the descriptor file of a template.
+ */
+type Descriptor struct {
+	/*
+	 * Name of the template. Defaults to the name of the template's directory.
+	 */
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Parameters  []Parameter `json:"parameters"`
+}
+
+/*
+ * Value given when creating a plugin from a template, and available
+ * in the template as {{.Parameters.<name>}}.
+ */
+type Parameter struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     string `json:"default"`
+	Required    bool   `json:"required"`
+
+	/*
+	 * Regular expression the whole value must match, if any. The values
+	 * written into a Dockerfile must be restricted, so that they cannot
+	 * add instructions to it.
+	 */
+	Pattern string `json:"pattern"`
+}
+
+/*
+ * Checks the given value of the parameter : it must not contain any
+ * control character, such as a line feed, and must match the
+ * parameter's pattern.
+ */
+func (parameter Parameter) Validate(value string) error {
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("The value of parameter %s must not contain control characters", parameter.Name)
+	}
+	if parameter.Pattern == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile("^(?:" + parameter.Pattern + ")$")
+	if err != nil {
+		return fmt.Errorf("Invalid pattern for parameter %s : %v", parameter.Name, err)
+	}
+	if !pattern.MatchString(value) {
+		return fmt.Errorf("The value of parameter %s must match %s", parameter.Name, parameter.Pattern)
+	}
+	return nil
+}
+
+type Template struct {
+	Descriptor
+
+	/*
+	 * Directory containing the template's files.
+	 */
+	Directory string
+}
+
+/*
+ * Templates available under a root directory, one template per
+ * sub-directory.
+ *
+ * The root directory is scanned on every call, so that templates can be
+ * added without restarting core.
+ */
+type Registry struct {
+	root string
+}
+
+func NewRegistry(root string) *Registry {
+	return &Registry{root: root}
+}
+
+/*
+ * Returns the available templates sorted by name.
+ *
+ * Templates whose descriptor is invalid are skipped, as well as the
+ * templates having the same name as a template of a previous directory,
+ * in the alphabetical order.
+ */
+func (registry *Registry) List() ([]*Template, error) {
+	entries, err := ioutil.ReadDir(registry.root)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*Template, 0, len(entries))
+	directories := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		template, err := registry.load(entry.Name())
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Invalid template %s : %v", entry.Name(), err)
+			continue
+		}
+		if directory, ok := directories[template.Name]; ok {
+			log.Printf("Template %s skipped : its name %s is already the one of %s", entry.Name(), template.Name, directory)
+			continue
+		}
+		directories[template.Name] = entry.Name()
+		list = append(list, template)
+	}
+
+	sort.Sort(byName(list))
+	return list, nil
+}
+
+/*
+ * Returns the template having the given name, as reported by List : the
+ * name of its descriptor, or of its directory when the descriptor has none.
+ */
+func (registry *Registry) Get(name string) (*Template, error) {
+	if name == "" {
+		return nil, fmt.Errorf("Invalid template name : %s", name)
+	}
+
+	list, err := registry.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, template := range list {
+		if template.Name == name {
+			return template, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown template : %s", name)
+}
+
+func (registry *Registry) load(directoryName string) (*Template, error) {
+	directory := filepath.Join(registry.root, directoryName)
+	content, err := ioutil.ReadFile(filepath.Join(directory, DescriptorFile))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &Template{Directory: directory}
+	if err := json.Unmarshal(content, &template.Descriptor); err != nil {
+		return nil, err
+	}
+	if template.Name == "" {
+		template.Name = directoryName
+	}
+	for _, parameter := range template.Parameters {
+		if _, err := regexp.Compile(parameter.Pattern); err != nil {
+			return nil, fmt.Errorf("Invalid pattern for parameter %s : %v", parameter.Name, err)
+		}
+	}
+	return template, nil
+}
+
+/*
+ * Computes the values of the template's parameters from the given ones :
+ * - Missing values are replaced by the parameters' defaults.
+ * - An error is returned if a required parameter has no value, if a
+ *   value is invalid (see "Parameter.Validate"), or if a value is given
+ *   for an unknown parameter.
+ */
+func (template *Template) ResolveParameters(values map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(template.Parameters))
+	for _, parameter := range template.Parameters {
+		value, ok := values[parameter.Name]
+		if !ok || value == "" {
+			if parameter.Required && parameter.Default == "" {
+				return nil, fmt.Errorf("Missing value for parameter %s", parameter.Name)
+			}
+			value = parameter.Default
+		}
+		if err := parameter.Validate(value); err != nil {
+			return nil, err
+		}
+		resolved[parameter.Name] = value
+	}
+
+	for name := range values {
+		if _, ok := resolved[name]; !ok {
+			return nil, fmt.Errorf("Unknown parameter %s for template %s", name, template.Name)
+		}
+	}
+	return resolved, nil
+}
+
+/*
+ * Renders the template into the given workspace.
+ */
+func (template *Template) Render(workspace string, data Data) error {
+	return Render(template.Directory, workspace, data)
+}
+
+type byName []*Template
+
+func (list byName) Len() int           { return len(list) }
+func (list byName) Swap(i, j int)      { list[i], list[j] = list[j], list[i] }
+func (list byName) Less(i, j int) bool { return list[i].Name < list[j].Name }
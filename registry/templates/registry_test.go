@@ -0,0 +1,281 @@
+package templates_test
+
+import (
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eogile/agilestack-core/registry/templates"
+)
+
+func TestListTemplates(t *testing.T) {
+	root := createTemplate(t, map[string]string{
+		"static-site/template.json": `{"description": "Static web site"}`,
+		"static-site/index.html":    "<html></html>",
+		"go-service/template.json": `{
+			"name": "go-service",
+			"description": "Go micro-service",
+			"parameters": [
+				{"name": "goVersion", "default": "1.7"},
+				{"name": "owner", "required": true}
+			]
+		}`,
+		"not-a-template/README.md": "No descriptor",
+		"broken/template.json":     "{",
+	})
+	defer os.RemoveAll(root)
+
+	list, err := templates.NewRegistry(root).List()
+	if err != nil {
+		t.Fatalf("Error while listing the templates : %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Invalid number of templates : %d", len(list))
+	}
+
+	if list[0].Name != "go-service" || len(list[0].Parameters) != 2 {
+		t.Errorf("Invalid first template : %v", list[0])
+	}
+	if list[1].Name != "static-site" || list[1].Description != "Static web site" {
+		t.Errorf("Invalid second template : %v", list[1])
+	}
+	if list[1].Directory != filepath.Join(root, "static-site") {
+		t.Errorf("Invalid directory for the second template : %s", list[1].Directory)
+	}
+}
+
+func TestGetTemplate(t *testing.T) {
+	root := createTemplate(t, map[string]string{
+		"static-site/template.json": `{}`,
+	})
+	defer os.RemoveAll(root)
+	registry := templates.NewRegistry(root)
+
+	if template, err := registry.Get("static-site"); err != nil || template.Name != "static-site" {
+		t.Errorf("Invalid template : %v, %v", template, err)
+	}
+	for _, name := range []string{"", "unknown", "../static-site", ".hidden"} {
+		if _, err := registry.Get(name); err == nil {
+			t.Errorf("Getting the template %q should fail", name)
+		}
+	}
+}
+
+/*
+ * Tests that the templates are got by the name reported by List, even when
+ * it differs from the name of their directory.
+ */
+func TestGetTemplateByListedName(t *testing.T) {
+	root := createTemplate(t, map[string]string{
+		"go/template.json":         `{"name": "go-service"}`,
+		"go-service/template.json": `{"name": "go-service-v2"}`,
+		"golang/template.json":     `{"name": "go-service"}`,
+	})
+	defer os.RemoveAll(root)
+	registry := templates.NewRegistry(root)
+
+	list, err := registry.List()
+	if err != nil {
+		t.Fatalf("Error while listing the templates : %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("The template having the name of another one should be skipped : %v", list)
+	}
+	for _, listed := range list {
+		template, err := registry.Get(listed.Name)
+		if err != nil || template.Directory != listed.Directory {
+			t.Errorf("Invalid template %s : %v, %v", listed.Name, template, err)
+		}
+	}
+	if template, err := registry.Get("go-service"); err != nil || template.Directory != filepath.Join(root, "go") {
+		t.Errorf("Invalid template : %v, %v", template, err)
+	}
+	if _, err := registry.Get("go"); err == nil {
+		t.Error("The templates should not be got by their directory when their name differs")
+	}
+}
+
+/*
+ * Tests that each template shipped in the "plugin-template" directory
+ * can be scaffolded with the default values of its parameters.
+ */
+func TestShippedTemplates(t *testing.T) {
+	registry := templates.NewRegistry(filepath.Join("..", "..", "plugin-template"))
+	checks := map[string]func(t *testing.T, workspace string){
+		"go-service": func(t *testing.T, workspace string) {
+			if _, err := parser.ParseFile(token.NewFileSet(), filepath.Join(workspace, "main.go"), nil, 0); err != nil {
+				t.Errorf("Invalid Go service : %v", err)
+			}
+		},
+		"js-frontend": func(t *testing.T, workspace string) {
+			content, err := ioutil.ReadFile(filepath.Join(workspace, "package.json"))
+			var description map[string]interface{}
+			if err == nil {
+				err = json.Unmarshal(content, &description)
+			}
+			if err != nil || description["name"] != "agilestack-todo" {
+				t.Errorf("Invalid package description : %v, %v", description, err)
+			}
+		},
+		"static-site": func(t *testing.T, workspace string) {
+			content, err := ioutil.ReadFile(filepath.Join(workspace, "public/index.html"))
+			if err != nil || !strings.Contains(string(content), "<title>todo</title>") {
+				t.Errorf("Invalid page : %s, %v", content, err)
+			}
+		},
+	}
+
+	list, err := registry.List()
+	if err != nil {
+		t.Fatalf("Error while listing the shipped templates : %v", err)
+	}
+	if len(list) != len(checks) {
+		t.Errorf("Invalid shipped templates : %v", list)
+	}
+	for name, check := range checks {
+		template, err := registry.Get(name)
+		if err != nil {
+			t.Errorf("Missing template %s : %v", name, err)
+			continue
+		}
+
+		data := templates.NewData("todo", "agilestack-todo", "/todo")
+		if data.Parameters, err = template.ResolveParameters(nil); err != nil {
+			t.Errorf("Invalid default parameters of %s : %v", name, err)
+			continue
+		}
+		workspace := tempDir(t)
+		defer os.RemoveAll(workspace)
+		if err := template.Render(workspace, data); err != nil {
+			t.Errorf("Error while rendering %s : %v", name, err)
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(workspace, "Dockerfile")); err != nil {
+			t.Errorf("The template %s has no Dockerfile : %v", name, err)
+		}
+		check(t, workspace)
+	}
+}
+
+func TestResolveParameters(t *testing.T) {
+	template := &templates.Template{
+		Descriptor: templates.Descriptor{
+			Name: "go-service",
+			Parameters: []templates.Parameter{
+				{Name: "goVersion", Default: "1.7"},
+				{Name: "owner", Required: true},
+				{Name: "port", Required: true, Default: "8080"},
+			},
+		},
+	}
+
+	values, err := template.ResolveParameters(map[string]string{"owner": "eogile"})
+	if err != nil {
+		t.Fatalf("Error while resolving the parameters : %v", err)
+	}
+	if values["goVersion"] != "1.7" || values["owner"] != "eogile" || values["port"] != "8080" {
+		t.Errorf("Invalid parameters : %v", values)
+	}
+
+	if _, err := template.ResolveParameters(map[string]string{}); err == nil {
+		t.Error("A missing required parameter should be rejected")
+	}
+	if _, err := template.ResolveParameters(map[string]string{"owner": "eogile", "color": "blue"}); err == nil {
+		t.Error("An unknown parameter should be rejected")
+	}
+	if _, err := template.ResolveParameters(map[string]string{"owner": "eogile\nRUN rm -rf /"}); err == nil {
+		t.Error("A value with a line feed should be rejected")
+	}
+}
+
+/*
+ * Tests that the values written into the Dockerfiles of the shipped
+ * templates cannot add instructions to them.
+ */
+func TestShippedTemplatesParameters(t *testing.T) {
+	registry := templates.NewRegistry(filepath.Join("..", "..", "plugin-template"))
+	invalid := map[string]map[string]string{
+		"go-service":  {"goVersion": "1.7 AS builder", "natsUrl": "nats://nats:4222 \\"},
+		"js-frontend": {"nodeVersion": "6\rRUN rm -rf /"},
+	}
+	for name, values := range invalid {
+		template, err := registry.Get(name)
+		if err != nil {
+			t.Fatalf("Missing template %s : %v", name, err)
+		}
+		for parameter, value := range values {
+			if _, err := template.ResolveParameters(map[string]string{parameter: value}); err == nil {
+				t.Errorf("The value %q of %s should be rejected", value, parameter)
+			}
+		}
+	}
+
+	template, err := registry.Get("go-service")
+	if err != nil {
+		t.Fatalf("Missing template go-service : %v", err)
+	}
+	values := map[string]string{"goVersion": "1.8-alpine", "natsUrl": "nats://user@nats.example.com:4222"}
+	if _, err := template.ResolveParameters(values); err != nil {
+		t.Errorf("The values should be valid : %v", err)
+	}
+}
+
+/*
+ * Tests that the URL of the plugin is escaped in the script of the
+ * "js-frontend" template.
+ */
+func TestShippedFrontendEscapesURL(t *testing.T) {
+	template, err := templates.NewRegistry(filepath.Join("..", "..", "plugin-template")).Get("js-frontend")
+	if err != nil {
+		t.Fatalf("Missing template js-frontend : %v", err)
+	}
+
+	workspace := tempDir(t)
+	defer os.RemoveAll(workspace)
+
+	data := templates.NewData("todo", "agilestack-todo", "/todo';alert(1);'")
+	if data.Parameters, err = template.ResolveParameters(nil); err != nil {
+		t.Fatalf("Invalid default parameters : %v", err)
+	}
+	if err := template.Render(workspace, data); err != nil {
+		t.Fatalf("Error while rendering the template : %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(workspace, "public/index.js"))
+	if err != nil || !strings.Contains(string(content), `url: '/todo\';alert(1);\''`) {
+		t.Errorf("The URL should be escaped : %s, %v", content, err)
+	}
+}
+
+func TestRenderSkipsDescriptor(t *testing.T) {
+	root := createTemplate(t, map[string]string{
+		"static-site/template.json":   `{"parameters": [{"name": "title"}]}`,
+		"static-site/index.html.tmpl": "<title>{{.Parameters.title}}</title>",
+	})
+	defer os.RemoveAll(root)
+
+	template, err := templates.NewRegistry(root).Get("static-site")
+	if err != nil {
+		t.Fatalf("Error while getting the template : %v", err)
+	}
+
+	workspace := tempDir(t)
+	defer os.RemoveAll(workspace)
+
+	data := templates.NewData("site", "agilestack-site", "/site")
+	data.Parameters["title"] = "My site"
+	if err := template.Render(workspace, data); err != nil {
+		t.Fatalf("Error while rendering the template : %v", err)
+	}
+
+	assertFileContent(t, workspace, "index.html", "<title>My site</title>")
+	if _, err := os.Stat(filepath.Join(workspace, templates.DescriptorFile)); !os.IsNotExist(err) {
+		t.Error("The descriptor file should not be copied")
+	}
+}
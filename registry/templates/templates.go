@@ -0,0 +1,148 @@
+package templates
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	pb "github.com/eogile/agilestack-core/proto"
+)
+
+/*
+ * Suffix of the files rendered with "text/template".
+ *
+ * The suffix is removed from the name of the rendered file. The files
+ * without this suffix are copied as is.
+ */
+const TemplateSuffix = ".tmpl"
+
+/*
+ * Values available in the templates.
+ *
+ * Example : {{.Name}} is served at {{.Url}}.
+ */
+type Data struct {
+	/*
+	 * Name of the plugin, as given by the requester.
+	 */
+	Name string
+
+	/*
+	 * Name of the Docker image of the plugin.
+	 */
+	ImageName string
+
+	Url string
+
+	Topics Topics
+
+	/*
+	 * Values of the template's parameters.
+	 */
+	Parameters map[string]string
+}
+
+/*
+ * NATS topics available in the templates.
+ */
+type Topics struct {
+	/*
+	 * Prefix of the topics owned by the plugin.
+	 */
+	Namespace string
+
+	ListAvailablePlugins string
+	ListInstalledPlugins string
+	InstallPlugin        string
+	UninstallPlugin      string
+	CreatePlugin         string
+}
+
+func NewData(name string, imageName string, url string) Data {
+	return Data{
+		Name:      name,
+		ImageName: imageName,
+		Url:       url,
+		Topics: Topics{
+			Namespace:            "plugin." + name,
+			ListAvailablePlugins: pb.ListAvailablePluginsTopic,
+			ListInstalledPlugins: pb.ListInstalledPluginsTopic,
+			InstallPlugin:        pb.InstallPluginTopic,
+			UninstallPlugin:      pb.UninstallPluginTopic,
+			CreatePlugin:         pb.CreatePlugin,
+		},
+		Parameters: make(map[string]string),
+	}
+}
+
+/*
+ * Copies the content of the template directory into the workspace
+ * directory, rendering the files suffixed by ".tmpl".
+ *
+ * The template's descriptor file is not copied.
+ *
+ * The workspace directory is created if it does not exist.
+ */
+func Render(templateDir string, workspace string, data Data) error {
+	return filepath.Walk(templateDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(templateDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(workspace, relativePath)
+
+		switch {
+		case relativePath == DescriptorFile:
+			return nil
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case strings.HasSuffix(path, TemplateSuffix):
+			return renderFile(path, strings.TrimSuffix(target, TemplateSuffix), info.Mode(), data)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode())
+		}
+
+		/*
+		 * Symbolic links and special files are skipped.
+		 */
+		return nil
+	})
+}
+
+func renderFile(source string, target string, mode os.FileMode, data Data) error {
+	tmpl, err := template.New(filepath.Base(source)).Option("missingkey=error").ParseFiles(source)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return tmpl.Execute(file, data)
+}
+
+func copyFile(source string, target string, mode os.FileMode) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	targetFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer targetFile.Close()
+
+	_, err = io.Copy(targetFile, sourceFile)
+	return err
+}
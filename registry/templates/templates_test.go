@@ -0,0 +1,102 @@
+package templates_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eogile/agilestack-core/registry/templates"
+)
+
+func TestRender(t *testing.T) {
+	templateDir := createTemplate(t, map[string]string{
+		"Dockerfile.tmpl":         "FROM scratch\nLABEL name={{.ImageName}}\n",
+		"src/index.js.tmpl":       "const url = '{{.Url}}';\nconst topic = '{{.Topics.Namespace}}.ping';\n",
+		"static/logo.png":         "\x89PNG{{.Name}}",
+		"README.md":               "Plugin {{.Name}}",
+		"src/components/.keep":    "",
+		"scripts/build.sh.tmpl":   "echo {{.Name}}",
+		"scripts/config.json.txt": "{}",
+	})
+	defer os.RemoveAll(templateDir)
+	os.Chmod(filepath.Join(templateDir, "scripts/build.sh.tmpl"), 0755)
+
+	workspace := tempDir(t)
+	defer os.RemoveAll(workspace)
+
+	data := templates.NewData("todo", "agilestack-todo", "/todo")
+	if err := templates.Render(templateDir, workspace, data); err != nil {
+		t.Fatalf("Error while rendering the template : %v", err)
+	}
+
+	assertFileContent(t, workspace, "Dockerfile", "FROM scratch\nLABEL name=agilestack-todo\n")
+	assertFileContent(t, workspace, "src/index.js", "const url = '/todo';\nconst topic = 'plugin.todo.ping';\n")
+	assertFileContent(t, workspace, "static/logo.png", "\x89PNG{{.Name}}")
+	assertFileContent(t, workspace, "README.md", "Plugin {{.Name}}")
+	assertFileContent(t, workspace, "src/components/.keep", "")
+	assertFileContent(t, workspace, "scripts/build.sh", "echo todo")
+	assertFileContent(t, workspace, "scripts/config.json.txt", "{}")
+
+	info, err := os.Stat(filepath.Join(workspace, "scripts/build.sh"))
+	if err != nil {
+		t.Fatalf("Error while reading the rendered script : %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("Invalid mode for the rendered script : %v", info.Mode())
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "Dockerfile.tmpl")); !os.IsNotExist(err) {
+		t.Error("The template files should not be copied")
+	}
+}
+
+func TestRenderUnknownPlaceholder(t *testing.T) {
+	templateDir := createTemplate(t, map[string]string{
+		"Dockerfile.tmpl": "FROM {{.BaseImage}}",
+	})
+	defer os.RemoveAll(templateDir)
+
+	workspace := tempDir(t)
+	defer os.RemoveAll(workspace)
+
+	data := templates.NewData("todo", "agilestack-todo", "/todo")
+	if err := templates.Render(templateDir, workspace, data); err == nil {
+		t.Error("Rendering a template with an unknown placeholder should fail")
+	}
+}
+
+/*
+ * Creates a template directory containing the given files.
+ */
+func createTemplate(t *testing.T, files map[string]string) string {
+	directory := tempDir(t)
+	for name, content := range files {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+func tempDir(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "agilestack-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return directory
+}
+
+func assertFileContent(t *testing.T, directory string, name string, expectedContent string) {
+	content, err := ioutil.ReadFile(filepath.Join(directory, name))
+	if err != nil {
+		t.Errorf("Error while reading %s : %v", name, err)
+		return
+	}
+	if string(content) != expectedContent {
+		t.Errorf("Invalid content for %s : %q", name, content)
+	}
+}
@@ -0,0 +1,263 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry/config"
+	"github.com/eogile/agilestack-core/registry/source"
+)
+
+const (
+	/*
+	 * Prefix of the name of the plugins' images.
+	 */
+	PLUGIN_IMAGE_PREFIX = "agilestack-"
+
+	/*
+	 * Maximum length of a Docker repository name.
+	 */
+	maxImageNameLength = 255
+)
+
+var (
+	/*
+	 * Path component of a Docker reference : lowercase alpha-numeric
+	 * parts separated by a period, one or two underscores, or dashes.
+	 */
+	pluginNameRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+
+	/*
+	 * Names that cannot be given to a new plugin, because they are used
+	 * by the platform itself.
+	 */
+	ReservedPluginNames = []string{"core", "backoffice"}
+)
+
+/*
+ * Invalid field of a request.
+ */
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (err *ValidationError) Error() string {
+	return err.Field + " : " + err.Message
+}
+
+/*
+ * Every invalid field of a request.
+ */
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return "Invalid request : " + strings.Join(messages, ", ")
+}
+
+/*
+ * Returns the errors as they are sent in the responses.
+ */
+func (errs ValidationErrors) Proto() []*pb.ValidationError {
+	list := make([]*pb.ValidationError, len(errs))
+	for i, err := range errs {
+		list[i] = &pb.ValidationError{Field: err.Field, Message: err.Message}
+	}
+	return list
+}
+
+/*
+ * Checks that the given name can be used as a plugin name : the image
+ * name built from it must be a valid Docker reference, and the name must
+ * not be reserved.
+ */
+func ValidatePluginName(name string) error {
+	if name == "" {
+		return errors.New("The name is required")
+	}
+	if len(PLUGIN_IMAGE_PREFIX)+len(name) > maxImageNameLength {
+		return fmt.Errorf("The name must not be longer than %d characters",
+			maxImageNameLength-len(PLUGIN_IMAGE_PREFIX))
+	}
+	if !pluginNameRegexp.MatchString(name) {
+		return errors.New("The name must contain lowercase letters and digits, " +
+			"separated by periods, underscores or dashes")
+	}
+	for _, reserved := range ReservedPluginNames {
+		if name == reserved {
+			return fmt.Errorf("The name %s is reserved", name)
+		}
+	}
+	return nil
+}
+
+/*
+ * Returns the directory given by a request, confined to the given root.
+ *
+ * Relative directories are relative to the root. The symbolic links are
+ * resolved before checking that the directory is inside the root, so that
+ * they cannot be used to escape from it.
+ */
+func ConfineDirectory(root string, directory string) (string, error) {
+	if root == "" {
+		return "", errors.New("No build directory is allowed")
+	}
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("Invalid build root : %v", err)
+	}
+
+	if !filepath.IsAbs(directory) {
+		directory = filepath.Join(root, directory)
+	}
+	resolved, err := filepath.EvalSymlinks(directory)
+	if err != nil {
+		return "", fmt.Errorf("The directory %s does not exist", directory)
+	}
+
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("The directory %s is not inside %s", directory, root)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", directory)
+	}
+	return resolved, nil
+}
+
+/*
+ * Schemes of the remote build contexts.
+ */
+var RemoteContextSchemes = []string{"https", "git"}
+
+/*
+ * Checks that a remote build context is downloaded from one of the given
+ * hosts, with one of the allowed schemes, so that the Docker daemon is not
+ * made to reach the internal services. When no host is given, the remote
+ * contexts are not allowed.
+ */
+func ValidateRemoteContext(remoteContext string, hosts []string) error {
+	if len(hosts) == 0 {
+		return errors.New("The remote build contexts are not allowed")
+	}
+	parsed, err := url.Parse(remoteContext)
+	if err != nil || parsed.Host == "" {
+		return errors.New("Invalid remote build context : " + remoteContext)
+	}
+
+	allowedScheme := false
+	for _, scheme := range RemoteContextSchemes {
+		allowedScheme = allowedScheme || parsed.Scheme == scheme
+	}
+	if !allowedScheme {
+		return fmt.Errorf("The remote build context must be given with one of the schemes %s",
+			strings.Join(RemoteContextSchemes, ", "))
+	}
+
+	host := parsed.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	for _, allowed := range hosts {
+		if strings.EqualFold(host, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("The remote build contexts cannot be downloaded from %s", host)
+}
+
+/*
+ * Checks every field of a plugin creation request. The remote build
+ * contexts must be downloaded from one of the given hosts.
+ *
+ * Returns "nil" if the request is valid.
+ */
+func ValidateNewPluginRequest(request *pb.NewPluginRequest, buildRoot string, remoteContextHosts []string) ValidationErrors {
+	var errs ValidationErrors
+	add := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, &ValidationError{Field: field, Message: err.Error()})
+		}
+	}
+
+	add("name", ValidatePluginName(request.Name))
+	if request.Directory != "" {
+		_, err := ConfineDirectory(buildRoot, request.Directory)
+		add("directory", err)
+	}
+	if request.BuildId != "" {
+		add("buildId", ValidateBuildID(request.BuildId))
+	}
+	add("source", checkSingleSource(request))
+	if request.GitUrl != "" {
+		add("gitUrl", source.ValidateGitURL(request.GitUrl))
+	}
+	if request.RemoteContext != "" {
+		add("remoteContext", ValidateRemoteContext(request.RemoteContext, remoteContextHosts))
+	}
+	if request.Tag != "" {
+		add("tag", ValidateImageTag(request.Tag))
+	}
+	for _, tag := range request.ExtraTags {
+		add("extraTags", ValidateImageTag(tag))
+	}
+	for name := range request.BuildArgs {
+		add("buildArgs", ValidateBuildArgName(name))
+	}
+	add("configuration", config.Validate(config.New(request.Name, request.Url, request.Configuration)))
+	return errs
+}
+
+/*
+ * Checks that an installation request targets a plugin.
+ *
+ * Returns "nil" if the request is valid.
+ */
+func ValidateInstallRequest(request *pb.InstallPluginRequest) ValidationErrors {
+	if request.Plugin == nil {
+		return ValidationErrors{{Field: "plugin", Message: "The plugin is required"}}
+	}
+	return validateTargetedPlugin("plugin.name", request.Plugin.Name)
+}
+
+/*
+ * Checks that an uninstallation request names a plugin.
+ *
+ * Returns "nil" if the request is valid.
+ */
+func ValidateUninstallRequest(plugin *pb.Plugin) ValidationErrors {
+	return validateTargetedPlugin("name", plugin.Name)
+}
+
+/*
+ * Checks that a deletion request names a plugin whose name, without the
+ * prefix of the plugins' images, is valid and not reserved.
+ *
+ * Returns "nil" if the request is valid.
+ */
+func ValidateDeleteRequest(request *pb.DeletePluginRequest) ValidationErrors {
+	if err := ValidatePluginName(strings.TrimPrefix(request.Name, PLUGIN_IMAGE_PREFIX)); err != nil {
+		return ValidationErrors{{Field: "name", Message: err.Error()}}
+	}
+	return nil
+}
+
+func validateTargetedPlugin(field string, name string) ValidationErrors {
+	if name == "" {
+		return ValidationErrors{{Field: field, Message: "The name of the plugin is required"}}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package registry_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "github.com/eogile/agilestack-core/proto"
+	"github.com/eogile/agilestack-core/registry"
+)
+
+func TestValidatePluginName(t *testing.T) {
+	validNames := []string{"plugin", "my-plugin", "my--plugin", "my_plugin", "my__plugin", "my.plugin", "plugin2"}
+	for _, name := range validNames {
+		if err := registry.ValidatePluginName(name); err != nil {
+			t.Errorf("The name %s should be valid : %v", name, err)
+		}
+	}
+
+	invalidNames := []string{"", "Plugin", "-plugin", "plugin-", "my___plugin", "my..plugin",
+		"my/plugin", "../plugin", "my plugin", "core", "backoffice", strings.Repeat("a", 250)}
+	for _, name := range invalidNames {
+		if err := registry.ValidatePluginName(name); err == nil {
+			t.Errorf("The name %q should be invalid", name)
+		}
+	}
+}
+
+func TestConfineDirectory(t *testing.T) {
+	root, outside := createBuildDirectories(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	directory, err := registry.ConfineDirectory(root, filepath.Join(root, "plugin"))
+	if err != nil || directory != filepath.Join(root, "plugin") {
+		t.Errorf("Invalid absolute directory : %s, %v", directory, err)
+	}
+
+	directory, err = registry.ConfineDirectory(root, "plugin")
+	if err != nil || directory != filepath.Join(root, "plugin") {
+		t.Errorf("Invalid relative directory : %s, %v", directory, err)
+	}
+
+	rejected := []string{
+		outside,
+		"../outside",
+		filepath.Join(root, "plugin", "..", "..", "outside"),
+		filepath.Join(root, "link"),
+		filepath.Join(root, "missing"),
+		filepath.Join(root, "plugin", "Dockerfile"),
+	}
+	for _, directory := range rejected {
+		if _, err := registry.ConfineDirectory(root, directory); err == nil {
+			t.Errorf("The directory %s should be rejected", directory)
+		}
+	}
+
+	if _, err := registry.ConfineDirectory("", filepath.Join(root, "plugin")); err == nil {
+		t.Error("No directory should be allowed without a root")
+	}
+}
+
+func TestValidateNewPluginRequest(t *testing.T) {
+	root, outside := createBuildDirectories(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	request := &pb.NewPluginRequest{Name: "plugin", Directory: "plugin", BuildId: "build-1"}
+	if errs := registry.ValidateNewPluginRequest(request, root, nil); errs != nil {
+		t.Errorf("The request should be valid : %v", errs)
+	}
+
+	request = &pb.NewPluginRequest{
+		Name:      "core",
+		Directory: outside,
+		BuildId:   "build.1",
+		GitUrl:    "https://github.com/eogile/plugin.git",
+	}
+	errs := registry.ValidateNewPluginRequest(request, root, nil)
+	fields := make([]string, len(errs))
+	for i, err := range errs.Proto() {
+		fields[i] = err.Field
+	}
+	if strings.Join(fields, ",") != "name,directory,buildId,source" {
+		t.Errorf("Invalid fields : %v", fields)
+	}
+
+	request = &pb.NewPluginRequest{
+		Name:      "plugin",
+		Tag:       "1.0:beta",
+		ExtraTags: []string{"stable", "-latest"},
+		BuildArgs: map[string]string{"VERSION": "1.0", "MY-ARG": "value"},
+	}
+	errs = registry.ValidateNewPluginRequest(request, root, nil)
+	fields = make([]string, len(errs))
+	for i, err := range errs.Proto() {
+		fields[i] = err.Field
+	}
+	if strings.Join(fields, ",") != "tag,extraTags,buildArgs" {
+		t.Errorf("Invalid fields : %v", fields)
+	}
+}
+
+func TestValidatePluginSources(t *testing.T) {
+	hosts := []string{"contexts.example.com"}
+	for _, url := range []string{
+		"https://github.com/eogile/plugin.git",
+		"ssh://git@github.com/eogile/plugin.git",
+		"git://github.com/eogile/plugin.git",
+		"git@github.com:eogile/plugin.git",
+	} {
+		request := &pb.NewPluginRequest{Name: "plugin", GitUrl: url}
+		if errs := registry.ValidateNewPluginRequest(request, "", hosts); errs != nil {
+			t.Errorf("The repository %s should be valid : %v", url, errs)
+		}
+	}
+	for _, url := range []string{
+		"/var/lib/repositories/plugin.git",
+		"../plugin.git",
+		"file:///var/lib/repositories/plugin.git",
+		"ext::sh -c touch% /tmp/pwned",
+		"http://github.com/eogile/plugin.git",
+		"ssh://-oProxyCommand=touch/plugin.git",
+	} {
+		request := &pb.NewPluginRequest{Name: "plugin", GitUrl: url}
+		if errs := registry.ValidateNewPluginRequest(request, "", hosts); len(errs) != 1 {
+			t.Errorf("The repository %s should be rejected : %v", url, errs)
+		}
+	}
+
+	for _, url := range []string{
+		"https://contexts.example.com/plugin.tar.gz",
+		"https://CONTEXTS.example.com:8443/plugin.tar.gz",
+		"git://contexts.example.com/plugin.git",
+	} {
+		request := &pb.NewPluginRequest{Name: "plugin", RemoteContext: url}
+		if errs := registry.ValidateNewPluginRequest(request, "", hosts); errs != nil {
+			t.Errorf("The remote context %s should be valid : %v", url, errs)
+		}
+	}
+	for _, url := range []string{
+		"https://169.254.169.254/latest/meta-data",
+		"http://contexts.example.com/plugin.tar.gz",
+		"https://contexts.example.com.evil.com/plugin.tar.gz",
+		"contexts.example.com/plugin.tar.gz",
+	} {
+		request := &pb.NewPluginRequest{Name: "plugin", RemoteContext: url}
+		if errs := registry.ValidateNewPluginRequest(request, "", hosts); len(errs) != 1 {
+			t.Errorf("The remote context %s should be rejected : %v", url, errs)
+		}
+	}
+
+	request := &pb.NewPluginRequest{Name: "plugin", RemoteContext: "https://contexts.example.com/plugin.tar.gz"}
+	if errs := registry.ValidateNewPluginRequest(request, "", nil); len(errs) != 1 {
+		t.Errorf("The remote contexts should be forbidden without allowed hosts : %v", errs)
+	}
+}
+
+/*
+ * Creates a build root containing a plugin's directory and a symbolic
+ * link to a directory outside the root.
+ */
+func createBuildDirectories(t *testing.T) (string, string) {
+	parent, err := ioutil.TempDir("", "agilestack-validation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, _ = filepath.EvalSymlinks(parent)
+	root := filepath.Join(parent, "root")
+	outside := filepath.Join(parent, "outside")
+	os.MkdirAll(filepath.Join(root, "plugin"), 0755)
+	os.MkdirAll(outside, 0755)
+	ioutil.WriteFile(filepath.Join(root, "plugin", "Dockerfile"), []byte("FROM scratch\n"), 0644)
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return root, outside
+}